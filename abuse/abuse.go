@@ -0,0 +1,115 @@
+// Package abuse bans clients for a while once too many of their requests
+// have failed, which is typical of scanners probing for paths that do not
+// exist and of scrapers running into errors.
+package abuse
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"lerproxy.mleku.dev/stats"
+)
+
+// Tracker counts the error responses to each client address within Window,
+// banning an address for Ban once it has had Threshold of them.
+type Tracker struct {
+	Threshold int
+	Window    time.Duration
+	Ban       time.Duration
+	mx        sync.Mutex
+	clients   map[S]*client
+	swept     time.Time
+}
+
+type client struct {
+	// errors in the window starting at since.
+	errors int
+	since  time.Time
+	banned time.Time
+}
+
+// Handler answers the requests of banned clients with 403 Forbidden,
+// passing the others to h and counting those answered with a 4xx or 5xx
+// status.
+func (t *Tracker) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if t.Banned(ip) {
+			http.Error(w, http.StatusText(http.StatusForbidden),
+				http.StatusForbidden)
+			return
+		}
+		rw := &stats.Writer{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+		if rw.Status() >= 400 {
+			t.Fail(ip)
+		}
+	})
+}
+
+// Banned reports whether ip is banned.
+func (t *Tracker) Banned(ip S) bool {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	c, ok := t.clients[ip]
+	return ok && time.Now().Before(c.banned)
+}
+
+// Fail counts an error response to ip, banning it if that reaches the
+// Threshold.
+func (t *Tracker) Fail(ip S) {
+	now := time.Now()
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.sweep(now)
+	if t.clients == nil {
+		t.clients = make(map[S]*client)
+	}
+	c, ok := t.clients[ip]
+	if !ok {
+		c = &client{since: now}
+		t.clients[ip] = c
+	}
+	if now.Sub(c.since) > t.Window {
+		c.errors, c.since = 0, now
+	}
+	if c.errors++; c.errors >= t.Threshold {
+		log.W.F("banning %s for %v after %d error responses within %v",
+			ip, t.Ban, c.errors, t.Window)
+		// counting starts over once the ban is over.
+		c.banned = now.Add(t.Ban)
+		c.errors, c.since = 0, c.banned
+	}
+}
+
+// Bans is the number of addresses currently banned.
+func (t *Tracker) Bans() (n int) {
+	now := time.Now()
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, c := range t.clients {
+		if now.Before(c.banned) {
+			n++
+		}
+	}
+	return
+}
+
+// sweep forgets the clients whose window and ban are over, at most once a
+// Window, so that the map does not grow with every address ever seen.
+func (t *Tracker) sweep(now time.Time) {
+	if now.Sub(t.swept) < t.Window {
+		return
+	}
+	t.swept = now
+	for ip, c := range t.clients {
+		if now.Sub(c.since) > t.Window && now.After(c.banned) {
+			delete(t.clients, ip)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package abuse
+
+import (
+	"bytes"
+
+	"ec.mleku.dev/v2/lol"
+	"lerproxy.mleku.dev/logging"
+)
+
+type (
+	B = []byte
+	S = string
+	E = error
+)
+
+var (
+	log, _, errorf = lol.New(logging.Writer)
+	_, chk, _      = lol.New(logging.Checks)
+	equals         = bytes.Equal
+)
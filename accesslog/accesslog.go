@@ -0,0 +1,61 @@
+// Package accesslog logs a line of key=value fields for each request served.
+package accesslog
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"lerproxy.mleku.dev/stats"
+)
+
+// Handler logs the requests served by Handler.
+type Handler struct {
+	http.Handler
+	// TLS adds the negotiated TLS version, cipher suite, server name (SNI)
+	// and application protocol (ALPN) of requests made over TLS.
+	TLS bool
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rw := &stats.Writer{ResponseWriter: w}
+	h.Handler.ServeHTTP(rw, r)
+	log.I.Ln(Line(r, rw.Status(), time.Since(start), h.TLS))
+}
+
+// Line formats the fields of a request that was answered with status after
+// d.
+func Line(r *http.Request, status int, d time.Duration, withTLS bool) S {
+	var b strings.Builder
+	field(&b, "remote", r.RemoteAddr)
+	field(&b, "host", r.Host)
+	field(&b, "method", r.Method)
+	field(&b, "path", r.URL.RequestURI())
+	field(&b, "proto", r.Proto)
+	field(&b, "status", strconv.Itoa(status))
+	field(&b, "dur", d.String())
+	if withTLS && r.TLS != nil {
+		field(&b, "tls", tls.VersionName(r.TLS.Version))
+		field(&b, "cipher", tls.CipherSuiteName(r.TLS.CipherSuite))
+		field(&b, "sni", r.TLS.ServerName)
+		field(&b, "alpn", r.TLS.NegotiatedProtocol)
+	}
+	return b.String()
+}
+
+// field appends key=value, quoting values that are empty or contain spaces
+// or quotes so that lines can be split on spaces.
+func field(b *strings.Builder, key, value S) {
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(key)
+	b.WriteByte('=')
+	if value == "" || strings.ContainsAny(value, " \"=") {
+		value = strconv.Quote(value)
+	}
+	b.WriteString(value)
+}
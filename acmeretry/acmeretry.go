@@ -0,0 +1,195 @@
+// Package acmeretry retries obtaining certificates from an ACME CA when it
+// fails transiently, so that a brief outage of the CA does not fail the
+// handshakes of the first clients of a host.
+package acmeretry
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Issuer obtains certificates, such as an autocert.Manager.
+type Issuer interface {
+	TLSConfig() *tls.Config
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// Retrier is an Issuer that retries transient failures of the Issuer it
+// wraps with exponential backoff.
+type Retrier struct {
+	Issuer
+	// Timeout bounds the time spent retrying for one request. If zero,
+	// failures are not retried.
+	Timeout time.Duration
+	// Backoff is the delay before the first retry, doubled after each,
+	// 1s if zero.
+	Backoff time.Duration
+	// NegativeTTL is how long a failure to obtain a certificate for a host
+	// is returned again without asking the CA.
+	NegativeTTL time.Duration
+
+	mx      sync.Mutex
+	failed  map[S]failure
+	issuing map[S]*issuing
+}
+
+// issuing counts the handshakes waiting for a certificate for a host, and
+// since when the first of them has.
+type issuing struct {
+	n     int
+	since time.Time
+}
+
+// slow is how long obtaining a certificate must have been going on to be
+// reported by Issuing, which rules out certificates found in the cache.
+const slow = time.Second
+
+type failure struct {
+	err   E
+	until time.Time
+}
+
+// GetCertificate obtains the certificate for the SNI of hello, retrying
+// transient errors until Timeout has passed or the handshake is abandoned.
+func (r *Retrier) GetCertificate(hello *tls.ClientHelloInfo) (
+	cert *tls.Certificate, err E) {
+
+	name := strings.ToLower(hello.ServerName)
+	if err = r.cached(name); err != nil {
+		return
+	}
+	r.start(name)
+	defer r.done(name)
+	ctx := hello.Context()
+	if ctx == nil {
+		// hellos made up to prefetch certificates have no context.
+		ctx = context.Background()
+	}
+	deadline := time.Now().Add(r.Timeout)
+	backoff := r.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		if cert, err = r.Issuer.GetCertificate(hello); err == nil {
+			if attempt > 1 {
+				log.I.F("obtained certificate for %s after %d attempts",
+					name, attempt)
+			}
+			return
+		}
+		if !Transient(err) {
+			if isACME(err) {
+				r.remember(name, err)
+			}
+			return
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			log.E.F("giving up obtaining certificate for %s after %d "+
+				"attempts: %v", name, attempt, err)
+			r.remember(name, err)
+			return
+		}
+		log.W.F("attempt %d to obtain certificate for %s failed, retrying "+
+			"in %v: %v", attempt, name, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Issuing reports whether a certificate for name has been waited for by a
+// handshake for a while, which means it is being obtained from the CA.
+func (r *Retrier) Issuing(name S) bool {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	i, ok := r.issuing[strings.ToLower(name)]
+	return ok && time.Since(i.since) > slow
+}
+
+func (r *Retrier) start(name S) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.issuing == nil {
+		r.issuing = make(map[S]*issuing)
+	}
+	i, ok := r.issuing[name]
+	if !ok {
+		i = &issuing{since: time.Now()}
+		r.issuing[name] = i
+	}
+	i.n++
+}
+
+func (r *Retrier) done(name S) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	i := r.issuing[name]
+	if i.n--; i.n == 0 {
+		delete(r.issuing, name)
+	}
+}
+
+// cached returns the recent failure for name, if any.
+func (r *Retrier) cached(name S) (err E) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	f, ok := r.failed[name]
+	if !ok {
+		return
+	}
+	if time.Now().After(f.until) {
+		delete(r.failed, name)
+		return
+	}
+	return f.err
+}
+
+func (r *Retrier) remember(name S, err E) {
+	if r.NegativeTTL <= 0 {
+		return
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.failed == nil {
+		r.failed = make(map[S]failure)
+	}
+	r.failed[name] = failure{err: err, until: time.Now().Add(r.NegativeTTL)}
+}
+
+// Transient reports whether err is likely to go away if the request is
+// repeated shortly: server errors of the CA, and timeouts and broken
+// connections to it. Rate limits are not transient, they last hours.
+func Transient(err E) bool {
+	var ae *acme.Error
+	if errors.As(err, &ae) {
+		return ae.StatusCode >= 500
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isACME reports whether err is an error response of the CA, as opposed to
+// a host being refused by the host policy, which is cheap to repeat.
+func isACME(err E) bool {
+	var ae *acme.Error
+	return errors.As(err, &ae)
+}
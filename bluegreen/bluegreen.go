@@ -0,0 +1,101 @@
+// Package bluegreen splits the traffic of a host between two backends, blue
+// and green, so a new version can be brought up beside the old one, tried
+// with a share of the requests and then switched to without downtime.
+package bluegreen
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"lerproxy.mleku.dev/routelog"
+)
+
+// Group names one of the two backends of a host.
+type Group S
+
+const (
+	Blue  Group = "blue"
+	Green Group = "green"
+)
+
+// ParseGroup reads a group name.
+func ParseGroup(s S) (g Group, err E) {
+	switch g = Group(strings.ToLower(s)); g {
+	case Blue, Green:
+		return
+	}
+	err = fmt.Errorf("unknown group %q, want blue or green", s)
+	return
+}
+
+// Split is the share of requests a host sends to its green backend, in
+// percent. Blue gets the rest.
+type Split struct {
+	green atomic.Int32
+}
+
+// Set sends percent of the requests to the group to, and the rest to the
+// other one.
+func (s *Split) Set(to Group, percent int) (err E) {
+	if percent < 0 || percent > 100 {
+		err = fmt.Errorf("share of %d%% is not between 0 and 100", percent)
+		return
+	}
+	if to == Blue {
+		percent = 100 - percent
+	}
+	s.green.Store(int32(percent))
+	return
+}
+
+// Green returns the percentage of requests sent to green.
+func (s *Split) Green() int { return int(s.green.Load()) }
+
+// pick chooses the group of a request.
+func (s *Split) pick() Group {
+	if g := s.green.Load(); g > 0 && rand.Int32N(100) < g {
+		return Green
+	}
+	return Blue
+}
+
+// Splits are the splits of the hosts with a green backend. They outlive the
+// handlers, so a switch is kept when the mapping is reloaded.
+type Splits struct {
+	mx     sync.Mutex
+	splits map[S]*Split
+}
+
+// For returns the split of host, all blue for a host not seen before.
+func (s *Splits) For(host S) (sp *Split) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.splits == nil {
+		s.splits = make(map[S]*Split)
+	}
+	if sp = s.splits[host]; sp == nil {
+		sp = &Split{}
+		s.splits[host] = sp
+	}
+	return
+}
+
+// Handler sends each request to Blue or Green as Split says.
+type Handler struct {
+	Blue, Green http.Handler
+	Split       *Split
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Split.pick() == Green {
+		routelog.Add(r.Context(), "split chose green")
+		h.Green.ServeHTTP(w, r)
+		return
+	}
+	routelog.Add(r.Context(), "split chose blue")
+	h.Blue.ServeHTTP(w, r)
+}
@@ -0,0 +1,99 @@
+// Package cachepolicy sets the Cache-Control and Expires headers of static
+// files by their path, such as a long lifetime for hashed assets and no-cache
+// for index.html.
+package cachepolicy
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule gives the Cache-Control of the paths matching Pattern.
+type Rule struct {
+	// Pattern is matched with path.Match against the request path, or
+	// against its last element if it has no slash, so "*.js" matches all
+	// scripts.
+	Pattern S
+	// CacheControl is the value of the Cache-Control header.
+	CacheControl S
+}
+
+// Match reports whether the rule applies to the request path p. Directories
+// match as their index.html, which is what is served for them.
+func (r Rule) Match(p S) bool {
+	if strings.HasSuffix(p, "/") {
+		p += "index.html"
+	}
+	if !strings.Contains(r.Pattern, "/") {
+		p = path.Base(p)
+	}
+	ok, _ := path.Match(r.Pattern, p)
+	return ok
+}
+
+// Rules maps hostnames to their rules, in the order they were given.
+type Rules map[S][]Rule
+
+// Parse reads rules in the form "example.com:/assets/*:public, max-age=31536000".
+func (r Rules) Parse(specs []S) (err E) {
+	for _, spec := range specs {
+		host, rest, _ := strings.Cut(spec, ":")
+		host = strings.ToLower(host)
+		pattern, value, ok := strings.Cut(rest, ":")
+		value = strings.TrimSpace(value)
+		if host == "" || pattern == "" || value == "" || !ok {
+			err = fmt.Errorf("invalid cache control parameter format: `%s`",
+				spec)
+			return
+		}
+		if _, err = path.Match(pattern, ""); err != nil {
+			err = fmt.Errorf("invalid cache control pattern `%s`: %w",
+				pattern, err)
+			return
+		}
+		r[host] = append(r[host], Rule{Pattern: pattern, CacheControl: value})
+	}
+	return
+}
+
+// Handler sets the headers of the first of Rules matching the request path
+// on the responses of Handler.
+type Handler struct {
+	http.Handler
+	Rules []Rule
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, rule := range h.Rules {
+		if rule.Match(r.URL.Path) {
+			Set(w.Header(), rule.CacheControl, time.Now())
+			break
+		}
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// Set sets Cache-Control to cc, and Expires to match its max-age relative to
+// now, or to the past if cc forbids using a stored response without
+// revalidating it, for HTTP/1.0 caches.
+func Set(h http.Header, cc S, now time.Time) {
+	h.Set("Cache-Control", cc)
+	for _, d := range strings.Split(cc, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		switch strings.ToLower(name) {
+		case "max-age":
+			if secs, err := strconv.Atoi(value); err == nil {
+				h.Set("Expires", now.Add(time.Duration(secs)*time.Second).
+					UTC().Format(http.TimeFormat))
+				return
+			}
+		case "no-cache", "no-store":
+			h.Set("Expires", "0")
+			return
+		}
+	}
+}
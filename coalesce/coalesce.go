@@ -0,0 +1,152 @@
+// Package coalesce sends identical GET requests to a backend that are in
+// flight at the same time as one, sharing its response, so that a burst of
+// requests for the same page, such as after a cache expired, does not reach
+// a slow backend all at once.
+package coalesce
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/sync/singleflight"
+
+	"lerproxy.mleku.dev/routelog"
+)
+
+// Transport is a RoundTripper coalescing the GET requests without
+// credentials or a Range that are identical in their URL and the headers
+// that select a representation. Responses that set a cookie or are private,
+// with a body larger than MaxSize or streamed as server-sent events, are not
+// shared: the first request gets it and the others are sent on their own.
+type Transport struct {
+	http.RoundTripper
+	MaxSize int64
+	group   singleflight.Group
+}
+
+// shared is the response of a coalesced request. stream is set instead of
+// body when it could not be shared.
+type shared struct {
+	res    *http.Response
+	body   B
+	stream io.ReadCloser
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (res *http.Response, err E) {
+	rt := t.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	if !coalescable(req) {
+		return rt.RoundTrip(req)
+	}
+	var first bool
+	ch := t.group.DoChan(key(req), func() (v any, err E) {
+		first = true
+		// the others waiting for it must not fail with this client leaving.
+		r := req.WithContext(context.WithoutCancel(req.Context()))
+		var res *http.Response
+		if res, err = rt.RoundTrip(r); err != nil {
+			return
+		}
+		return t.read(res)
+	})
+	var result singleflight.Result
+	select {
+	case result = <-ch:
+	case <-req.Context().Done():
+		go func() {
+			// a response only this request could have gets no reader.
+			if r := <-ch; r.Err == nil && first {
+				if s := r.Val.(*shared); s.stream != nil {
+					s.stream.Close()
+				}
+			}
+		}()
+		return nil, req.Context().Err()
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+	s := result.Val.(*shared)
+	if s.stream != nil {
+		if first {
+			res = s.res
+			res.Body = s.stream
+			return
+		}
+		return rt.RoundTrip(req)
+	}
+	if !first {
+		routelog.Add(req.Context(), "shared the response of an identical "+
+			"request in flight")
+	}
+	res = new(http.Response)
+	*res = *s.res
+	res.Header = s.res.Header.Clone()
+	res.Trailer = s.res.Trailer.Clone()
+	res.Body = io.NopCloser(bytes.NewReader(s.body))
+	res.ContentLength = int64(len(s.body))
+	res.Request = req
+	return
+}
+
+// read reads the body of res to share it, unless it is too large or
+// streamed.
+func (t *Transport) read(res *http.Response) (s *shared, err E) {
+	s = &shared{res: res}
+	cc := res.Header.Get("Cache-Control")
+	if res.ContentLength > t.MaxSize ||
+		res.Header.Get("Content-Type") == "text/event-stream" ||
+		len(res.Header.Values("Set-Cookie")) > 0 ||
+		strings.Contains(cc, "private") || strings.Contains(cc, "no-store") {
+
+		s.stream = res.Body
+		return
+	}
+	var body B
+	body, err = io.ReadAll(io.LimitReader(res.Body, t.MaxSize+1))
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	if int64(len(body)) > t.MaxSize {
+		log.D.F("not sharing the response to %s, larger than %d bytes",
+			res.Request.URL, t.MaxSize)
+		s.stream = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+		return
+	}
+	res.Body.Close()
+	s.body = body
+	return
+}
+
+// coalescable reports whether req may share the response of another: a GET
+// without a body that carries no credentials, whose response would be
+// private, and does not ask for part of the resource.
+func coalescable(req *http.Request) bool {
+	if req.Method != http.MethodGet ||
+		(req.Body != nil && req.Body != http.NoBody) {
+		return false
+	}
+	for _, h := range []S{"Authorization", "Cookie", "Range"} {
+		if req.Header.Get(h) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// key identifies the requests that get the same response.
+func key(req *http.Request) S {
+	return req.Host + " " + req.URL.String() +
+		" " + req.Header.Get("Accept") +
+		" " + req.Header.Get("Accept-Encoding") +
+		" " + req.Header.Get("Accept-Language")
+}
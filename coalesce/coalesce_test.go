@@ -0,0 +1,147 @@
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// backend counts its requests and answers them with "page" once release is
+// closed.
+type backend struct {
+	hits    atomic.Int32
+	hit     chan struct{}
+	release chan struct{}
+	cookie  bool
+}
+
+func (b *backend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.hits.Add(1)
+	select {
+	case b.hit <- struct{}{}:
+	default:
+	}
+	<-b.release
+	if b.cookie {
+		w.Header().Set("Set-Cookie", "session=1")
+	}
+	io.WriteString(w, "page")
+}
+
+func newBackend(t *testing.T, cookie bool) (b *backend, tr *Transport,
+	url S) {
+
+	b = &backend{hit: make(chan struct{}, 1), release: make(chan struct{}),
+		cookie: cookie}
+	srv := httptest.NewServer(b)
+	t.Cleanup(srv.Close)
+	return b, &Transport{RoundTripper: srv.Client().Transport,
+		MaxSize: 1 << 20}, srv.URL
+}
+
+// get sends n identical requests with header set, releasing the backend
+// once they have had time to join the first, and returns their bodies.
+func get(t *testing.T, b *backend, rt http.RoundTripper, url S, n int,
+	header http.Header) (bodies []S) {
+
+	t.Helper()
+	bodies = make([]S, n)
+	var wg sync.WaitGroup
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest(http.MethodGet, url+"/page", nil)
+			for k, v := range header {
+				req.Header[k] = v
+			}
+			res, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+			bodies[i] = S(body)
+		}()
+	}
+	<-b.hit
+	time.Sleep(100 * time.Millisecond)
+	close(b.release)
+	wg.Wait()
+	return
+}
+
+func TestCoalesce(t *testing.T) {
+	b, rt, url := newBackend(t, false)
+	for i, body := range get(t, b, rt, url, 10, nil) {
+		if body != "page" {
+			t.Errorf("request %d got %q", i, body)
+		}
+	}
+	if n := b.hits.Load(); n != 1 {
+		t.Errorf("backend got %d requests, want 1", n)
+	}
+}
+
+func TestNotShared(t *testing.T) {
+	for _, tc := range []struct {
+		name   S
+		cookie bool
+		header http.Header
+	}{
+		{"set-cookie response", true, nil},
+		{"authorization request", false,
+			http.Header{"Authorization": {"Bearer secret"}}},
+		{"cookie request", false, http.Header{"Cookie": {"session=1"}}},
+	} {
+		b, rt, url := newBackend(t, tc.cookie)
+		for i, body := range get(t, b, rt, url, 5, tc.header) {
+			if body != "page" {
+				t.Errorf("%s: request %d got %q", tc.name, i, body)
+			}
+		}
+		if n := b.hits.Load(); n != 5 {
+			t.Errorf("%s: backend got %d requests, want 5", tc.name, n)
+		}
+	}
+}
+
+func TestCancelFirst(t *testing.T) {
+	b, rt, url := newBackend(t, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url+"/page",
+		nil)
+	firstErr := make(chan E, 1)
+	go func() {
+		res, err := rt.RoundTrip(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		firstErr <- err
+	}()
+	<-b.hit
+	// the others join the request of the first before it leaves.
+	b.hit <- struct{}{}
+	done := make(chan []S)
+	go func() { done <- get(t, b, rt, url, 5, nil) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("first request got %v, want it canceled", err)
+	}
+	for i, body := range <-done {
+		if body != "page" {
+			t.Errorf("request %d got %q", i, body)
+		}
+	}
+	if n := b.hits.Load(); n != 1 {
+		t.Errorf("backend got %d requests, want 1", n)
+	}
+}
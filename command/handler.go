@@ -0,0 +1,139 @@
+// Package command implements a CGI-like backend that runs an executable for
+// each request, passing the request through the environment and stdin and
+// streaming the process' stdout back as the response.
+package command
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler runs Path once per request.
+//
+// The script writes CGI style output: a block of headers terminated by an
+// empty line, followed by the body. A "Status" header sets the response
+// status code, otherwise it is 200.
+type Handler struct {
+	// Path is the absolute path of the executable to run.
+	Path S
+	// Timeout bounds how long the process may run. Zero means no limit.
+	Timeout time.Duration
+	// MaxOutput bounds the number of bytes read from the process' stdout,
+	// including headers. Zero means no limit.
+	MaxOutput int64
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.Path)
+	cmd.Env = env(r)
+	cmd.Stdin = r.Body
+	cmd.Stderr = os.Stderr
+	var err E
+	var stdout io.ReadCloser
+	if stdout, err = cmd.StdoutPipe(); chk.E(err) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	if err = cmd.Start(); chk.E(err) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	out := io.Reader(stdout)
+	if h.MaxOutput > 0 {
+		out = &io.LimitedReader{R: stdout, N: h.MaxOutput}
+	}
+	br := bufio.NewReader(out)
+	var hdr textproto.MIMEHeader
+	if hdr, err = textproto.NewReader(br).ReadMIMEHeader(); chk.E(err) {
+		log.E.F("invalid headers from %s: %v", h.Path, err)
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		_ = cmd.Process.Kill()
+		chk.T(cmd.Wait())
+		return
+	}
+	status := http.StatusOK
+	if st := hdr.Get("Status"); st != "" {
+		hdr.Del("Status")
+		// the status line is a code optionally followed by a reason phrase.
+		code, _, _ := strings.Cut(st, " ")
+		if status, err = strconv.Atoi(code); err != nil ||
+			status < 100 || status > 999 {
+			log.E.F("invalid status %q from %s", st, h.Path)
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+			_ = cmd.Process.Kill()
+			chk.T(cmd.Wait())
+			return
+		}
+	}
+	for k, v := range hdr {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(status)
+	if _, err = io.Copy(w, br); err != nil {
+		log.D.F("copying output of %s: %v", h.Path, err)
+	}
+	if lr, ok := out.(*io.LimitedReader); ok && lr.N <= 0 {
+		log.W.F("output of %s exceeded %d bytes, truncating", h.Path,
+			h.MaxOutput)
+		_ = cmd.Process.Kill()
+	}
+	if err = cmd.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			log.W.F("%s timed out after %v", h.Path, h.Timeout)
+			return
+		}
+		log.D.F("%s exited: %v", h.Path, err)
+	}
+}
+
+// env builds the CGI environment for the request.
+func env(r *http.Request) (e []S) {
+	e = []S{
+		"GATEWAY_INTERFACE=CGI/1.1",
+		"SERVER_PROTOCOL=" + r.Proto,
+		"SERVER_NAME=" + r.Host,
+		"REQUEST_METHOD=" + r.Method,
+		"REQUEST_URI=" + r.URL.RequestURI(),
+		"PATH_INFO=" + r.URL.Path,
+		"QUERY_STRING=" + r.URL.RawQuery,
+		"PATH=" + os.Getenv("PATH"),
+	}
+	// REMOTE_ADDR is the address alone, as CGI defines it.
+	if host, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		e = append(e, "REMOTE_ADDR="+host, "REMOTE_PORT="+port)
+	} else {
+		e = append(e, "REMOTE_ADDR="+r.RemoteAddr)
+	}
+	if r.ContentLength >= 0 {
+		e = append(e, fmt.Sprintf("CONTENT_LENGTH=%d", r.ContentLength))
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		e = append(e, "CONTENT_TYPE="+ct)
+	}
+	for k, v := range r.Header {
+		k = strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		// these are passed without the HTTP_ prefix, and proxy headers must
+		// not be able to set HTTP_PROXY for the child.
+		if k == "CONTENT_TYPE" || k == "CONTENT_LENGTH" || k == "PROXY" {
+			continue
+		}
+		e = append(e, "HTTP_"+k+"="+strings.Join(v, ", "))
+	}
+	return
+}
@@ -0,0 +1,34 @@
+package command
+
+import (
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEnvRemoteAddr(t *testing.T) {
+	for _, tc := range []struct {
+		remote S
+		want   []S
+	}{
+		{"192.0.2.1:1234", []S{"REMOTE_ADDR=192.0.2.1", "REMOTE_PORT=1234"}},
+		{"[2001:db8::1]:443", []S{"REMOTE_ADDR=2001:db8::1",
+			"REMOTE_PORT=443"}},
+		{"@", []S{"REMOTE_ADDR=@"}},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remote
+		e := env(r)
+		for _, want := range tc.want {
+			if !slices.Contains(e, want) {
+				t.Errorf("%s: no %s in %q", tc.remote, want, e)
+			}
+		}
+		if len(tc.want) == 1 && slices.ContainsFunc(e, func(v S) bool {
+			return strings.HasPrefix(v, "REMOTE_PORT=")
+		}) {
+			t.Errorf("%s: REMOTE_PORT set", tc.remote)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package compression compresses backend responses for clients that accept
+// it, with zstd or gzip as negotiated by Accept-Encoding.
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoder is a content coding responses can be compressed with.
+type Encoder struct {
+	// Name is the token of the coding in Accept-Encoding and
+	// Content-Encoding.
+	Name S
+	pool sync.Pool
+}
+
+// encoder writes the compressed form of what is written to it.
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+var (
+	// Zstd compresses better and faster than gzip, but not all clients
+	// support it.
+	Zstd = &Encoder{Name: "zstd", pool: sync.Pool{New: func() any {
+		// a window of 8MB is the most browsers accept.
+		e, _ := zstd.NewWriter(nil, zstd.WithWindowSize(1<<23),
+			zstd.WithEncoderConcurrency(1))
+		return e
+	}}}
+	// Gzip is supported by all clients.
+	Gzip = &Encoder{Name: "gzip", pool: sync.Pool{New: func() any {
+		return gzip.NewWriter(nil)
+	}}}
+	encoders = map[S]*Encoder{"zstd": Zstd, "gzip": Gzip}
+)
+
+// ParseEncoders returns the encoders named in names, in order of
+// preference.
+func ParseEncoders(names []S) (e []*Encoder, err E) {
+	for _, name := range names {
+		enc, ok := encoders[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			err = fmt.Errorf("unknown compression encoder %q, want zstd or "+
+				"gzip", name)
+			return
+		}
+		e = append(e, enc)
+	}
+	return
+}
+
+// Compressor compresses the responses of a host.
+type Compressor struct {
+	// Encoders are the codings used, preferred in order when the client
+	// accepts several equally.
+	Encoders []*Encoder
+	// Types are the media types compressed. A type ending in /* matches
+	// all its subtypes.
+	Types []S
+	// MinSize is the smallest Content-Length compressed. Responses of
+	// unknown length are always compressed.
+	MinSize int64
+}
+
+// DefaultTypes are the media types that are usually worth compressing.
+var DefaultTypes = []S{"text/*", "application/json", "application/javascript",
+	"application/xml", "application/wasm", "image/svg+xml"}
+
+// ModifyResponse compresses res if the client accepts one of the Encoders
+// and the response is not compressed already, has one of the Types and is
+// at least MinSize long. Event streams and responses the backend marked
+// no-transform are left alone.
+func (c *Compressor) ModifyResponse(res *http.Response) (err E) {
+	if res.Request == nil || res.Request.Method == http.MethodHead ||
+		res.Body == nil || res.Body == http.NoBody ||
+		res.StatusCode < 200 || res.StatusCode == http.StatusNoContent ||
+		res.StatusCode == http.StatusNotModified ||
+		res.StatusCode == http.StatusPartialContent {
+		return
+	}
+	h := res.Header
+	if h.Get("Content-Encoding") != "" ||
+		(res.ContentLength >= 0 && res.ContentLength < c.MinSize) ||
+		!c.matches(h.Get("Content-Type")) || noTransform(h) {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	enc := Negotiate(res.Request.Header, c.Encoders)
+	if enc == nil {
+		return
+	}
+	res.Body = enc.compress(res.Body)
+	h.Set("Content-Encoding", enc.Name)
+	h.Del("Content-Length")
+	res.ContentLength = -1
+	// the compressed body differs from the one the backend's validator is
+	// for.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	return
+}
+
+func (c *Compressor) matches(ct S) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	// events are flushed one at a time, which the encoder would hold back.
+	if err != nil || mt == "text/event-stream" {
+		return false
+	}
+	for _, t := range c.Types {
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok {
+			if strings.HasPrefix(mt, prefix+"/") {
+				return true
+			}
+		} else if strings.EqualFold(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// noTransform reports whether the Cache-Control of h forbids proxies from
+// changing the content coding.
+func noTransform(h http.Header) bool {
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(d), "no-transform") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Negotiate returns the encoder of encoders with the highest quality in the
+// Accept-Encoding of h, the earliest of equally accepted ones, or nil if none
+// is acceptable.
+func Negotiate(h http.Header, encoders []*Encoder) (best *Encoder) {
+	q := make(map[S]float64)
+	for _, v := range h.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(coding), ";")
+			quality := 1.0
+			for _, p := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if f, err := strconv.ParseFloat(v, 64); k == "q" &&
+					err == nil {
+					quality = f
+				}
+			}
+			q[strings.ToLower(strings.TrimSpace(name))] = quality
+		}
+	}
+	var bestQ float64
+	for _, e := range encoders {
+		eq, ok := q[e.Name]
+		if !ok {
+			eq, ok = q["*"]
+		}
+		if ok && eq > bestQ {
+			best, bestQ = e, eq
+		}
+	}
+	return
+}
+
+// compress returns a body that reads body compressed, encoding it as it is
+// read in a goroutine with an encoder from the pool.
+func (e *Encoder) compress(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w := e.pool.Get().(encoder)
+		w.Reset(pw)
+		_, err := io.Copy(w, body)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		w.Reset(nil)
+		e.pool.Put(w)
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return &compressedBody{PipeReader: pr, body: body}
+}
+
+// compressedBody closes the backend's body along with the pipe, so that the
+// encoding goroutine stops when the client goes away.
+type compressedBody struct {
+	*io.PipeReader
+	body io.ReadCloser
+}
+
+func (b *compressedBody) Close() (err E) {
+	err = b.PipeReader.Close()
+	b.body.Close()
+	return
+}
@@ -0,0 +1,186 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestNegotiate(t *testing.T) {
+	both := []*Encoder{Zstd, Gzip}
+	for _, tc := range []struct {
+		accept   S
+		encoders []*Encoder
+		want     *Encoder
+	}{
+		{"", both, nil},
+		{"gzip", both, Gzip},
+		{"zstd", both, Zstd},
+		{"gzip, zstd", both, Zstd},
+		{"gzip, zstd", []*Encoder{Gzip, Zstd}, Gzip},
+		{"zstd;q=0.5, gzip", both, Gzip},
+		{"zstd; q=0.9, gzip;q=0.8", both, Zstd},
+		{"gzip;q=0, zstd;q=0", both, nil},
+		{"zstd;q=0, *", both, Gzip},
+		{"*;q=0.1", both, Zstd},
+		{"br, deflate", both, nil},
+		{"GZIP", both, Gzip},
+		{"identity, gzip;q=1.0", both, Gzip},
+		{"zstd", []*Encoder{Gzip}, nil},
+	} {
+		h := http.Header{}
+		if tc.accept != "" {
+			h.Set("Accept-Encoding", tc.accept)
+		}
+		if got := Negotiate(h, tc.encoders); got != tc.want {
+			t.Errorf("Negotiate(%q) = %v, want %v", tc.accept, name(got),
+				name(tc.want))
+		}
+	}
+}
+
+func name(e *Encoder) S {
+	if e == nil {
+		return "none"
+	}
+	return e.Name
+}
+
+var page = strings.Repeat("<p>the quick brown fox jumps over the lazy dog</p>\n",
+	2000)
+
+func response(accept S, header ...S) *http.Response {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	req.Header.Set("Accept-Encoding", accept)
+	res := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"text/html"}},
+		Body:          io.NopCloser(strings.NewReader(page)),
+		ContentLength: int64(len(page)),
+		Request:       req,
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		res.Header.Set(header[i], header[i+1])
+	}
+	return res
+}
+
+func decode(t *testing.T, res *http.Response) S {
+	t.Helper()
+	var r io.Reader = res.Body
+	switch res.Header.Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return S(b)
+}
+
+func TestModifyResponse(t *testing.T) {
+	c := &Compressor{Encoders: []*Encoder{Zstd, Gzip}, Types: DefaultTypes,
+		MinSize: 1024}
+	for _, accept := range []S{"gzip", "zstd"} {
+		res := response(accept, "ETag", `"v1"`)
+		if err := c.ModifyResponse(res); err != nil {
+			t.Fatal(err)
+		}
+		if ce := res.Header.Get("Content-Encoding"); ce != accept {
+			t.Errorf("%s: Content-Encoding %q", accept, ce)
+		}
+		if res.ContentLength != -1 || res.Header.Get("Content-Length") != "" {
+			t.Errorf("%s: length %d kept", accept, res.ContentLength)
+		}
+		if etag := res.Header.Get("ETag"); etag != `W/"v1"` {
+			t.Errorf("%s: ETag %q", accept, etag)
+		}
+		if vary := res.Header.Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%s: Vary %q", accept, vary)
+		}
+		if body := decode(t, res); body != page {
+			t.Errorf("%s: body changed, %d bytes", accept, len(body))
+		}
+	}
+}
+
+func TestModifyResponseSkipped(t *testing.T) {
+	c := &Compressor{Encoders: []*Encoder{Zstd, Gzip}, Types: DefaultTypes,
+		MinSize: 1024}
+	for _, tc := range []struct {
+		name   S
+		res    *http.Response
+		modify func(res *http.Response)
+	}{
+		{name: "not accepted", res: response("br")},
+		{name: "event stream", res: response("gzip", "Content-Type",
+			"text/event-stream")},
+		{name: "no-transform", res: response("gzip", "Cache-Control",
+			"public, No-Transform")},
+		{name: "encoded", res: response("gzip", "Content-Encoding", "br")},
+		{name: "type", res: response("gzip", "Content-Type", "image/png")},
+		{name: "small", res: response("gzip"),
+			modify: func(res *http.Response) { res.ContentLength = 10 }},
+		{name: "head", res: response("gzip"),
+			modify: func(res *http.Response) {
+				res.Request.Method = http.MethodHead
+			}},
+		{name: "partial", res: response("gzip"),
+			modify: func(res *http.Response) {
+				res.StatusCode = http.StatusPartialContent
+			}},
+	} {
+		if tc.modify != nil {
+			tc.modify(tc.res)
+		}
+		ce := tc.res.Header.Get("Content-Encoding")
+		if err := c.ModifyResponse(tc.res); err != nil {
+			t.Fatal(err)
+		}
+		if got := tc.res.Header.Get("Content-Encoding"); got != ce {
+			t.Errorf("%s: compressed with %s", tc.name, got)
+		}
+		if b, _ := io.ReadAll(tc.res.Body); S(b) != page {
+			t.Errorf("%s: body changed", tc.name)
+		}
+	}
+}
+
+func BenchmarkEncoders(b *testing.B) {
+	for _, e := range []*Encoder{Zstd, Gzip} {
+		b.Run(e.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(page)))
+			b.ReportAllocs()
+			var size int
+			for i := 0; i < b.N; i++ {
+				var out bytes.Buffer
+				body := e.compress(io.NopCloser(strings.NewReader(page)))
+				n, err := io.Copy(&out, body)
+				if err != nil {
+					b.Fatal(err)
+				}
+				body.Close()
+				size = int(n)
+			}
+			b.ReportMetric(float64(size)/float64(len(page)), "ratio")
+		})
+	}
+}
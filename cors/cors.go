@@ -0,0 +1,79 @@
+// Package cors sets the cross-origin resource sharing headers of responses
+// and answers preflight requests.
+package cors
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Policy is the set of CORS headers given in responses.
+type Policy struct {
+	// Origin is the value of Access-Control-Allow-Origin.
+	Origin S
+	// Methods is the value of Access-Control-Allow-Methods.
+	Methods S
+	// Headers is the value of Access-Control-Allow-Headers in answers to
+	// preflight requests. If empty, the headers the request asks for are
+	// allowed.
+	Headers S
+	// MaxAge is how long browsers may cache the answer to a preflight
+	// request.
+	MaxAge time.Duration
+}
+
+// Default allows any origin to use the usual methods, as lerproxy always has.
+var Default = &Policy{
+	Origin:  "*",
+	Methods: "GET,HEAD,PUT,PATCH,POST,DELETE",
+	MaxAge:  24 * time.Hour,
+}
+
+// Set adds the headers of the policy to h.
+func (p *Policy) Set(h http.Header) {
+	h.Set("Access-Control-Allow-Methods", p.Methods)
+	h.Set("Access-Control-Allow-Origin", p.Origin)
+}
+
+// Preflight answers r with status 204 and the headers of the policy if it is
+// a CORS preflight request, reporting whether it was.
+func (p *Policy) Preflight(w http.ResponseWriter, r *http.Request) (ok bool) {
+	if r.Method != http.MethodOptions ||
+		r.Header.Get("Access-Control-Request-Method") == "" {
+		return
+	}
+	h := w.Header()
+	p.Set(h)
+	allow := p.Headers
+	if allow == "" {
+		allow = r.Header.Get("Access-Control-Request-Headers")
+	}
+	if allow != "" {
+		h.Set("Access-Control-Allow-Headers", allow)
+	}
+	if p.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age",
+			strconv.Itoa(int(p.MaxAge/time.Second)))
+	}
+	h.Add("Vary", "Origin")
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// Handler serves preflight requests with Policy and sets its headers on the
+// responses of Handler to the others.
+type Handler struct {
+	http.Handler
+	Policy *Policy
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Policy.Preflight(w, r) {
+		return
+	}
+	h.Policy.Set(w.Header())
+	h.Handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,121 @@
+// Package dnscache pins the addresses of backends given by hostname for a
+// while, so that new connections to them do not wait for a slow DNS server
+// each time.
+package dnscache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DialFunc dials an address, like net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr S) (net.Conn, E)
+
+// Cache resolves hostnames and keeps their addresses for TTL. Once they have
+// expired, they are still used while they are resolved again in the
+// background, and kept if that fails, so that a DNS outage does not make
+// the backends unreachable.
+type Cache struct {
+	TTL time.Duration
+	// Resolver resolves the hostnames, net.DefaultResolver if nil.
+	Resolver *net.Resolver
+	mx       sync.Mutex
+	entries  map[S]*entry
+}
+
+type entry struct {
+	addrs      []S
+	expires    time.Time
+	refreshing bool
+}
+
+// lookupTimeout bounds resolving in the background.
+const lookupTimeout = 10 * time.Second
+
+// failures counts the failed lookups of all caches.
+var failures atomic.Int64
+
+// Failures is the number of failed lookups so far.
+func Failures() int64 { return failures.Load() }
+
+// Dial returns a DialFunc dialing the cached addresses of the host of the
+// address with dial, in turn until one connects. Addresses with an IP are
+// dialed as they are.
+func (c *Cache) Dial(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr S) (conn net.Conn,
+		err E) {
+
+		host, port, serr := net.SplitHostPort(addr)
+		if serr != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		var addrs []S
+		if addrs, err = c.lookup(ctx, host); err != nil {
+			return
+		}
+		for _, a := range addrs {
+			if conn, err = dial(ctx, network,
+				net.JoinHostPort(a, port)); err == nil {
+				return
+			}
+		}
+		return
+	}
+}
+
+// lookup returns the addresses of host, resolving it now if they are not
+// cached, or in the background if they have expired.
+func (c *Cache) lookup(ctx context.Context, host S) (addrs []S, err E) {
+	c.mx.Lock()
+	if c.entries == nil {
+		c.entries = make(map[S]*entry)
+	}
+	e, ok := c.entries[host]
+	if ok {
+		addrs = e.addrs
+		if time.Now().After(e.expires) && !e.refreshing {
+			e.refreshing = true
+			go c.refresh(host, e)
+		}
+		c.mx.Unlock()
+		return
+	}
+	c.mx.Unlock()
+	if addrs, err = c.resolver().LookupHost(ctx, host); err != nil {
+		failures.Add(1)
+		log.W.F("resolving backend %s: %v", host, err)
+		return
+	}
+	c.mx.Lock()
+	c.entries[host] = &entry{addrs: addrs, expires: time.Now().Add(c.TTL)}
+	c.mx.Unlock()
+	return
+}
+
+func (c *Cache) refresh(host S, e *entry) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	addrs, err := c.resolver().LookupHost(ctx, host)
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	e.refreshing = false
+	// a failure is retried after TTL too, rather than on every dial.
+	e.expires = time.Now().Add(c.TTL)
+	if err != nil {
+		failures.Add(1)
+		log.W.F("resolving backend %s failed, keeping %v: %v", host,
+			e.addrs, err)
+		return
+	}
+	e.addrs = addrs
+}
+
+func (c *Cache) resolver() *net.Resolver {
+	if c.Resolver != nil {
+		return c.Resolver
+	}
+	return net.DefaultResolver
+}
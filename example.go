@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+)
+
+// initArgs are the arguments of the init subcommand.
+type initArgs struct {
+	Force bool `arg:"--force" help:"overwrite the mapping file if it exists"`
+}
+
+// exampleMapping is written by the init subcommand to show each kind of
+// backend.
+const exampleMapping = `# lerproxy mapping: each line routes a host to a backend, in the form
+#
+#	host: backend
+#
+# Lines starting with # are comments. Hostnames are matched ignoring case.
+# Certificates are obtained from LetsEncrypt for each host when it is first
+# requested. Run lerproxy --print-routes to see how each line is read.
+
+# an http or https URL proxies to it, sending the backend's own host name
+# rather than the one requested.
+example.com: http://127.0.0.1:8080
+api.example.com: https://internal.example.net
+
+# a host:port or an absolute path to a unix socket proxies to it, passing the
+# requested host name through. @name is an abstract unix socket on linux.
+app.example.com: 127.0.0.1:3000
+socket.example.com: /run/app/http.sock
+
+# an absolute path ending in a slash serves the files of the directory.
+www.example.com: /var/www/example.com/
+
+# a path ending in nostr.json serves it as the NIP-05 identities of the host
+# at /.well-known/nostr.json.
+nostr.example.com: /etc/lerproxy/nostr.json
+
+# git+ and a repository URL serves the go-import meta tag for a vanity
+# import path.
+go.example.com: git+https://github.com/example/repo
+
+# srv: looks up the backends in the DNS SRV records of the name.
+lb.example.com: srv://_http._tcp.app.example.net
+
+# grpc: and grpcs: proxy gRPC over cleartext or TLS HTTP/2.
+grpc.example.com: grpc://127.0.0.1:50051
+
+# exec: and an absolute path runs the program once per request, CGI-like.
+cgi.example.com: exec:/usr/local/bin/handler
+
+# connect: and host:port targets makes the host a CONNECT proxy, tunneling
+# only to those targets.
+tunnel.example.com: connect:db.internal:5432,git.internal:22
+
+# a path, optionally preceded by a method, sends part of a host to another
+# backend. *.example.com routes the subdomains that have no line of their own.
+example.com/api/: 127.0.0.1:9000
+GET example.com/items/{id}: http://127.0.0.1:9001
+*.example.com: http://127.0.0.1:8000
+
+# strings in the response bodies of a host are replaced with --rewrite-body
+# rather than in this file, eg:
+#
+#	--rewrite-body 'example.com:http://127.0.0.1:8080 https://example.com'
+`
+
+// writeExample writes exampleMapping to path, unless the file exists and
+// force is not set.
+func writeExample(path string, force bool) (err error) {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	var f *os.File
+	if f, err = os.OpenFile(path, flag, 0644); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			err = log.E.Err("%s exists, use --force to overwrite it", path)
+		}
+		return
+	}
+	if _, err = f.WriteString(exampleMapping); chk.E(err) {
+		f.Close()
+		return
+	}
+	if err = f.Close(); chk.E(err) {
+		return
+	}
+	log.I.Ln("wrote example mapping to", path)
+	return
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lerproxy.mleku.dev/proxy"
+)
+
+func TestWriteExample(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.txt")
+	if err := writeExample(path, false); err != nil {
+		t.Fatal(err)
+	}
+	m, err := proxy.ReadMapping(path)
+	if err != nil {
+		t.Fatalf("example mapping does not parse: %v", err)
+	}
+	if len(m) == 0 {
+		t.Error("example mapping has no hosts")
+	}
+	if err = os.WriteFile(path, []byte("mine.test: 127.0.0.1:1\n"),
+		0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = writeExample(path, false); err == nil {
+		t.Error("existing file overwritten without --force")
+	}
+	if b, _ := os.ReadFile(path); string(b) != "mine.test: 127.0.0.1:1\n" {
+		t.Errorf("existing file changed: %q", b)
+	}
+	if err = writeExample(path, true); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != exampleMapping {
+		t.Error("--force did not overwrite the file")
+	}
+}
@@ -0,0 +1,12 @@
+// Package fastopen enables TCP Fast Open on listening sockets where the
+// platform supports it.
+//
+// On Linux the kernel must also permit server side fast open, which is bit 2
+// of the net.ipv4.tcp_fastopen sysctl:
+//
+//	sysctl -w net.ipv4.tcp_fastopen=3
+package fastopen
+
+// QueueLength is the maximum number of pending fast open requests (those
+// that have not yet completed the three way handshake) per listener.
+var QueueLength = 256
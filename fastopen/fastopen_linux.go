@@ -0,0 +1,30 @@
+//go:build linux
+
+package fastopen
+
+import "syscall"
+
+// tcpFastOpen is TCP_FASTOPEN from linux/tcp.h, which the syscall package
+// does not define.
+const tcpFastOpen = 0x17
+
+// Supported reports whether TCP Fast Open can be enabled on this platform.
+const Supported = true
+
+// Control is a net.ListenConfig Control function that sets TCP_FASTOPEN on
+// the socket before it is bound.
+func Control(network, address S, c syscall.RawConn) (err E) {
+	var serr E
+	if err = c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP,
+			tcpFastOpen, QueueLength)
+	}); chk.E(err) {
+		return
+	}
+	if serr != nil {
+		// an older kernel or a restrictive sysctl should not stop the
+		// listener from working, just without fast open.
+		log.W.F("enabling TCP fast open on %s: %v", address, serr)
+	}
+	return
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package fastopen
+
+import "syscall"
+
+// Supported reports whether TCP Fast Open can be enabled on this platform.
+const Supported = false
+
+// Control does nothing on platforms where TCP Fast Open is not supported.
+func Control(network, address S, c syscall.RawConn) (err E) { return }
@@ -0,0 +1,94 @@
+// Package favicon serves a default /favicon.ico for hosts that have none of
+// their own, which browsers ask every host for, so that those requests are
+// not answered with 404 and logged.
+package favicon
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Path is the path browsers request the icon at.
+const Path = "/favicon.ico"
+
+// Icon serves the icon.
+type Icon struct {
+	Body B
+	// ContentType of Body. If empty, it is detected from the content.
+	ContentType S
+}
+
+func (i *Icon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ct := i.ContentType
+	if ct == "" {
+		ct = http.DetectContentType(i.Body)
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Length", fmt.Sprint(len(i.Body)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(i.Body)
+	}
+}
+
+// Handler passes requests to Handler, answering those for the icon that it
+// answers with 404 with Icon instead.
+type Handler struct {
+	http.Handler
+	Icon *Icon
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Path ||
+		(r.Method != http.MethodGet && r.Method != http.MethodHead) {
+
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	mw := &missing{ResponseWriter: w}
+	h.Handler.ServeHTTP(mw, r)
+	if mw.notFound {
+		hdr := w.Header()
+		for _, k := range []S{"Content-Encoding", "Content-Length",
+			"X-Content-Type-Options"} {
+
+			hdr.Del(k)
+		}
+		h.Icon.ServeHTTP(w, r)
+	}
+}
+
+// missing is a ResponseWriter that holds back a 404 response, so that the
+// icon can be served in its place.
+type missing struct {
+	http.ResponseWriter
+	notFound, wrote bool
+}
+
+func (m *missing) WriteHeader(code int) {
+	if m.wrote || code < http.StatusOK {
+		if !m.wrote {
+			m.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	m.wrote = true
+	if code == http.StatusNotFound {
+		m.notFound = true
+		return
+	}
+	m.ResponseWriter.WriteHeader(code)
+}
+
+func (m *missing) Write(p B) (n int, err E) {
+	if !m.wrote {
+		m.WriteHeader(http.StatusOK)
+	}
+	if m.notFound {
+		return len(p), nil
+	}
+	return m.ResponseWriter.Write(p)
+}
+
+func (m *missing) Unwrap() http.ResponseWriter { return m.ResponseWriter }
@@ -0,0 +1,34 @@
+// Package headerlimit rejects requests with more header fields than a limit,
+// which the server's MaxHeaderBytes does not bound when they are small.
+package headerlimit
+
+import (
+	"net/http"
+)
+
+// Handler answers requests with more than Max header fields with 431 Request
+// Header Fields Too Large, passing the others to Handler. Repeated fields
+// count once for each value.
+type Handler struct {
+	http.Handler
+	Max int
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if n := Count(r.Header); n > h.Max {
+		log.D.F("rejecting request for %s%s from %s with %d header fields",
+			r.Host, r.URL.Path, r.RemoteAddr, n)
+		http.Error(w, http.StatusText(http.StatusRequestHeaderFieldsTooLarge),
+			http.StatusRequestHeaderFieldsTooLarge)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// Count returns the number of header fields of h.
+func Count(h http.Header) (n int) {
+	for _, v := range h {
+		n += len(v)
+	}
+	return
+}
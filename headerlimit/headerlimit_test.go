@@ -0,0 +1,57 @@
+package headerlimit
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	h := &Handler{Max: 3, Handler: http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {})}
+	for _, tc := range []struct {
+		name   S
+		header http.Header
+		status int
+	}{
+		{"none", http.Header{}, http.StatusOK},
+		{"at limit", http.Header{"A": {"1"}, "B": {"2"}, "C": {"3"}},
+			http.StatusOK},
+		{"over limit", http.Header{"A": {"1"}, "B": {"2"}, "C": {"3"},
+			"D": {"4"}}, http.StatusRequestHeaderFieldsTooLarge},
+		{"repeated", http.Header{"A": {"1", "2", "3", "4"}},
+			http.StatusRequestHeaderFieldsTooLarge},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header = tc.header
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+	}
+}
+
+func TestServer(t *testing.T) {
+	srv := httptest.NewServer(&Handler{Max: 10, Handler: http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {})})
+	defer srv.Close()
+	for _, tc := range []struct {
+		fields, status int
+	}{{5, http.StatusOK}, {50, http.StatusRequestHeaderFieldsTooLarge}} {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		for i := 0; i < tc.fields; i++ {
+			req.Header.Add(fmt.Sprintf("X-Field-%d", i), "v")
+		}
+		res, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tc.status {
+			t.Errorf("%d fields: status %d, want %d", tc.fields,
+				res.StatusCode, tc.status)
+		}
+	}
+}
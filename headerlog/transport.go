@@ -0,0 +1,63 @@
+// Package headerlog logs the headers of requests forwarded to a backend and
+// of its responses at trace level, for debugging a single host without
+// turning on trace logging for everything.
+package headerlog
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Redacted lists the headers whose values are never logged.
+var Redacted = map[S]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// Transport wraps a RoundTripper, logging the headers of each request and
+// response passing through it.
+type Transport struct {
+	// RoundTripper makes the requests. If nil, http.DefaultTransport is used.
+	http.RoundTripper
+	// Host is the mapped host, used to label the log lines.
+	Host S
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (res *http.Response, err E) {
+	rt := t.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	log.T.F("%s request %s %s\n%s", t.Host, req.Method, req.URL,
+		format(req.Header))
+	if res, err = rt.RoundTrip(req); err != nil {
+		log.T.F("%s request %s %s failed: %v", t.Host, req.Method, req.URL,
+			err)
+		return
+	}
+	log.T.F("%s response %s %s %s\n%s", t.Host, req.Method, req.URL,
+		res.Status, format(res.Header))
+	return
+}
+
+// format renders headers one per line in a stable order with the values of
+// Redacted headers replaced.
+func format(h http.Header) S {
+	keys := make([]S, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		v := strings.Join(h[k], ", ")
+		if Redacted[http.CanonicalHeaderKey(k)] {
+			v = "[redacted]"
+		}
+		b.WriteString("\t" + k + ": " + v + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,68 @@
+// Package headers adds configured response headers per host, such as
+// Content-Security-Policy or Referrer-Policy.
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Rule adds one header value.
+type Rule struct {
+	Name, Value S
+	// Override replaces any values the backend set for Name, otherwise the
+	// rule only applies when the backend did not set Name.
+	Override bool
+}
+
+// Rules maps hostnames to their rules, in the order they were given.
+type Rules map[S][]Rule
+
+// Parse reads rules in the form "example.com:Header-Name: value". Rules for
+// the same host and header name add several values.
+func (r Rules) Parse(specs []S, override bool) (err E) {
+	for _, spec := range specs {
+		host, header, _ := strings.Cut(spec, ":")
+		host = strings.ToLower(host)
+		name, value, ok := strings.Cut(header, ":")
+		name = strings.TrimSpace(name)
+		if host == "" || name == "" || !ok {
+			err = fmt.Errorf("invalid header parameter format: `%s`", spec)
+			return
+		}
+		r[host] = append(r[host], Rule{
+			Name:     http.CanonicalHeaderKey(name),
+			Value:    strings.TrimSpace(value),
+			Override: override,
+		})
+	}
+	return
+}
+
+// Apply adds the rules to h, which holds the headers set by the backend.
+//
+// Headers the backend set are kept unless a rule for them overrides, in
+// which case all of the backend's values are replaced by those of the
+// rules. Values are added in the order of the rules.
+func Apply(h http.Header, rules []Rule) {
+	backend := make(map[S]bool, len(rules))
+	for _, r := range rules {
+		if _, ok := backend[r.Name]; !ok {
+			backend[r.Name] = len(h.Values(r.Name)) > 0
+		}
+	}
+	cleared := make(map[S]bool)
+	for _, r := range rules {
+		switch {
+		case r.Override:
+			if !cleared[r.Name] {
+				h.Del(r.Name)
+				cleared[r.Name] = true
+			}
+		case backend[r.Name] && !cleared[r.Name]:
+			continue
+		}
+		h.Add(r.Name, r.Value)
+	}
+}
@@ -0,0 +1,98 @@
+package headers
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	r := make(Rules)
+	if err := r.Parse([]S{
+		"Example.com:referrer-policy: no-referrer",
+		"example.com:Content-Security-Policy: default-src 'self'; img-src *",
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Parse([]S{"example.com:X-Frame-Options:DENY"},
+		true); err != nil {
+		t.Fatal(err)
+	}
+	want := []Rule{
+		{"Referrer-Policy", "no-referrer", false},
+		{"Content-Security-Policy", "default-src 'self'; img-src *", false},
+		{"X-Frame-Options", "DENY", true},
+	}
+	if !reflect.DeepEqual(r["example.com"], want) {
+		t.Errorf("rules = %+v, want %+v", r["example.com"], want)
+	}
+	for _, spec := range []S{"example.com", ":X-A: b", "example.com:: b",
+		"example.com:X-A"} {
+		if err := make(Rules).Parse([]S{spec}, false); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend http.Header
+		rules   []Rule
+		want    http.Header
+	}{
+		{
+			name:    "added when the backend did not set it",
+			backend: http.Header{},
+			rules:   []Rule{{"Referrer-Policy", "no-referrer", false}},
+			want:    http.Header{"Referrer-Policy": {"no-referrer"}},
+		},
+		{
+			name:    "backend value kept",
+			backend: http.Header{"Referrer-Policy": {"origin"}},
+			rules:   []Rule{{"Referrer-Policy", "no-referrer", false}},
+			want:    http.Header{"Referrer-Policy": {"origin"}},
+		},
+		{
+			name:    "override replaces the backend values",
+			backend: http.Header{"X-Frame-Options": {"ALLOW", "SAMEORIGIN"}},
+			rules:   []Rule{{"X-Frame-Options", "DENY", true}},
+			want:    http.Header{"X-Frame-Options": {"DENY"}},
+		},
+		{
+			name:    "values added in order",
+			backend: http.Header{},
+			rules: []Rule{
+				{"Permissions-Policy", "camera=()", false},
+				{"Permissions-Policy", "microphone=()", false},
+			},
+			want: http.Header{"Permissions-Policy": {"camera=()",
+				"microphone=()"}},
+		},
+		{
+			name:    "overrides and defaults of one header",
+			backend: http.Header{"Content-Security-Policy": {"default-src *"}},
+			rules: []Rule{
+				{"Content-Security-Policy", "default-src 'self'", true},
+				{"Content-Security-Policy", "img-src *", false},
+			},
+			want: http.Header{"Content-Security-Policy": {
+				"default-src 'self'", "img-src *"}},
+		},
+		{
+			name: "other headers untouched",
+			backend: http.Header{"Content-Type": {"text/html"},
+				"X-Content-Type-Options": {"nosniff"}},
+			rules: []Rule{{"X-Content-Type-Options", "nosniff", true}},
+			want: http.Header{"Content-Type": {"text/html"},
+				"X-Content-Type-Options": {"nosniff"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			Apply(tc.backend, tc.rules)
+			if !reflect.DeepEqual(tc.backend, tc.want) {
+				t.Errorf("headers = %v, want %v", tc.backend, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package hostpolicy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// reserved are the suffixes of names that are not in the public DNS, which
+// no CA issues certificates for.
+var reserved = []S{".local", ".localhost", ".internal", ".lan", ".home",
+	".test", ".invalid", ".example", ".arpa", ".onion"}
+
+// Any is an autocert.HostPolicy allowing any public hostname that is not
+// denied, for hosts created on the fly. Every name a client asks for counts
+// against the rate limits of the CA, so it is only safe when the DNS of all
+// names resolving to the server is under control.
+type Any struct {
+	// Deny are the hosts refused, in the form of those of a Whitelist.
+	Deny *Whitelist
+}
+
+// Policy rejects IP addresses, names that are not valid or not public, and
+// those in Deny.
+func (a *Any) Policy(_ context.Context, host S) (err E) {
+	host = strings.ToLower(host)
+	if err = checkName(host); err != nil {
+		return fmt.Errorf("acme/autocert: host %q not allowed: %w", host, err)
+	}
+	if a.Deny != nil && a.Deny.Contains(host) {
+		err = fmt.Errorf("acme/autocert: host %q is denied", host)
+	}
+	return
+}
+
+// checkName reports why host is not a public DNS name.
+func checkName(host S) (err E) {
+	if net.ParseIP(host) != nil {
+		return fmt.Errorf("IP address")
+	}
+	if len(host) > 253 {
+		return fmt.Errorf("name too long")
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("no domain")
+	}
+	for _, l := range labels {
+		if len(l) == 0 || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return fmt.Errorf("invalid label %q", l)
+		}
+		for _, c := range l {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return fmt.Errorf("invalid label %q", l)
+			}
+		}
+	}
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return fmt.Errorf("numeric top level domain")
+	}
+	for _, suffix := range reserved {
+		if strings.HasSuffix(host, suffix) {
+			return fmt.Errorf("reserved domain %s", suffix[1:])
+		}
+	}
+	return
+}
@@ -0,0 +1,40 @@
+package hostpolicy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestAny(t *testing.T) {
+	a := &Any{Deny: New("denied.example.com", "*.blocked.com")}
+	for _, tc := range []struct {
+		host S
+		ok   bool
+	}{
+		{"example.com", true},
+		{"Sub.Example.COM", true},
+		{"xn--bcher-kva.de", true},
+		{"a-b.example.org", true},
+		{"denied.example.com", false},
+		{"x.blocked.com", false},
+		{"192.0.2.1", false},
+		{"::1", false},
+		{"localhost", false},
+		{"printer.local", false},
+		{"app.test", false},
+		{"db.internal", false},
+		{"example.123", false},
+		{"-bad.example.com", false},
+		{"bad-.example.com", false},
+		{"under_score.example.com", false},
+		{"example..com", false},
+		{strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a.", 127) + "com", false},
+	} {
+		if err := a.Policy(context.Background(), tc.host); (err == nil) !=
+			tc.ok {
+			t.Errorf("Policy(%q) = %v", tc.host, err)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Package hostpolicy provides an autocert.HostPolicy backed by a set of
+// hostnames that can be replaced at runtime, so hosts added by a reload can
+// obtain certificates without restarting.
+package hostpolicy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// Whitelist is a set of hostnames allowed to obtain certificates. The zero
+// value allows no hosts.
+//
+// A hostname of the form *.example.com allows any name ending in
+// .example.com, each obtaining its own certificate.
+type Whitelist struct {
+	hosts atomic.Pointer[set]
+}
+
+type set struct {
+	exact map[S]struct{}
+	// suffixes of the wildcards, with their leading dot.
+	suffixes []S
+}
+
+// New creates a Whitelist allowing the given hosts.
+func New(hosts ...S) (w *Whitelist) {
+	w = &Whitelist{}
+	w.Set(hosts...)
+	return
+}
+
+// Set atomically replaces the allowed hosts.
+func (w *Whitelist) Set(hosts ...S) {
+	s := &set{exact: make(map[S]struct{}, len(hosts))}
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if suffix, ok := strings.CutPrefix(h, "*"); ok {
+			s.suffixes = append(s.suffixes, suffix)
+			continue
+		}
+		s.exact[h] = struct{}{}
+	}
+	w.hosts.Store(s)
+}
+
+// Contains reports whether host is currently allowed, ignoring case.
+func (w *Whitelist) Contains(host S) (ok bool) {
+	host = strings.ToLower(host)
+	s := w.hosts.Load()
+	if s == nil {
+		return
+	}
+	if _, ok = s.exact[host]; ok {
+		return
+	}
+	for _, suffix := range s.suffixes {
+		if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return
+}
+
+// Hosts returns the allowed hosts in sorted order, without the wildcards.
+func (w *Whitelist) Hosts() (hosts []S) {
+	s := w.hosts.Load()
+	if s == nil {
+		return
+	}
+	hosts = make([]S, 0, len(s.exact))
+	for h := range s.exact {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return
+}
+
+// Policy is an autocert.HostPolicy that rejects hosts not in the Whitelist.
+func (w *Whitelist) Policy(_ context.Context, host S) (err E) {
+	if !w.Contains(host) {
+		err = fmt.Errorf("acme/autocert: host %q not configured in whitelist",
+			host)
+	}
+	return
+}
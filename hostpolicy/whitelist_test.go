@@ -0,0 +1,58 @@
+package hostpolicy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const benchHosts = 5000
+
+func hosts() (h []S) {
+	for i := 0; i < benchHosts; i++ {
+		h = append(h, fmt.Sprintf("host%d.example.com", i))
+	}
+	return
+}
+
+func benchmarkPolicy(b *testing.B, policy autocert.HostPolicy) {
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := policy(ctx, fmt.Sprintf("host%d.example.com",
+			i%benchHosts)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWhitelist(b *testing.B) {
+	benchmarkPolicy(b, New(hosts()...).Policy)
+}
+
+// BenchmarkAutocertWhitelist is the policy used before the Whitelist.
+func BenchmarkAutocertWhitelist(b *testing.B) {
+	benchmarkPolicy(b, autocert.HostWhitelist(hosts()...))
+}
+
+func TestWhitelistCase(t *testing.T) {
+	w := New("Example.COM", "*.Wild.example")
+	for _, tc := range []struct {
+		host S
+		ok   bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"a.wild.example", true},
+		{"A.WILD.Example", true},
+		{"other.com", false},
+	} {
+		if err := w.Policy(context.Background(), tc.host); (err == nil) !=
+			tc.ok {
+			t.Errorf("Policy(%q) = %v", tc.host, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package listen opens listeners on TCP addresses or, with the form
+// unix:/path, on unix sockets whose access is restricted by file
+// permissions.
+package listen
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// Unix is the prefix of addresses of unix sockets.
+const Unix = "unix:"
+
+// Listen listens at addr with lc. A unix socket is created with permissions
+// 0660, replacing a socket left behind at the path.
+func Listen(ctx context.Context, lc *net.ListenConfig,
+	addr S) (ln net.Listener, err E) {
+
+	path, ok := strings.CutPrefix(addr, Unix)
+	if !ok {
+		return lc.Listen(ctx, "tcp", addr)
+	}
+	var fi fs.FileInfo
+	if fi, err = os.Lstat(path); err == nil {
+		if fi.Mode()&fs.ModeSocket == 0 {
+			err = log.E.Err("%s exists and is not a socket", path)
+			return
+		}
+		if err = os.Remove(path); chk.E(err) {
+			return
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if ln, err = lc.Listen(ctx, "unix", path); err != nil {
+		return
+	}
+	if err = os.Chmod(path, 0660); chk.E(err) {
+		ln.Close()
+		return
+	}
+	return
+}
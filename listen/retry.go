@@ -0,0 +1,36 @@
+package listen
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Retryable reports whether listening failed because the address is in use,
+// which may only last until a previous instance has finished stopping,
+// rather than because it is invalid or not permitted.
+func Retryable(err E) bool { return errors.Is(err, syscall.EADDRINUSE) }
+
+// Retry calls listen until it succeeds, fails with an error that is not
+// Retryable, or has been retried retries times, waiting delay before the
+// first retry and twice as long before each further one.
+func Retry(ctx context.Context, retries int, delay time.Duration,
+	listen func() (net.Listener, E)) (ln net.Listener, err E) {
+
+	for i := 0; ; i++ {
+		if ln, err = listen(); err == nil || i >= retries ||
+			!Retryable(err) {
+
+			return
+		}
+		log.W.F("%v, retrying in %v (%d of %d)", err, delay, i+1, retries)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
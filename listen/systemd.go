@@ -0,0 +1,74 @@
+package listen
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// firstFD is the first file descriptor passed by systemd.
+const firstFD = 3
+
+// Socket is a listener passed by systemd.
+type Socket struct {
+	net.Listener
+	// Name is given with FileDescriptorName= in the socket unit, and
+	// defaults to the name of the unit.
+	Name S
+}
+
+// Systemd returns the sockets passed by systemd socket activation, in the
+// order of the socket unit. ok is false if the process was not socket
+// activated, when the caller should bind its addresses itself.
+func Systemd() (sockets []Socket, ok bool, err E) {
+	pid, n := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	if pid == "" || n == "" || pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	var count int
+	if count, err = strconv.Atoi(n); err != nil || count < 1 {
+		err = log.E.Err("invalid LISTEN_FDS %q", n)
+		return
+	}
+	var names []S
+	if v := os.Getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+	// the sockets must not be passed on to child processes such as exec:
+	// backends.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for i := range count {
+		fd := firstFD + i
+		var name S
+		if i < len(names) {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		var ln net.Listener
+		ln, err = net.FileListener(f)
+		// the listener holds a duplicate of the descriptor, which is not
+		// inherited by child processes.
+		f.Close()
+		if chk.E(err) {
+			return
+		}
+		sockets = append(sockets, Socket{Listener: ln, Name: name})
+	}
+	return sockets, true, nil
+}
+
+// Pick returns the socket named name, or else the one at index i, or nil.
+func Pick(sockets []Socket, name S, i int) net.Listener {
+	for _, s := range sockets {
+		if s.Name == name {
+			return s.Listener
+		}
+	}
+	if i < len(sockets) {
+		return sockets[i].Listener
+	}
+	return nil
+}
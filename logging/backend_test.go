@@ -0,0 +1,36 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	stdLog "log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+
+	"lerproxy.mleku.dev/logging"
+	"lerproxy.mleku.dev/reverse"
+)
+
+// TestBackendErrorLog encodes an entry of the backend error log as the
+// reverse proxy writes it.
+func TestBackendErrorLog(t *testing.T) {
+	var b bytes.Buffer
+	s := logging.NewSwitch(&b)
+	s.SetJSON(true)
+	h := reverse.ErrorHandler("example.com", stdLog.New(s, "", 0))
+	err := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet,
+		"https://example.com/", nil), err)
+	var e struct {
+		Level, Msg, Host, Err string
+	}
+	if err := json.Unmarshal(b.Bytes(), &e); err != nil {
+		t.Fatalf("%q: %v", b.String(), err)
+	}
+	if e.Level != "error" || e.Host != "example.com" || e.Err != err.Error() {
+		t.Errorf("got %+v from %q", e, b.String())
+	}
+}
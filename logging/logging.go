@@ -0,0 +1,207 @@
+// Package logging holds the output shared by the loggers of every package,
+// so that its format can be switched in one place.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writer is the destination each package passes to lol.New for its log, and
+// Checks the one for its chk, whose entries are the errors it checked.
+var (
+	Writer = NewSwitch(os.Stderr)
+	Checks = Writer.Checks()
+)
+
+// SetJSON switches Writer between passing log lines through verbatim and
+// encoding each one as a JSON object.
+func SetJSON(on bool) { Writer.SetJSON(on) }
+
+// Switch is an io.Writer that either copies log entries to the underlying
+// writer or re-encodes each as a JSON line with the fields level, ts, msg,
+// host, err and src.
+type Switch struct {
+	mx   sync.Mutex
+	w    io.Writer
+	json bool
+	buf  [2][]byte
+}
+
+// NewSwitch returns a Switch writing to w, passing entries through verbatim.
+func NewSwitch(w io.Writer) *Switch { return &Switch{w: w} }
+
+// Checks returns a writer to s for the entries of the errors checked with
+// chk, whose text is put in the err field of JSON entries rather than msg.
+func (s *Switch) Checks() io.Writer { return checks{s} }
+
+type checks struct{ s *Switch }
+
+func (c checks) Write(p []byte) (n int, err error) { return c.s.write(p, true) }
+
+// SetJSON enables or disables JSON encoding.
+func (s *Switch) SetJSON(on bool) {
+	s.mx.Lock()
+	s.json = on
+	s.mx.Unlock()
+}
+
+func (s *Switch) Write(p []byte) (n int, err error) { return s.write(p, false) }
+
+// write encodes an entry once it is complete. An entry may arrive in several
+// writes, and ends with a write ending in a newline, so that a message of
+// several lines stays one entry.
+func (s *Switch) write(p []byte, checked bool) (n int, err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.json {
+		return s.w.Write(p)
+	}
+	n = len(p)
+	k := 0
+	if checked {
+		k = 1
+	}
+	s.buf[k] = append(s.buf[k], p...)
+	if !bytes.HasSuffix(s.buf[k], []byte{'\n'}) {
+		return
+	}
+	text := s.buf[k]
+	s.buf[k] = nil
+	if len(bytes.TrimSpace(text)) == 0 {
+		return
+	}
+	_, err = s.w.Write(encode(text, checked))
+	return
+}
+
+type entry struct {
+	Level string `json:"level"`
+	TS    string `json:"ts"`
+	Msg   string `json:"msg"`
+	Host  string `json:"host,omitempty"`
+	Err   string `json:"err,omitempty"`
+	Src   string `json:"src,omitempty"`
+}
+
+var (
+	ansi   = regexp.MustCompile("\x1b\\[[0-9;]*m")
+	source = regexp.MustCompile(`^\S+\.go:\d+$`)
+	// timestamp matches a field of the logger's timestamp.
+	timestamp = regexp.MustCompile(`^\d[\d:./+\-TZ]*$`)
+	// field matches the host and err fields of lines such as those of the
+	// backend error log, quoted or not.
+	field  = regexp.MustCompile(`(?:^|\s)(host|err)=("(?:[^"\\]|\\.)*"|\S+)`)
+	levels = map[string]string{
+		"FTL": "fatal",
+		"ERR": "error",
+		"WRN": "warn",
+		"INF": "info",
+		"DBG": "debug",
+		"TRC": "trace",
+	}
+)
+
+// encode turns one formatted log entry into a JSON line. The logger's own
+// timestamp before the level marker becomes ts, a trailing source location
+// is moved to src, and host= and err= fields of the message are moved to
+// host and err. The message of an entry of chk, which is the error checked,
+// is moved to err. Lines after the first are kept in msg.
+func encode(text []byte, checked bool) []byte {
+	e := entry{Level: "info"}
+	ts := time.Now()
+	rest := strings.TrimSpace(ansi.ReplaceAllString(string(text), ""))
+	first, _, _ := strings.Cut(rest, "\n")
+	fields := strings.Fields(first)
+	i, l := level(fields)
+	if i >= 0 {
+		e.Level = l
+		if t, ok := stamp(fields[:i]); ok {
+			ts = t
+		}
+		// drop the fields up to the marker, keeping the message as it is.
+		for _, f := range fields[:i+1] {
+			_, rest, _ = strings.Cut(rest, f)
+		}
+		rest = strings.TrimSpace(rest)
+	}
+	e.TS = ts.UTC().Format(time.RFC3339Nano)
+	if j := strings.LastIndexAny(rest, " \t\n"); j >= 0 &&
+		source.MatchString(rest[j+1:]) {
+
+		e.Src = rest[j+1:]
+		rest = strings.TrimSpace(rest[:j])
+	}
+	if checked {
+		e.Err = rest
+	} else {
+		for _, m := range field.FindAllStringSubmatch(rest, -1) {
+			v := m[2]
+			if u, err := strconv.Unquote(v); err == nil {
+				v = u
+			}
+			if m[1] == "host" {
+				e.Host = v
+			} else {
+				e.Err = v
+			}
+		}
+		e.Msg = strings.TrimSpace(field.ReplaceAllString(rest, ""))
+	}
+	if i < 0 && e.Err != "" {
+		// lines of the standard logger, such as backend errors, have no
+		// level marker.
+		e.Level = "error"
+	}
+	b, _ := json.Marshal(e)
+	return append(b, '\n')
+}
+
+// stamp reads the logger's timestamp of the fields before the level marker,
+// in seconds since the epoch or RFC 3339.
+func stamp(fields []string) (t time.Time, ok bool) {
+	if len(fields) != 1 {
+		return
+	}
+	f := strings.Trim(fields[0], "[]")
+	if t, err := time.Parse(time.RFC3339Nano, f); err == nil {
+		return t, true
+	}
+	sec, frac, _ := strings.Cut(f, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return
+	}
+	var ns int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		if ns, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return
+		}
+	}
+	return time.Unix(s, ns), true
+}
+
+// level returns the position and level of the level marker of a line of
+// fields, or -1 if there is none. The marker is the first field, or the one
+// after the timestamp, which is all digits and punctuation, so that words of
+// the message are never taken for it.
+func level(fields []string) (i int, l string) {
+	for i = 0; i < len(fields) && i < 3; i++ {
+		var ok bool
+		if l, ok = levels[strings.Trim(fields[i], "[]:")]; ok {
+			return
+		}
+		if !timestamp.MatchString(fields[i]) {
+			break
+		}
+	}
+	return -1, ""
+}
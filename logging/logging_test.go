@@ -0,0 +1,128 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"ec.mleku.dev/v2/lol"
+)
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		name, text string
+		checked    bool
+		want       entry
+	}{
+		{
+			name: "info",
+			text: "\x1b[38;5;242m1729080000.123456\x1b[0m \x1b[32mINF\x1b[0m " +
+				"listening on :https \x1b[38;5;242mmain.go:512\x1b[0m\n",
+			want: entry{Level: "info", TS: "2024-10-16T12:00:00.123456Z",
+				Msg: "listening on :https", Src: "main.go:512"},
+		},
+		{
+			name: "error from chk",
+			text: "1729080000.123456 ERR open mapping.txt: no such file or " +
+				"directory /src/lerproxy/proxy/server.go:120\n",
+			checked: true,
+			want: entry{Level: "error", TS: "2024-10-16T12:00:00.123456Z",
+				Err: "open mapping.txt: no such file or directory",
+				Src: "/src/lerproxy/proxy/server.go:120"},
+		},
+		{
+			name: "level word in the message",
+			text: "1729080000.123456 WRN backend error rate is high for " +
+				"example.com proxy/check.go:40\n",
+			want: entry{Level: "warn", TS: "2024-10-16T12:00:00.123456Z",
+				Msg: "backend error rate is high for example.com",
+				Src: "proxy/check.go:40"},
+		},
+		{
+			name: "several lines",
+			text: "1729080000.123456 INF first line\n  second line " +
+				"main.go:40\n",
+			want: entry{Level: "info", TS: "2024-10-16T12:00:00.123456Z",
+				Msg: "first line\n  second line", Src: "main.go:40"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got entry
+			if err := json.Unmarshal(encode([]byte(tc.text), tc.checked),
+				&got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("encode = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// entries decodes the JSON lines written to b.
+func entries(t *testing.T, b *bytes.Buffer) (es []entry) {
+	t.Helper()
+	for _, line := range strings.SplitAfter(b.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		var e entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%q: %v", line, err)
+		}
+		if e.TS == "" {
+			t.Errorf("%q: no ts", line)
+		}
+		es = append(es, e)
+	}
+	return
+}
+
+// TestLoggers encodes the output of the loggers as each package creates
+// them.
+func TestLoggers(t *testing.T) {
+	var b bytes.Buffer
+	s := &Switch{w: &b, json: true}
+	log, _, _ := lol.New(s)
+	_, chk, _ := lol.New(s.Checks())
+	log.I.Ln("serving\nover two lines")
+	chk.E(errors.New("open mapping.txt: no such file or directory"))
+	if chk.E(nil) {
+		t.Fatal("nil error checked")
+	}
+	es := entries(t, &b)
+	if len(es) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(es), es)
+	}
+	if !strings.HasSuffix(es[0].Msg, "serving\nover two lines") ||
+		es[0].Err != "" {
+		t.Errorf("multi-line entry %+v", es[0])
+	}
+	if es[1].Level != "error" || es[1].Msg != "" ||
+		es[1].Err != "open mapping.txt: no such file or directory" {
+		t.Errorf("checked error entry %+v", es[1])
+	}
+	b.Reset()
+	s.SetJSON(false)
+	chk.E(errors.New("plain"))
+	if !strings.Contains(b.String(), "plain") || strings.Contains(b.String(),
+		"{") {
+		t.Errorf("not passed through verbatim: %q", b.String())
+	}
+}
+
+func TestSplitWrites(t *testing.T) {
+	var b bytes.Buffer
+	s := &Switch{w: &b, json: true}
+	s.Write([]byte("1729080000.123456 INF half "))
+	if b.Len() != 0 {
+		t.Fatalf("incomplete entry written: %q", b.String())
+	}
+	s.Write([]byte("and the rest\n"))
+	if es := entries(t, &b); len(es) != 1 ||
+		es[0].Msg != "half and the rest" {
+		t.Errorf("got %+v", es)
+	}
+}
@@ -3,81 +3,513 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/subtle"
 	"crypto/tls"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	stdLog "log"
 	"net"
 	"net/http"
-	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/alexflint/go-arg"
-	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/netutil"
 	"golang.org/x/sync/errgroup"
-	"lerproxy.mleku.dev/buf"
-	"lerproxy.mleku.dev/hsts"
-	"lerproxy.mleku.dev/reverse"
+	"lerproxy.mleku.dev/abuse"
+	"lerproxy.mleku.dev/accesslog"
+	"lerproxy.mleku.dev/bluegreen"
+	"lerproxy.mleku.dev/dnscache"
+	"lerproxy.mleku.dev/fastopen"
+	"lerproxy.mleku.dev/headerlimit"
+	"lerproxy.mleku.dev/listen"
+	"lerproxy.mleku.dev/logging"
+	"lerproxy.mleku.dev/maintenance"
+	"lerproxy.mleku.dev/prefetch"
+	"lerproxy.mleku.dev/proxy"
+	"lerproxy.mleku.dev/routelog"
+	"lerproxy.mleku.dev/router"
+	"lerproxy.mleku.dev/secret"
+	"lerproxy.mleku.dev/slowbody"
+	"lerproxy.mleku.dev/stats"
 	"lerproxy.mleku.dev/tcpkeepalive"
-	"lerproxy.mleku.dev/util"
+	"lerproxy.mleku.dev/ticketkeys"
+	"lerproxy.mleku.dev/tracing"
+	"lerproxy.mleku.dev/watch"
 )
 
 type runArgs struct {
-	Addr string `arg:"-l,--listen" default:":https" help:"address to listen at"`
-	Conf string `arg:"-m,--map" default:"mapping.txt" help:"file with host/backend mapping"`
+	Addr              string     `arg:"-l,--listen" default:":https" help:"address to listen at"`
+	Network           string     `arg:"--listen-network" default:"tcp" help:"address family the https and http listeners bind: tcp for both IPv4 and IPv6, tcp4 or tcp6 for only one"`
+	Conf              string     `arg:"-m,--map" default:"mapping.txt" help:"file with host/backend mapping, or an http(s) URL to fetch it from"`
+	AllowEmptyMapping bool       `arg:"--allow-empty-mapping" help:"start with no hosts if the mapping file is missing or empty, and pick it up on reload"`
+	Init              *initArgs  `arg:"subcommand:init" help:"write an example mapping file showing each kind of backend to the --map path and exit, there is no rewrites file as body rewrites are given with --rewrite-body"`
+	Prune             *pruneArgs `arg:"subcommand:prune-cache" help:"remove the cached certificates of hosts that are not in the mapping and exit"`
+	Watch             bool       `arg:"--watch" help:"reload the mapping when its file changes, as on SIGHUP"`
+	PrintRoutes       bool       `arg:"--print-routes" help:"print the route, backend kind and target of each line of the mapping, separated by tabs, and exit"`
+	CheckBackends     bool       `arg:"--check-backends" help:"probe each backend once at startup and log which are available"`
+	RequireBackends   bool       `arg:"--require-backends" help:"probe each backend once at startup and fail if any is unavailable"`
 	// Rewrites string        `arg:"-r,--rewrites" default:"rewrites.txt"`
-	Cache string        `arg:"-c,--cachedir" default:"/var/cache/letsencrypt" help:"path to directory to cache key and certificates"`
-	HSTS  bool          `arg:"-h,--hsts" help:"add Strict-Transport-Security header"`
-	Email string        `arg:"-e,--email" help:"contact email address presented to letsencrypt CA"`
-	HTTP  string        `arg:"--http" default:":http" help:"optional address to serve http-to-https redirects and ACME http-01 challenge responses"`
-	RTO   time.Duration `arg:"-r,--rto" default:"1m" help:"maximum duration before timing out read of the request"`
-	WTO   time.Duration `arg:"-w,--wto" default:"5m" help:"maximum duration before timing out write of the response"`
-	Idle  time.Duration `arg:"-i,--idle" help:"how long idle connection is kept before closing (set rto, wto to 0 to use this)"`
-	Certs []string      `arg:"--cert,separate" help:"certificates and the domain they match: eg: mleku.dev:/path/to/cert - this will indicate to load two, one with extension .key and one with .crt, each expected to be PEM encoded TLS private and public keys, respectively"`
+	Cache             string        `arg:"-c,--cachedir" default:"/var/cache/letsencrypt" help:"path to directory to cache key and certificates"`
+	HSTS              bool          `arg:"-h,--hsts" help:"add Strict-Transport-Security header"`
+	Email             string        `arg:"-e,--email" help:"contact email address presented to letsencrypt CA, or a comma separated list of them"`
+	RequireEmail      bool          `arg:"--require-email" help:"fail to start without a contact email for each ACME account, instead of warning"`
+	HTTP              string        `arg:"--http" default:":http" help:"optional address to serve http-to-https redirects and ACME http-01 challenge responses"`
+	RedirectStatus    int           `arg:"--redirect-status" default:"308" help:"status code of redirects from http to https; 307 and 308 preserve the method and body"`
+	RedirectPort      string        `arg:"--redirect-port" help:"public https port that redirects from http point at, when it differs from the one listened on, eg: behind NAT [default: 443]"`
+	ReadHeaderTimeout time.Duration `arg:"--read-header-timeout" default:"5s" help:"maximum duration for reading request headers, 0 to disable"`
+	RTO               time.Duration `arg:"-r,--rto" default:"1m" help:"maximum duration before timing out read of the request"`
+	WTO               time.Duration `arg:"-w,--wto" default:"5m" help:"maximum duration before timing out write of the response"`
+	Idle              time.Duration `arg:"-i,--idle" help:"how long idle connection is kept before closing (set rto, wto to 0 to use this)"`
+	DrainRetryAfter   time.Duration `arg:"--drain-retry-after" default:"30s" help:"Retry-After of the 503 responses to requests while draining for maintenance"`
+	BindRetries       int           `arg:"--bind-retries" help:"how many times to retry listening on an address that is in use, such as by a previous instance that is still stopping"`
+	BindRetryDelay    time.Duration `arg:"--bind-retry-delay" default:"500ms" help:"wait before the first retry of --bind-retries, doubling for each further one"`
+	ShutdownTimeout   time.Duration `arg:"--shutdown-timeout" default:"10s" help:"how long requests and HTTP/2 streams in flight may take to finish when stopping, before their connections are closed"`
+	Certs             []string      `arg:"--cert,separate" help:"certificates and the domain they match: eg: mleku.dev:/path/to/cert - this will indicate to load two, one with extension .key and one with .crt, each expected to be PEM encoded TLS private and public keys, respectively"`
+
+	SRVTTL     time.Duration `arg:"--srv-ttl" default:"30s" help:"how long the DNS SRV records of srv:// backends are cached"`
+	LBStrategy string        `arg:"--lb-strategy" default:"weighted" help:"how srv:// backends choose among their targets: weighted, round-robin or latency"`
+
+	ACMEProfiles     []string `arg:"--acme-profile,separate" help:"additional ACME account with its own cache subdirectory, eg: tenant1:ops@tenant1.com, or a comma separated list of emails, may be repeated"`
+	ACMEProfileHosts []string `arg:"--acme-profile-host,separate" help:"host that obtains certificates with an ACME profile, eg: tenant1.mleku.dev:tenant1, may be repeated"`
+
+	ACMEDirectory string `arg:"--acme-directory" help:"directory URL of the ACME CA, eg: https://localhost:14000/dir for a Pebble test CA [default: LetsEncrypt]"`
+	ACMERootCA    string `arg:"--acme-root-ca" help:"PEM file with the root certificates the ACME CA's https certificate is verified with, instead of the system's"`
+
+	ACMERetry       time.Duration `arg:"--acme-retry" default:"30s" help:"how long to retry obtaining a certificate with backoff after transient ACME errors, 0 to disable"`
+	ACMENegativeTTL time.Duration `arg:"--acme-negative-ttl" default:"1m" help:"how long a failure to obtain a certificate is returned without asking the CA again"`
+
+	AllowAnyHost bool     `arg:"--allow-any-host" help:"obtain certificates for any public hostname a client asks for, not only those in the mapping, CAUTION: each one counts against the ACME rate limits"`
+	DenyHosts    []string `arg:"--deny-host,separate" help:"host refused a certificate with --allow-any-host, eg: *.mleku.dev, may be repeated"`
+
+	IssuingRetryAfter time.Duration `arg:"--issuing-retry-after" help:"answer plain http requests for a host whose certificate is being obtained with 503 and this Retry-After, instead of redirecting them to https"`
+
+	Via             bool     `arg:"--via" help:"add lerproxy to the Via header of requests forwarded to backends and of their responses"`
+	ClientIPHeaders []string `arg:"--client-ip-header,separate" help:"header a host's backend gets the client address in instead of X-Forwarded-For, eg: mleku.dev:True-Client-IP, or *:CF-Connecting-IP for all hosts, may be repeated"`
+	TrustedProxies  []string `arg:"--trusted-proxy,separate" help:"address or CIDR network of a proxy in front of lerproxy whose X-Forwarded-For is passed on to backends, others are replaced by the client address, may be repeated"`
+
+	RewriteLocation []string `arg:"--rewrite-location,separate" help:"host whose backend redirects to its internal address are rewritten to the public host over https, may be repeated"`
+
+	Headers         []string `arg:"--header,separate" help:"response header added for a host unless the backend sets it, eg: 'mleku.dev:Referrer-Policy: no-referrer', may be repeated"`
+	HeadersOverride []string `arg:"--header-override,separate" help:"response header for a host that replaces any the backend sets, in the same form as --header, may be repeated"`
+
+	RewriteBody        []string `arg:"--rewrite-body,separate" help:"string replaced in the response bodies of a host, eg: 'mleku.dev:http://internal:8080 https://mleku.dev', may be repeated"`
+	RewriteBodyTypes   string   `arg:"--rewrite-body-types" default:"text/html" help:"comma separated media types of the responses --rewrite-body applies to"`
+	RewriteBodyMaxSize int64    `arg:"--rewrite-body-max-size" default:"10485760" help:"largest response body in bytes that --rewrite-body applies to"`
+
+	Compress         []string `arg:"--compress,separate" help:"host whose backend responses are compressed for clients that accept it, may be repeated"`
+	CompressEncoders string   `arg:"--compress-encoders" default:"zstd,gzip" help:"comma separated encodings used by --compress, in order of preference"`
+	CompressTypes    string   `arg:"--compress-types" default:"text/*,application/json,application/javascript,application/xml,application/wasm,image/svg+xml" help:"comma separated media types of the responses --compress applies to"`
+	CompressMinSize  int64    `arg:"--compress-min-size" default:"1024" help:"smallest response body in bytes that --compress applies to"`
+
+	VerbatimPath []string `arg:"--verbatim-path,separate" help:"host whose request paths are passed to the backend as they are, instead of redirecting them to their clean form, may be repeated"`
+	AltSvc       []string `arg:"--alt-svc,separate" help:"Alt-Svc header of the responses of a host, to advertise an HTTP/3 endpoint served elsewhere, eg: 'mleku.dev:h3=\":443\"; ma=86400', may be repeated"`
+	RemapStatus  []string `arg:"--remap-status,separate" help:"status of a host's backend responses replaced with another, optionally for the paths matching a pattern, eg: mleku.dev:500=503 or mleku.dev:/api/:500=503, the first match applies, may be repeated"`
+
+	Stream []string `arg:"--stream,separate" help:"host whose backend responses are flushed to the client as each part arrives instead of buffered, may be repeated"`
+
+	Coalesce        []string `arg:"--coalesce,separate" help:"host whose identical GET requests in flight at the same time are sent to the backend once, sharing the response, may be repeated"`
+	CoalesceMaxSize int64    `arg:"--coalesce-max-size" default:"1048576" help:"largest response body in bytes that --coalesce shares, larger ones are fetched for each request"`
+
+	NoKeepAlive []string `arg:"--no-keepalive,separate" help:"host whose backend gets a new connection for each request, sent with Connection: close, may be repeated"`
+
+	StripRequestHeaders  []string `arg:"--strip-request-header,separate" help:"header removed from the requests to all backends, eg: X-Internal-Auth, may be repeated"`
+	StripResponseHeaders []string `arg:"--strip-response-header,separate" help:"header removed from the responses of all backends, eg: X-Internal-Auth, may be repeated"`
+
+	BackendHeader string `arg:"--backend-header" help:"name of a response header reporting the address of the backend that answered, eg: X-Backend, for debugging, as it exposes internal addresses"`
+
+	BackendHosts []string `arg:"--backend-host,separate" help:"Host header sent to a host's unix socket or host:port backend instead of the client's, eg: mleku.dev:app.internal, may be repeated"`
+
+	RouteHeaders []string `arg:"--route-header,separate" help:"backend for the requests to a host with a header value, eg: 'mleku.dev:X-Experiment=beta:127.0.0.1:8081', may be repeated"`
+	RouteCookies []string `arg:"--route-cookie,separate" help:"backend for the requests to a host with a cookie value, eg: 'mleku.dev:experiment=beta:127.0.0.1:8081', may be repeated"`
+
+	Stale []string `arg:"--stale,separate" help:"directory with a snapshot of a host's site served when its backend fails or answers with a server error, eg: mleku.dev:/var/www/snapshot/, may be repeated"`
+
+	Gunzip []string `arg:"--gunzip,separate" help:"host whose gzip encoded backend responses are decompressed for clients that do not accept gzip, may be repeated"`
+
+	Origins []string `arg:"--origin,separate" help:"Origin header sent to a host's backend instead of the client's, or - to remove it, eg: mleku.dev:https://app.internal, may be repeated"`
+
+	Green []string `arg:"--green,separate" help:"second backend of a host that requests can be switched to with the admin server's /switch, eg: 'mleku.dev:127.0.0.1:8081', may be repeated"`
+
+	ClientCAs         []string `arg:"--client-ca,separate" help:"require client certificates signed by a CA for a host, eg: internal.mleku.dev:/path/to/ca.pem, or *:/path/to/ca.pem for all hosts, may be repeated"`
+	ClientCertHeaders []string `arg:"--client-cert-header,separate" help:"header a detail of the client certificate is sent to backends in, for subject, cn, sans and fingerprint, eg: fingerprint:X-SSL-Client-Fingerprint, or sans: to leave it out, may be repeated"`
+
+	DebugHeaders []string `arg:"--debug-headers,separate" help:"host whose forwarded request and response headers are logged at trace level, with credentials redacted, may be repeated"`
+	TraceRouting bool     `arg:"--trace-routing" help:"log the host, route and backend each request was routed to and the address dialed at trace level"`
+
+	StatsInterval time.Duration `arg:"--stats-interval" help:"interval of a log line summarizing requests, errors, connections and backend health, 0 to disable"`
+
+	AdminToken string `arg:"--admin-token" help:"bearer token required by the admin server, or @/path/to/file to read it from a file"`
+
+	Admin string `arg:"--admin" help:"address to serve plain text statistics at /stats, reloads at /reload, blue/green switches at /switch, drain mode at /drain, readiness at /ready and cache pruning at /prune-cache on, eg: 127.0.0.1:8081, or unix:/path/to/socket"`
+
+	ErrorLog string `arg:"--error-log" help:"file that backend errors are appended to instead of the general log"`
+
+	LogJSON bool `arg:"--log-json" help:"write logs as JSON lines with the fields level, ts, msg, host, err and src"`
+
+	OTelEndpoint string `arg:"--otel-endpoint" help:"OTLP/HTTP collector that a trace span for each request and backend request is exported to, eg: http://localhost:4318"`
+
+	AccessLog    bool `arg:"--access-log" help:"log a line of key=value fields for each request"`
+	AccessLogTLS bool `arg:"--access-log-tls" help:"add the TLS version, cipher, SNI and ALPN protocol of each request to the access log, implies --access-log"`
+
+	Systemd bool `arg:"--systemd" help:"use the sockets passed by systemd socket activation, named https and http or else in that order, instead of binding --listen and --http"`
+
+	AbuseThreshold int           `arg:"--abuse-threshold" help:"number of error responses to a client address within --abuse-window after which it is answered with 403 for --abuse-ban, 0 to disable"`
+	AbuseWindow    time.Duration `arg:"--abuse-window" default:"1m" help:"window in which the error responses of --abuse-threshold are counted"`
+	AbuseBan       time.Duration `arg:"--abuse-ban" default:"10m" help:"how long a client address exceeding --abuse-threshold is banned"`
+
+	MaxHeaders        int           `arg:"--max-headers" help:"maximum number of header fields of a request, those with more are answered with 431, 0 for no limit"`
+	MinBodyRate       int64         `arg:"--min-body-rate" help:"minimum bytes per second a request body must arrive at, averaged over --min-body-rate-window, slower requests are aborted, 0 to disable"`
+	MinBodyRateWindow time.Duration `arg:"--min-body-rate-window" default:"10s" help:"window over which --min-body-rate is measured"`
+	MaxConnections    int           `arg:"--max-connections" help:"maximum number of simultaneous connections on each of the https and http listeners, further ones wait to be accepted, 0 for no limit"`
+
+	NoSessionTickets      bool          `arg:"--no-session-tickets" help:"disable TLS session tickets, so that every connection makes a full handshake"`
+	SessionTicketRotation time.Duration `arg:"--session-ticket-rotation" help:"interval at which TLS session ticket keys are replaced, keeping the previous key valid for resumption, eg: 1h [default: Go's own rotation]"`
+	SessionTicketKeys     string        `arg:"--session-ticket-keys" help:"file with hex encoded 32 byte session ticket keys, newest first, re-read at each --session-ticket-rotation to share keys between instances"`
+
+	TCPFastOpen bool `arg:"--tcp-fastopen" help:"enable TCP fast open on the TLS listener (linux only, needs net.ipv4.tcp_fastopen=3)"`
+
+	ALPN string `arg:"--alpn" default:"h2,http/1.1" help:"comma separated protocols negotiated with clients, in order of preference, eg: http/1.1,h2 to prefer HTTP/1.1"`
+
+	AllowMethods []string `arg:"--allow-methods,separate" help:"request methods a host allows, others are answered with 405, eg: mleku.dev:GET,HEAD, may be repeated"`
+	CacheControl []string `arg:"--cache-control,separate" help:"Cache-Control of the files of a static host whose path matches a pattern, eg: 'mleku.dev:/assets/*:public, max-age=31536000, immutable', the first match applies, may be repeated"`
+
+	DefaultHost     string `arg:"--default-host" help:"host that requests without a Host header, as HTTP/1.0 allows, are routed to"`
+	RejectEmptyHost bool   `arg:"--reject-empty-host" help:"answer requests without a Host header with 400 instead of 404"`
+
+	NotFound string `arg:"--not-found" help:"file served with status 404 for requests to hosts that are not in the mapping"`
+	Favicon  string `arg:"--favicon" help:"icon served as /favicon.ico for hosts that are not in the mapping or whose backend has none"`
+
+	Prefetch            bool          `arg:"--prefetch" help:"obtain certificates for all mapped hosts at startup"`
+	PrefetchConcurrency int           `arg:"--prefetch-concurrency" default:"4" help:"maximum number of certificates obtained in parallel when prefetching"`
+	PrefetchDelay       time.Duration `arg:"--prefetch-delay" default:"1s" help:"minimum delay between starting certificate requests when prefetching"`
+
+	DialTimeout      time.Duration `arg:"--dial-timeout" default:"5s" help:"how long connecting to a unix socket, tcp, SRV or gRPC backend may take, 0 for no limit"`
+	ResponseBudgets  []string      `arg:"--response-budget,separate" help:"longest a host waits for the response headers of its backend before cancelling the request and answering 504, eg: api.mleku.dev:2s, may be repeated"`
+	PinDNS           []string      `arg:"--pin-dns,separate" help:"cache the addresses of the hostname of a host's http or https backend for a while, keeping them if resolving fails, eg: mleku.dev:5m, may be repeated"`
+	HostDialTimeouts []string      `arg:"--host-dial-timeout,separate" help:"dial timeout for a host's backend instead of --dial-timeout, eg: ws.mleku.dev:30s, may be repeated"`
+
+	ExecTimeout   time.Duration `arg:"--exec-timeout" default:"30s" help:"maximum duration an exec: backend process may run for a request"`
+	ExecMaxOutput int64         `arg:"--exec-max-output" default:"10485760" help:"maximum number of bytes read from an exec: backend process' output"`
 }
 
 var args runArgs
 
+// watchDelay is how long --watch waits for writes to the mapping to stop
+// before reloading it, so that it is not read half written.
+const watchDelay = 500 * time.Millisecond
+
+// config returns the proxy configuration given by the arguments, with the
+// requests to backends counted in st.
+func (a runArgs) config(errorLog *stdLog.Logger,
+	st *stats.Stats) proxy.Config {
+
+	return proxy.Config{
+		Mapping:              a.Conf,
+		AllowEmptyMapping:    a.AllowEmptyMapping,
+		CheckBackends:        a.CheckBackends,
+		RequireBackends:      a.RequireBackends,
+		DialTimeout:          a.DialTimeout,
+		HostDialTimeouts:     a.HostDialTimeouts,
+		ResponseBudgets:      a.ResponseBudgets,
+		PinDNS:               a.PinDNS,
+		Cache:                a.Cache,
+		Email:                a.Email,
+		RequireEmail:         a.RequireEmail,
+		ACMEProfiles:         a.ACMEProfiles,
+		ACMEProfileHosts:     a.ACMEProfileHosts,
+		ACMEDirectory:        a.ACMEDirectory,
+		ACMERootCA:           a.ACMERootCA,
+		ACMERetry:            a.ACMERetry,
+		ACMENegativeTTL:      a.ACMENegativeTTL,
+		IssuingRetryAfter:    a.IssuingRetryAfter,
+		AllowAnyHost:         a.AllowAnyHost,
+		DenyHosts:            a.DenyHosts,
+		HSTS:                 a.HSTS,
+		RedirectStatus:       a.RedirectStatus,
+		RedirectPort:         a.RedirectPort,
+		Certs:                a.Certs,
+		ALPN:                 strings.Split(a.ALPN, ","),
+		AllowMethods:         a.AllowMethods,
+		CacheControl:         a.CacheControl,
+		NotFound:             a.NotFound,
+		Favicon:              a.Favicon,
+		DefaultHost:          a.DefaultHost,
+		RejectEmptyHost:      a.RejectEmptyHost,
+		ExecTimeout:          a.ExecTimeout,
+		ExecMaxOutput:        a.ExecMaxOutput,
+		SRVTTL:               a.SRVTTL,
+		LBStrategy:           a.LBStrategy,
+		TrustedProxies:       a.TrustedProxies,
+		ClientIPHeaders:      a.ClientIPHeaders,
+		Via:                  a.Via,
+		BackendHeader:        a.BackendHeader,
+		StripRequestHeaders:  a.StripRequestHeaders,
+		StripResponseHeaders: a.StripResponseHeaders,
+		RewriteLocation:      a.RewriteLocation,
+		DebugHeaders:         a.DebugHeaders,
+		TraceRouting:         a.TraceRouting,
+		Headers:              a.Headers,
+		HeadersOverride:      a.HeadersOverride,
+		RewriteBody:          a.RewriteBody,
+		RewriteBodyTypes:     strings.Split(a.RewriteBodyTypes, ","),
+		RewriteBodyMaxSize:   a.RewriteBodyMaxSize,
+		Gunzip:               a.Gunzip,
+		Origins:              a.Origins,
+		Stale:                a.Stale,
+		RouteHeaders:         a.RouteHeaders,
+		RouteCookies:         a.RouteCookies,
+		BackendHosts:         a.BackendHosts,
+		NoKeepAlive:          a.NoKeepAlive,
+		Stream:               a.Stream,
+		AltSvc:               a.AltSvc,
+		RemapStatus:          a.RemapStatus,
+		Coalesce:             a.Coalesce,
+		CoalesceMaxSize:      a.CoalesceMaxSize,
+		VerbatimPath:         a.VerbatimPath,
+		Compress:             a.Compress,
+		CompressEncoders:     strings.Split(a.CompressEncoders, ","),
+		CompressTypes:        strings.Split(a.CompressTypes, ","),
+		CompressMinSize:      a.CompressMinSize,
+		ErrorLog:             errorLog,
+		InstrumentBackend:    st.Backend,
+		Tracing:              a.OTelEndpoint != "",
+		ClientCAs:            a.ClientCAs,
+		ClientCertHeaders:    a.ClientCertHeaders,
+		Green:                a.Green,
+	}
+}
+
+// stopSignals shut the proxy down gracefully. systemd and container runtimes
+// stop services with SIGTERM, so it is treated the same as an interrupt from
+// the terminal.
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// server returns the https server of handler, with the timeouts given by the
+// arguments.
+func (a runArgs) server(handler http.Handler,
+	tlsConfig *tls.Config) (srv *http.Server) {
+
+	srv = &http.Server{
+		Handler:   handler,
+		Addr:      a.Addr,
+		TLSConfig: tlsConfig,
+		// for the tunnels of connect: backends over HTTP/2.
+		ConnContext: router.ConnContext,
+	}
+	srv.ReadHeaderTimeout = a.ReadHeaderTimeout
+	if a.RTO > 0 {
+		srv.ReadTimeout = a.RTO
+	}
+	if a.WTO > 0 {
+		srv.WriteTimeout = a.WTO
+	}
+	return
+}
+
+// limit returns ln accepting at most --max-connections connections at a
+// time, or ln itself without a limit.
+func (a runArgs) limit(ln net.Listener) net.Listener {
+	if a.MaxConnections > 0 {
+		return netutil.LimitListener(ln, a.MaxConnections)
+	}
+	return ln
+}
+
 func main() {
 	arg.MustParse(&args)
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	logging.SetJSON(args.LogJSON)
+	ctx, cancel := signal.NotifyContext(context.Background(),
+		stopSignals...)
 	defer cancel()
 	if err := run(ctx, args); err != nil {
 		log.F.Ln(err)
+		cancel()
+		os.Exit(1)
 	}
 }
 
 func run(ctx context.Context, args runArgs) (err error) {
 
+	if args.Init != nil {
+		return writeExample(args.Conf, args.Init.Force)
+	}
+
+	if args.Prune != nil {
+		return pruneCache(args)
+	}
+
+	if args.PrintRoutes {
+		var mapping map[string]string
+		if mapping, err = proxy.ReadMapping(args.Conf); chk.E(err) {
+			return
+		}
+		return proxy.WriteRoutes(os.Stdout, mapping)
+	}
+
 	if args.Cache == "" {
 		err = log.E.Err("no cache specified")
 		return
 	}
+	switch args.Network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		err = log.E.Err("invalid listen network %q, want tcp, tcp4 or tcp6",
+			args.Network)
+		return
+	}
 
-	var srv *http.Server
-	var httpHandler http.Handler
-	if srv, httpHandler, err = setupServer(args); chk.E(err) {
+	var s *proxy.Server
+	var errorLog *stdLog.Logger
+	if args.ErrorLog != "" {
+		var f *os.File
+		if f, err = os.OpenFile(args.ErrorLog,
+			os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640); chk.E(err) {
+			return
+		}
+		defer f.Close()
+		errorLog = stdLog.New(f, "", stdLog.LstdFlags)
+	}
+	st := &stats.Stats{}
+	if s, err = proxy.New(args.config(errorLog, st)); chk.E(err) {
 		return
 	}
-	srv.ReadHeaderTimeout = 5 * time.Second
-	if args.RTO > 0 {
-		srv.ReadTimeout = args.RTO
+	s.TLSConfig.GetCertificate = st.GetCertificate(s.TLSConfig.GetCertificate)
+	var handler http.Handler = s
+	if args.TraceRouting {
+		handler = routelog.Handler{Handler: handler}
+	}
+	if args.MaxHeaders > 0 {
+		handler = &headerlimit.Handler{Handler: handler, Max: args.MaxHeaders}
+	}
+	var tracker *abuse.Tracker
+	if args.AbuseThreshold > 0 {
+		tracker = &abuse.Tracker{
+			Threshold: args.AbuseThreshold,
+			Window:    args.AbuseWindow,
+			Ban:       args.AbuseBan,
+		}
+		handler = tracker.Handler(handler)
+	}
+	// outside the tracker, so that the 503s do not get clients banned.
+	maint := &maintenance.Drain{RetryAfter: args.DrainRetryAfter}
+	handler = maint.Handler(handler)
+	if args.OTelEndpoint != "" {
+		var shutdown func(context.Context) error
+		if shutdown, err = tracing.Setup(ctx, args.OTelEndpoint); chk.E(err) {
+			return
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(),
+				5*time.Second)
+			defer cancel()
+			chk.E(shutdown(ctx))
+		}()
+		handler = tracing.Handler(handler)
+	}
+	httpHandler := s.Challenge
+	if args.AccessLog || args.AccessLogTLS {
+		handler = &accesslog.Handler{Handler: handler, TLS: args.AccessLogTLS}
+		httpHandler = &accesslog.Handler{Handler: httpHandler}
 	}
-	if args.WTO > 0 {
-		srv.WriteTimeout = args.WTO
+	handler = st.Handler(handler)
+	if args.MinBodyRate > 0 {
+		// outermost, so that it can set the read deadlines of the connection.
+		handler = &slowbody.Handler{Handler: handler,
+			MinRate: args.MinBodyRate, Window: args.MinBodyRateWindow}
+	}
+	srv := args.server(handler, s.TLSConfig)
+	srv.ConnState = st.ConnState
+	var tlsLn, httpLn net.Listener
+	if args.Systemd {
+		var sockets []listen.Socket
+		var ok bool
+		if sockets, ok, err = listen.Systemd(); chk.E(err) {
+			return
+		}
+		if ok {
+			tlsLn = listen.Pick(sockets, "https", 0)
+			if httpLn = listen.Pick(sockets, "http", 1); httpLn == tlsLn {
+				httpLn = nil
+			}
+			log.I.Ln("using", len(sockets), "sockets passed by systemd")
+		} else {
+			log.W.Ln("not socket activated by systemd, binding addresses")
+		}
 	}
 	group, ctx := errgroup.WithContext(ctx)
-	if args.HTTP != "" {
+	group.Go(func() error {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, append(drainSignals, syscall.SIGHUP)...)
+		defer signal.Stop(sigs)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case sig := <-sigs:
+				if sig != syscall.SIGHUP {
+					maint.Toggle()
+					continue
+				}
+				log.I.Ln("reloading mapping from", args.Conf)
+				// a failed reload keeps the previous configuration running.
+				chk.E(s.Reload())
+			}
+		}
+	})
+	if args.Watch && proxy.IsURL(args.Conf) {
+		log.W.Ln("--watch only applies to a mapping file, reload a mapping " +
+			"URL with SIGHUP or the admin server's /reload")
+	} else if args.Watch {
+		group.Go(func() error {
+			return watch.File(ctx, args.Conf, watchDelay, func() {
+				log.I.Ln("reloading changed mapping from", args.Conf)
+				chk.E(s.Reload())
+			})
+		})
+	}
+	if args.NoSessionTickets {
+		s.TLSConfig.SessionTicketsDisabled = true
+		if args.SessionTicketRotation > 0 || args.SessionTicketKeys != "" {
+			log.W.Ln("session ticket keys are not used with --no-session-tickets")
+		}
+	} else if args.SessionTicketKeys != "" &&
+		args.SessionTicketRotation == 0 {
+
+		args.SessionTicketRotation = time.Hour
+	}
+	if !args.NoSessionTickets && args.SessionTicketRotation > 0 {
+		r := &ticketkeys.Rotator{
+			Config:   s.TLSConfig,
+			Interval: args.SessionTicketRotation,
+			File:     args.SessionTicketKeys,
+		}
+		// the keys must be in place before the first handshake.
+		if err = r.Rotate(); chk.E(err) {
+			return
+		}
+		group.Go(func() error { return r.Run(ctx) })
+	}
+	if args.StatsInterval > 0 {
+		group.Go(func() error {
+			logStats(ctx, s, st, args.StatsInterval)
+			return nil
+		})
+	}
+	if args.Prefetch {
+		group.Go(func() error {
+			prefetch.Certificates(ctx, s.TLSConfig.GetCertificate, s.Hosts(),
+				args.PrefetchConcurrency, args.PrefetchDelay)
+			return nil
+		})
+	}
+	if args.HTTP != "" || httpLn != nil {
 		httpServer := http.Server{
 			Addr:         args.HTTP,
 			Handler:      httpHandler,
@@ -85,269 +517,341 @@ func run(ctx context.Context, args runArgs) (err error) {
 			WriteTimeout: 10 * time.Second,
 		}
 		group.Go(func() (err error) {
-			chk.E(httpServer.ListenAndServe())
+			ln := httpLn
+			if ln == nil {
+				if ln, err = bind(ctx, func() (net.Listener, error) {
+					return net.Listen(args.Network, args.HTTP)
+				}); chk.E(err) {
+					return
+				}
+			}
+			if err = httpServer.Serve(args.limit(ln)); errors.Is(err,
+				http.ErrServerClosed) {
+
+				err = nil
+			}
+			chk.E(err)
 			return
 		})
 		group.Go(func() error {
-			<-ctx.Done()
-			ctx, cancel := context.WithTimeout(context.Background(),
-				time.Second)
-			defer cancel()
-			return httpServer.Shutdown(ctx)
+			return drain(ctx, &httpServer, args.ShutdownTimeout)
 		})
 	}
-	if srv.ReadTimeout != 0 || srv.WriteTimeout != 0 || args.Idle == 0 {
-		group.Go(func() (err error) {
-			chk.E(srv.ListenAndServeTLS("", ""))
-			return
+	if args.Admin != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+			st.ServeHTTP(w, r)
+			fmt.Fprintf(w, "dns_lookup_failures_total=%d\n",
+				dnscache.Failures())
+			if tracker != nil {
+				fmt.Fprintf(w, "bans_active=%d\n", tracker.Bans())
+			}
 		})
-	} else {
+		mux.Handle("/reload", reload(s))
+		mux.Handle("/switch", switchGroup(s))
+		mux.Handle("/drain", drainMode(maint))
+		mux.HandleFunc("/ready", maint.Ready)
+		mux.Handle("/prune-cache", prune(s))
+		var adminHandler http.Handler = mux
+		if args.AdminToken != "" {
+			var token string
+			if token, err = secret.Resolve(args.AdminToken); chk.E(err) {
+				return
+			}
+			adminHandler = bearer(token, mux)
+		}
+		adminServer := http.Server{
+			Addr:              args.Admin,
+			Handler:           adminHandler,
+			ReadHeaderTimeout: 5 * time.Second,
+		}
 		group.Go(func() (err error) {
 			var ln net.Listener
-			if ln, err = net.Listen("tcp", srv.Addr); chk.E(err) {
+			if ln, err = bind(ctx, func() (net.Listener, error) {
+				return listen.Listen(ctx, &net.ListenConfig{}, args.Admin)
+			}); chk.E(err) {
 				return
 			}
-			defer ln.Close()
-			ln = tcpkeepalive.Listener{
-				Duration:    args.Idle,
-				TCPListener: ln.(*net.TCPListener),
+			if err = adminServer.Serve(ln); errors.Is(err,
+				http.ErrServerClosed) {
+				err = nil
 			}
-			err = srv.ServeTLS(ln, "", "")
 			chk.E(err)
 			return
 		})
+		group.Go(func() error {
+			<-ctx.Done()
+			ctx, cancel := context.WithTimeout(context.Background(),
+				time.Second)
+			defer cancel()
+			return adminServer.Shutdown(ctx)
+		})
 	}
+	var lc net.ListenConfig
+	if args.TCPFastOpen {
+		if fastopen.Supported {
+			lc.Control = fastopen.Control
+		} else {
+			log.W.Ln("TCP fast open is not supported on", runtime.GOOS)
+		}
+	}
+	group.Go(func() (err error) {
+		ln := tlsLn
+		if ln == nil {
+			if ln, err = bind(ctx, func() (net.Listener, error) {
+				return lc.Listen(ctx, args.Network, srv.Addr)
+			}); chk.E(err) {
+				return
+			}
+		}
+		defer ln.Close()
+		tl, ok := ln.(*net.TCPListener)
+		keepalive := ok && srv.ReadTimeout == 0 && srv.WriteTimeout == 0 &&
+			args.Idle != 0
+		if keepalive {
+			ln = tcpkeepalive.Listener{
+				Duration:    args.Idle,
+				TCPListener: tl,
+			}
+		}
+		logTimeouts(srv, keepalive, args.Idle)
+		if args.MaxConnections > 0 {
+			log.I.F("accepting at most %d connections at a time on each "+
+				"listener", args.MaxConnections)
+		}
+		ln = args.limit(ln)
+		// serving the TLS config itself rather than the copy ServeTLS makes
+		// lets the session ticket keys be rotated.
+		if err = srv.Serve(tls.NewListener(ln, srv.TLSConfig)); errors.Is(err,
+			http.ErrServerClosed) {
+			err = nil
+		}
+		chk.E(err)
+		return
+	})
 	group.Go(func() error {
-		<-ctx.Done()
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
-		return srv.Shutdown(ctx)
+		return drain(ctx, srv, args.ShutdownTimeout)
 	})
 	return group.Wait()
 }
 
-// TLSConfig returns a TLSConfig that works with a LetsEncrypt automatic SSL cert issuer as well
-// as any provided .pem certificates from providers.
-//
-// The certs are provided in the form "example.com:/path/to/cert.pem"
-func TLSConfig(m *autocert.Manager, certs ...string) (tc *tls.Config) {
-	certMap := make(map[S]*tls.Certificate)
-	var mx sync.Mutex
-	for _, cert := range certs {
-		split := strings.Split(cert, ":")
-		if len(split) != 2 {
-			log.E.F("invalid certificate parameter format: `%s`", cert)
-			continue
-		}
-		var err E
-		var c tls.Certificate
-		if c, err = tls.LoadX509KeyPair(split[1]+".crt", split[1]+".key"); chk.E(err) {
-			continue
-		}
-		certMap[split[0]] = &c
-	}
-	tc = m.TLSConfig()
-	tc.GetCertificate = func(helo *tls.ClientHelloInfo) (cert *tls.Certificate, err E) {
-		mx.Lock()
-		var own S
-		for i := range certMap {
-			// to also handle explicit subdomain certs, prioritize over a root wildcard.
-			if helo.ServerName == i {
-				own = i
-				break
-			}
-			// if it got to us and ends in the same name dot tld assume the subdomain was
-			// redirected or it's a wildcard certificate, thus only the ending needs to match.
-			if strings.HasSuffix(helo.ServerName, i) {
-				own = i
-				break
-			}
-		}
-		if own != "" {
-			defer mx.Unlock()
-			return certMap[own], nil
-		}
-		mx.Unlock()
-		return m.GetCertificate(helo)
-	}
-	return
+// bind listens with l, retrying with backoff as given by --bind-retries and
+// --bind-retry-delay while the address is in use.
+func bind(ctx context.Context, l func() (net.Listener, error)) (net.Listener,
+	error) {
+
+	return listen.Retry(ctx, args.BindRetries, args.BindRetryDelay, l)
 }
 
-func setupServer(a runArgs) (s *http.Server, h http.Handler, err error) {
-	var mapping map[string]string
-	if mapping, err = readMapping(a.Conf); chk.E(err) {
-		return
-	}
-	var proxy http.Handler
-	if proxy, err = setProxy(mapping); chk.E(err) {
-		return
-	}
-	if a.HSTS {
-		proxy = &hsts.Proxy{Handler: proxy}
-	}
-	if err = os.MkdirAll(a.Cache, 0700); chk.E(err) {
-		err = fmt.Errorf("cannot create cache directory %q: %v",
-			a.Cache, err)
-		chk.E(err)
-		return
-	}
-	m := autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		Cache:      autocert.DirCache(a.Cache),
-		HostPolicy: autocert.HostWhitelist(util.GetKeys(mapping)...),
-		Email:      a.Email,
-	}
-	s = &http.Server{
-		Handler:   proxy,
-		Addr:      a.Addr,
-		TLSConfig: TLSConfig(&m, a.Certs...),
+// drain shuts srv down once ctx is done, letting the requests in flight
+// finish for up to timeout before closing their connections. HTTP/2 clients
+// are sent a GOAWAY, so that they open no new streams on the connection
+// while the open ones finish.
+func drain(ctx context.Context, srv *http.Server,
+	timeout time.Duration) (err error) {
+
+	<-ctx.Done()
+	sctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err = srv.Shutdown(sctx); errors.Is(err, context.DeadlineExceeded) {
+		log.W.F("requests still in flight after %v, closing their "+
+			"connections", timeout)
+		err = srv.Close()
 	}
-	h = m.HTTPHandler(nil)
 	return
 }
 
-type NostrJSON struct {
-	Names  map[string]string   `json:"names"`
-	Relays map[string][]string `json:"relays"`
+// bearer passes requests with the given bearer token to h, and answers
+// others with 401.
+func bearer(token string, h http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// reload answers POST requests by reloading what the query names, the
+// mapping by default, as SIGHUP does.
+func reload(s *proxy.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed)
+			return
+		}
+		switch what := r.URL.Query().Get("what"); what {
+		case "", "mapping":
+			log.I.Ln("reloading mapping from", args.Conf, "for", r.RemoteAddr)
+			if err := s.Reload(); chk.E(err) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		case "rewrites":
+			// there is no rewrites file, body rewrites are flags.
+			http.Error(w, "cannot reload rewrites, --rewrite-body is only "+
+				"read at startup", http.StatusBadRequest)
+			return
+		default:
+			http.Error(w, fmt.Sprintf("cannot reload %q, only mapping", what),
+				http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintln(w, "reloaded")
+	})
 }
 
-func setProxy(mapping map[string]string) (h http.Handler, err error) {
-	if len(mapping) == 0 {
-		return nil, fmt.Errorf("empty mapping")
-	}
-	mux := http.NewServeMux()
-	for hostname, backendAddr := range mapping {
-		hn, ba := hostname, backendAddr
-		if strings.ContainsRune(hn, os.PathSeparator) {
-			err = log.E.Err("invalid hostname: %q", hn)
+// drainMode answers POST requests by turning drain mode on, or off with
+// on=false in the query, as SIGUSR1 toggles it.
+func drainMode(d *maintenance.Drain) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed)
 			return
 		}
-		network := "tcp"
-		if ba != "" && ba[0] == '@' && runtime.GOOS == "linux" {
-			// append \0 to address so addrlen for connect(2) is calculated in a
-			// way compatible with some other implementations (i.e. uwsgi)
-			network, ba = "unix", ba+string(byte(0))
-		} else if strings.HasPrefix(ba, "git+") {
-			split := strings.Split(ba, "git+")
-			if len(split) != 2 {
-				log.E.Ln("invalid go vanity redirect: %s: %s", hn, ba)
-				continue
+		on := true
+		if v := r.URL.Query().Get("on"); v != "" {
+			var err error
+			if on, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid on %q", v),
+					http.StatusBadRequest)
+				return
 			}
-			redirector := fmt.Sprintf(
-				`<html><head><meta name="go-import" content="%s git %s"/><meta http-equiv = "refresh" content = " 3 ; url = %s"/></head><body>redirecting to <a href="%s">%s</a></body></html>`,
-				hn, split[1], split[1], split[1], split[1])
-			mux.HandleFunc(hn+"/", func(writer http.ResponseWriter, request *http.Request) {
-				writer.Header().Set("Access-Control-Allow-Methods",
-					"GET,HEAD,PUT,PATCH,POST,DELETE")
-				writer.Header().Set("Access-Control-Allow-Origin", "*")
-				writer.Header().Set("Content-Type", "text/html")
-				writer.Header().Set("Content-Length", fmt.Sprint(len(redirector)))
-				writer.Header().Set("strict-transport-security", "max-age=0; includeSubDomains")
-				fmt.Fprint(writer, redirector)
-			})
-			continue
-		} else if filepath.IsAbs(ba) {
-			network = "unix"
-			switch {
-			case strings.HasSuffix(ba, string(os.PathSeparator)):
-				// path specified as directory with explicit trailing slash; add
-				// this path as static site
-				fs := http.FileServer(http.Dir(ba))
-				mux.Handle(hn+"/", fs)
-				continue
-			case strings.HasSuffix(ba, "nostr.json"):
-				log.I.Ln(hn, ba)
-				var fb []byte
-				if fb, err = os.ReadFile(ba); chk.E(err) {
-					continue
-				}
-				var v NostrJSON
-				if err = json.Unmarshal(fb, &v); chk.E(err) {
-					continue
-				}
-				var jb []byte
-				if jb, err = json.Marshal(v); chk.E(err) {
-					continue
-				}
-				nostrJSON := string(jb)
-				mux.HandleFunc(hn+"/.well-known/nostr.json",
-					func(writer http.ResponseWriter, request *http.Request) {
-						log.I.Ln("serving nostr json to", hn)
-						writer.Header().Set("Access-Control-Allow-Methods",
-							"GET,HEAD,PUT,PATCH,POST,DELETE")
-						writer.Header().Set("Access-Control-Allow-Origin", "*")
-						writer.Header().Set("Content-Type", "application/json")
-						writer.Header().Set("Content-Length", fmt.Sprint(len(nostrJSON)))
-						writer.Header().Set("strict-transport-security",
-							"max-age=0; includeSubDomains")
-						fmt.Fprint(writer, nostrJSON)
-					})
-				continue
+		}
+		log.I.F("drain mode set to %v by %s", on, r.RemoteAddr)
+		d.Set(on)
+		if on {
+			fmt.Fprintln(w, "draining")
+			return
+		}
+		fmt.Fprintln(w, "serving")
+	})
+}
+
+// prune answers POST requests by removing the cached certificates of the
+// hosts that are not in the mapping, listing the files removed, or only
+// listing them with dry-run=true in the query.
+func prune(s *proxy.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed)
+			return
+		}
+		var dryRun bool
+		if v := r.URL.Query().Get("dry-run"); v != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid dry-run %q", v),
+					http.StatusBadRequest)
+				return
 			}
-		} else if u, err := url.Parse(ba); err == nil {
-			switch u.Scheme {
-			case "http", "https":
-				rp := reverse.NewSingleHostReverseProxy(u)
-				modifyCORSResponse := func(res *http.Response) error {
-					res.Header.Set("Access-Control-Allow-Methods",
-						"GET,HEAD,PUT,PATCH,POST,DELETE")
-					// res.Header.Set("Access-Control-Allow-Credentials", "true")
-					res.Header.Set("Access-Control-Allow-Origin", "*")
-					return nil
-				}
-				rp.ModifyResponse = modifyCORSResponse
-				rp.ErrorLog = stdLog.New(os.Stderr, "lerproxy", stdLog.Llongfile)
-				rp.BufferPool = buf.Pool{}
-				mux.Handle(hn+"/", rp)
-				continue
+		}
+		log.I.F("pruning the certificate cache for %s, dry run %v",
+			r.RemoteAddr, dryRun)
+		pruned, err := s.PruneCache(dryRun)
+		if chk.E(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, file := range pruned {
+			fmt.Fprintln(w, file)
+		}
+	})
+}
+
+// switchGroup answers POST requests by sending the share of the requests for
+// the host given in the query to the group given, all of them unless a
+// canary percentage is given.
+func switchGroup(s *proxy.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+				http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		to, err := bluegreen.ParseGroup(q.Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		percent := 100
+		if c := q.Get("canary"); c != "" {
+			if percent, err = strconv.Atoi(c); err != nil {
+				http.Error(w, "invalid canary percentage", http.StatusBadRequest)
+				return
 			}
 		}
-		rp := &httputil.ReverseProxy{
-			Director: func(req *http.Request) {
-				req.URL.Scheme = "http"
-				req.URL.Host = req.Host
-				req.Header.Set("X-Forwarded-Proto", "https")
-				req.Header.Set("X-Forwarded-For", req.RemoteAddr)
-				req.Header.Set("Access-Control-Allow-Methods", "GET,HEAD,PUT,PATCH,POST,DELETE")
-				// req.Header.Set("Access-Control-Allow-Credentials", "true")
-				req.Header.Set("Access-Control-Allow-Origin", "*")
-				log.D.Ln(req.URL, req.RemoteAddr)
-			},
-			Transport: &http.Transport{
-				DialContext: func(ctx context.Context, n, addr string) (net.Conn, error) {
-					return net.DialTimeout(network, ba, 5*time.Second)
-				},
-			},
-			ErrorLog:   stdLog.New(io.Discard, "", 0),
-			BufferPool: buf.Pool{},
-		}
-		mux.Handle(hn+"/", rp)
-	}
-	return mux, nil
+		if err = s.Switch(q.Get("host"), to, percent); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%d%% of %s to %s\n", percent, q.Get("host"), to)
+	})
 }
 
-func readMapping(file string) (m map[string]string, err error) {
-	var f *os.File
-	if f, err = os.Open(file); chk.E(err) {
-		return
-	}
-	m = make(map[string]string)
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		if b := sc.Bytes(); len(b) == 0 || b[0] == '#' {
-			continue
-		}
-		s := strings.SplitN(sc.Text(), ":", 2)
-		if len(s) != 2 {
-			err = fmt.Errorf("invalid line: %q", sc.Text())
-			log.E.Ln(err)
-			chk.E(f.Close())
+// logStats logs a summary of the requests since the previous one, the open
+// connections and the health of the backends every interval until ctx is
+// done.
+func logStats(ctx context.Context, s *proxy.Server, st *stats.Stats,
+	interval time.Duration) {
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var lastRequests, lastErrors int64
+	for {
+		select {
+		case <-ctx.Done():
 			return
+		case <-t.C:
+		}
+		requests, errs, active := st.Totals()
+		n, e := requests-lastRequests, errs-lastErrors
+		lastRequests, lastErrors = requests, errs
+		var rate float64
+		if n > 0 {
+			rate = 100 * float64(e) / float64(n)
 		}
-		m[strings.TrimSpace(s[0])] = strings.TrimSpace(s[1])
+		healthy, unhealthy := s.ProbeBackends(ctx)
+		log.I.F("stats: %d requests in %v, %.1f%% server errors, %d "+
+			"connections open, %d backends healthy, %d unhealthy", n,
+			interval, rate, active, healthy, unhealthy)
+	}
+}
+
+// logTimeouts logs the timeouts in effect on the TLS listener, and whether
+// connections are closed after being idle, which only happens with the
+// keepalive listener when the read and write timeouts are disabled.
+func logTimeouts(srv *http.Server, keepalive bool, idle time.Duration) {
+	log.I.F("timeouts: read header %v, read %v, write %v (0 is unlimited)",
+		srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout)
+	if keepalive {
+		log.I.F("keepalive listener: connections idle for %v are closed, "+
+			"TCP keepalive every %v", idle, tcpkeepalive.Period)
+		return
+	}
+	if idle != 0 {
+		log.W.F("--idle %v has no effect unless --rto and --wto are both 0",
+			idle)
+	}
+	// without an IdleTimeout, the server uses the read timeout for idle
+	// connections.
+	if srv.ReadTimeout > 0 {
+		log.I.F("plain listener: idle connections are closed after %v",
+			srv.ReadTimeout)
+	} else {
+		log.I.Ln("plain listener: idle connections are kept until the " +
+			"client closes them")
 	}
-	err = sc.Err()
-	chk.E(err)
-	chk.E(f.Close())
-	return
 }
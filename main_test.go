@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexflint/go-arg"
+	"lerproxy.mleku.dev/proxy"
+)
+
+// parse returns the arguments parsed from the command line cl.
+func parse(t *testing.T, cl ...string) (a runArgs) {
+	t.Helper()
+	p, err := arg.NewParser(arg.Config{}, &a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Parse(cl); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cl   []string
+		want time.Duration
+	}{
+		{"default", nil, 5 * time.Second},
+		{"set", []string{"--read-header-timeout", "30s"}, 30 * time.Second},
+		{"disabled", []string{"--read-header-timeout", "0"}, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := parse(t, tc.cl...).server(nil, nil)
+			if srv.ReadHeaderTimeout != tc.want {
+				t.Errorf("ReadHeaderTimeout = %v, want %v",
+					srv.ReadHeaderTimeout, tc.want)
+			}
+		})
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "ok")
+		})}
+	go srv.Serve(parse(t, "--max-connections", "2").limit(ln))
+	defer srv.Close()
+	// each connection sends a request and keeps the connection open.
+	var conns []net.Conn
+	var readers []*bufio.Reader
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if _, err = io.WriteString(c,
+			"GET / HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+			t.Fatal(err)
+		}
+		conns = append(conns, c)
+		readers = append(readers, bufio.NewReader(c))
+	}
+	read := func(i int, timeout time.Duration) (err error) {
+		conns[i].SetReadDeadline(time.Now().Add(timeout))
+		_, err = http.ReadResponse(readers[i], nil)
+		return
+	}
+	for i := 0; i < 2; i++ {
+		if err = read(i, 2*time.Second); err != nil {
+			t.Fatalf("connection %d: %v", i+1, err)
+		}
+	}
+	// the third waits to be accepted while the others are open...
+	if err = read(2, 200*time.Millisecond); err == nil {
+		t.Fatal("connection 3 was served past the limit")
+	}
+	// ...and is served once one of them closes.
+	conns[0].Close()
+	if err = read(2, 2*time.Second); err != nil {
+		t.Errorf("connection 3 after connection 1 closed: %v", err)
+	}
+}
+
+func TestNoMaxConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if l := parse(t).limit(ln); l != ln {
+		t.Errorf("listener wrapped without --max-connections")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	mapping := filepath.Join(dir, "mapping.txt")
+	if err := os.WriteFile(mapping, []byte("one.test: 127.0.0.1:1\n"),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	s, err := proxy.New(proxy.Config{Mapping: mapping,
+		Cache: filepath.Join(dir, "cache")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := reload(s)
+	do := func(method, target string) (status int, body string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+		return w.Code, w.Body.String()
+	}
+	if err = os.WriteFile(mapping, []byte("two.test: 127.0.0.1:1\n"),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		method, target string
+		status         int
+		hosts          string
+	}{
+		{http.MethodGet, "/reload?what=mapping", http.StatusMethodNotAllowed,
+			"one.test"},
+		{http.MethodPost, "/reload?what=rewrites", http.StatusBadRequest,
+			"one.test"},
+		{http.MethodPost, "/reload?what=certs", http.StatusBadRequest,
+			"one.test"},
+		{http.MethodPost, "/reload?what=mapping", http.StatusOK, "two.test"},
+	} {
+		status, body := do(tc.method, tc.target)
+		if status != tc.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tc.method, tc.target,
+				status, tc.status, body)
+		}
+		if hosts := strings.Join(s.Hosts(), ","); hosts != tc.hosts {
+			t.Errorf("%s %s: hosts %s, want %s", tc.method, tc.target,
+				hosts, tc.hosts)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		work, timeout time.Duration
+		finished      bool
+	}{
+		{"finishes", 200 * time.Millisecond, 2 * time.Second, true},
+		{"cut off", 5 * time.Second, 100 * time.Millisecond, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{})
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(
+				func(w http.ResponseWriter, r *http.Request) {
+					close(started)
+					select {
+					case <-time.After(tc.work):
+						io.WriteString(w, "done")
+					case <-r.Context().Done():
+					}
+				}))
+			srv.Start()
+			defer srv.Close()
+			type result struct {
+				body string
+				err  error
+			}
+			got := make(chan result, 1)
+			go func() {
+				res, err := http.Get(srv.URL)
+				if err != nil {
+					got <- result{err: err}
+					return
+				}
+				defer res.Body.Close()
+				b, err := io.ReadAll(res.Body)
+				got <- result{string(b), err}
+			}()
+			<-started
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			start := time.Now()
+			if err := drain(ctx, srv.Config, tc.timeout); err != nil {
+				t.Errorf("drain: %v", err)
+			}
+			if d := time.Since(start); d > tc.work+tc.timeout {
+				t.Errorf("drain took %v", d)
+			}
+			r := <-got
+			if finished := r.err == nil && r.body == "done"; finished !=
+				tc.finished {
+				t.Errorf("request finished %v, want %v: %q %v", finished,
+					tc.finished, r.body, r.err)
+			}
+		})
+	}
+}
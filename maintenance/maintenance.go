@@ -0,0 +1,79 @@
+// Package maintenance drains the proxy before it is stopped, answering new
+// requests with 503 and reporting it as not ready, so that an orchestrator
+// or load balancer takes it out of rotation while the requests in flight
+// finish.
+package maintenance
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Drain is the drain mode switch. The zero value is not draining.
+type Drain struct {
+	// RetryAfter is sent with the 503 responses, none if zero.
+	RetryAfter time.Duration
+	on         atomic.Bool
+}
+
+// Set turns drain mode on or off.
+func (d *Drain) Set(on bool) {
+	if d.on.Swap(on) != on {
+		changed(on)
+	}
+}
+
+// Toggle turns drain mode on if it is off and off if it is on, returning
+// whether it is on now.
+func (d *Drain) Toggle() (on bool) {
+	for {
+		was := d.on.Load()
+		if d.on.CompareAndSwap(was, !was) {
+			changed(!was)
+			return !was
+		}
+	}
+}
+
+func changed(on bool) {
+	if on {
+		log.W.Ln("draining, new requests are answered with 503")
+	} else {
+		log.I.Ln("no longer draining, serving requests")
+	}
+}
+
+// Draining reports whether drain mode is on.
+func (d *Drain) Draining() bool { return d.on.Load() }
+
+// Handler answers requests with 503 Service Unavailable while draining,
+// closing the connection after the response, and passes them to h
+// otherwise.
+func (d *Drain) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.on.Load() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if d.RetryAfter > 0 {
+			w.Header().Set("Retry-After",
+				strconv.Itoa(int(d.RetryAfter.Seconds())))
+		}
+		w.Header().Set("Connection", "close")
+		http.Error(w, "draining for maintenance",
+			http.StatusServiceUnavailable)
+	})
+}
+
+// Ready is a readiness check answering 200 when serving requests and 503
+// while draining.
+func (d *Drain) Ready(w http.ResponseWriter, _ *http.Request) {
+	if d.on.Load() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
@@ -0,0 +1,84 @@
+// Package matchroute sends requests carrying a given header or cookie value
+// to another backend than the rest, such as the users opted into an
+// experiment.
+package matchroute
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"lerproxy.mleku.dev/routelog"
+)
+
+// Rule matches requests whose Header, or else Cookie, has Value.
+type Rule struct {
+	Header, Cookie S
+	Value          S
+	Handler        http.Handler
+}
+
+// Parse reads a condition of the form "Name=value" into a Rule matching a
+// header, or a cookie if cookie is set.
+func Parse(cond S, cookie bool) (r Rule, err E) {
+	name, value, ok := strings.Cut(cond, "=")
+	if name = strings.TrimSpace(name); !ok || name == "" {
+		err = fmt.Errorf("invalid condition `%s`, want name=value", cond)
+		return
+	}
+	r.Value = strings.TrimSpace(value)
+	if cookie {
+		r.Cookie = name
+	} else {
+		r.Header = http.CanonicalHeaderKey(name)
+	}
+	return
+}
+
+// Matches reports whether req has the header or cookie value of r.
+func (r *Rule) Matches(req *http.Request) bool {
+	if r.Header != "" {
+		for _, v := range req.Header.Values(r.Header) {
+			if v == r.Value {
+				return true
+			}
+		}
+		return false
+	}
+	c, err := req.Cookie(r.Cookie)
+	return err == nil && c.Value == r.Value
+}
+
+// String describes the condition of r.
+func (r *Rule) String() S {
+	if r.Header != "" {
+		return fmt.Sprintf("header %s=%s", r.Header, r.Value)
+	}
+	return fmt.Sprintf("cookie %s=%s", r.Cookie, r.Value)
+}
+
+// Handler sends requests to the Handler of the first of Rules matching
+// them, and the others to Default.
+type Handler struct {
+	Rules   []Rule
+	Default http.Handler
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// caches must not serve one group the responses for another.
+	for i := range h.Rules {
+		if h.Rules[i].Header != "" {
+			w.Header().Add("Vary", h.Rules[i].Header)
+		} else {
+			w.Header().Add("Vary", "Cookie")
+		}
+	}
+	for i := range h.Rules {
+		if h.Rules[i].Matches(r) {
+			routelog.Add(r.Context(), "matched %s", &h.Rules[i])
+			h.Rules[i].Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	h.Default.ServeHTTP(w, r)
+}
@@ -0,0 +1,70 @@
+package matchroute
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func named(name S) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+func TestParse(t *testing.T) {
+	r, err := Parse(" x-experiment = beta ", false)
+	if err != nil || r.Header != "X-Experiment" || r.Value != "beta" ||
+		r.Cookie != "" {
+		t.Errorf("header rule %+v, %v", r, err)
+	}
+	if r, err = Parse("group=b", true); err != nil || r.Cookie != "group" ||
+		r.Value != "b" || r.Header != "" {
+		t.Errorf("cookie rule %+v, %v", r, err)
+	}
+	for _, cond := range []S{"", "X-Experiment", "=beta"} {
+		if _, err = Parse(cond, false); err == nil {
+			t.Errorf("%q parsed without error", cond)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	header, _ := Parse("X-Experiment=beta", false)
+	header.Handler = named("header")
+	cookie, _ := Parse("group=b", true)
+	cookie.Handler = named("cookie")
+	h := &Handler{Rules: []Rule{header, cookie}, Default: named("default")}
+	for _, tc := range []struct {
+		name   S
+		header http.Header
+		want   S
+	}{
+		{"none", nil, "default"},
+		{"header", http.Header{"X-Experiment": {"beta"}}, "header"},
+		{"header other value", http.Header{"X-Experiment": {"alpha"}},
+			"default"},
+		{"header repeated", http.Header{"X-Experiment": {"alpha", "beta"}},
+			"header"},
+		{"cookie", http.Header{"Cookie": {"a=1; group=b"}}, "cookie"},
+		{"cookie other value", http.Header{"Cookie": {"group=a"}},
+			"default"},
+		{"first rule wins", http.Header{"X-Experiment": {"beta"},
+			"Cookie": {"group=b"}}, "header"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for k, v := range tc.header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Body.String(); got != tc.want {
+			t.Errorf("%s: served by %s, want %s", tc.name, got, tc.want)
+		}
+		vary := w.Header().Values("Vary")
+		if len(vary) != 2 || vary[0] != "X-Experiment" || vary[1] != "Cookie" {
+			t.Errorf("%s: Vary %q", tc.name, vary)
+		}
+	}
+}
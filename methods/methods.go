@@ -0,0 +1,69 @@
+// Package methods rejects requests with methods a host does not allow, such
+// as writes to a read only site, before they reach its backend.
+package methods
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"lerproxy.mleku.dev/routelog"
+)
+
+// Allowed maps hostnames to the methods allowed for them. Hosts not in it
+// allow all methods.
+type Allowed map[S][]S
+
+// Parse reads lists in the form "example.com:GET,HEAD". HEAD is allowed
+// along with GET, as the http server answers it from the GET handler.
+func (a Allowed) Parse(specs []S) (err E) {
+	for _, spec := range specs {
+		host, list, _ := strings.Cut(spec, ":")
+		host = strings.ToLower(host)
+		if host == "" || list == "" {
+			err = fmt.Errorf("invalid allowed methods parameter format: `%s`",
+				spec)
+			return
+		}
+		for _, m := range strings.Split(list, ",") {
+			if m = strings.ToUpper(strings.TrimSpace(m)); m != "" &&
+				!contains(a[host], m) {
+				a[host] = append(a[host], m)
+			}
+		}
+		if contains(a[host], http.MethodGet) &&
+			!contains(a[host], http.MethodHead) {
+			a[host] = append(a[host], http.MethodHead)
+		}
+	}
+	return
+}
+
+// Handler answers requests with a method not in Methods with 405 Method Not
+// Allowed and an Allow header listing them, passing the others to Handler.
+type Handler struct {
+	http.Handler
+	Methods []S
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !contains(h.Methods, r.Method) {
+		log.D.F("rejecting %s %s%s from %s", r.Method, r.Host, r.URL.Path,
+			r.RemoteAddr)
+		routelog.Add(r.Context(), "method %s not allowed", r.Method)
+		w.Header().Set("Allow", strings.Join(h.Methods, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+func contains(list []S, s S) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
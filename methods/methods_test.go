@@ -0,0 +1,70 @@
+package methods
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	a := Allowed{}
+	if err := a.Parse([]S{
+		"Example.com:get, post",
+		"example.com:POST,OPTIONS",
+		"api.example.com:PUT",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := Allowed{
+		"example.com":     {"GET", "POST", "HEAD", "OPTIONS"},
+		"api.example.com": {"PUT"},
+	}
+	if !reflect.DeepEqual(a, want) {
+		t.Errorf("got %v, want %v", a, want)
+	}
+	for _, spec := range []S{"example.com", ":GET", "example.com:"} {
+		if err := (Allowed{}).Parse([]S{spec}); err == nil {
+			t.Errorf("%q parsed without error", spec)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	var served int
+	h := &Handler{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter,
+			r *http.Request) {
+			served++
+		}),
+		Methods: []S{"GET", "HEAD"},
+	}
+	for _, tc := range []struct {
+		method S
+		status int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+		{http.MethodDelete, http.StatusMethodNotAllowed},
+	} {
+		served = 0
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, "/", nil))
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.method, w.Code, tc.status)
+		}
+		if tc.status == http.StatusOK {
+			if served != 1 {
+				t.Errorf("%s was not passed to the handler", tc.method)
+			}
+			continue
+		}
+		if served != 0 {
+			t.Errorf("%s reached the handler", tc.method)
+		}
+		if allow := w.Header().Get("Allow"); allow != "GET, HEAD" {
+			t.Errorf("%s: Allow %q", tc.method, allow)
+		}
+	}
+}
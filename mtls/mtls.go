@@ -0,0 +1,234 @@
+// Package mtls requires and verifies client certificates for selected hosts,
+// selected by the SNI of the TLS handshake and checked again against the Host
+// of each request.
+package mtls
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+
+	"lerproxy.mleku.dev/router"
+)
+
+// Any is the host that applies a CA bundle to every host.
+const Any = "*"
+
+// SubjectHeader is the request header carrying the subject of the verified
+// client certificate to the backend.
+const SubjectHeader = "X-Client-Cert-Subject"
+
+// Headers are the names of the request headers carrying the details of the
+// verified client certificate to the backend. A detail without a name is
+// left out.
+type Headers struct {
+	Subject, CN, SANs, Fingerprint S
+}
+
+// DefaultHeaders are the Headers of a Handler without any.
+var DefaultHeaders = Headers{
+	Subject:     SubjectHeader,
+	CN:          "X-Client-Cert-CN",
+	SANs:        "X-Client-Cert-SANs",
+	Fingerprint: "X-Client-Cert-Fingerprint",
+}
+
+// ParseHeaders reads header names in the form "cn:X-SSL-Client-CN", for the
+// details subject, cn, sans and fingerprint, replacing those of
+// DefaultHeaders. "cn:" leaves the detail out.
+func ParseHeaders(specs []S) (h Headers, err E) {
+	h = DefaultHeaders
+	for _, spec := range specs {
+		detail, name, ok := strings.Cut(spec, ":")
+		if name = strings.TrimSpace(name); name != "" {
+			name = http.CanonicalHeaderKey(name)
+		}
+		switch strings.ToLower(detail) {
+		case "subject":
+			h.Subject = name
+		case "cn":
+			h.CN = name
+		case "sans":
+			h.SANs = name
+		case "fingerprint":
+			h.Fingerprint = name
+		default:
+			ok = false
+		}
+		if !ok {
+			err = log.E.Err("invalid client certificate header parameter "+
+				"format: `%s`", spec)
+			return
+		}
+	}
+	return
+}
+
+// names returns the header names that are set.
+func (h Headers) names() (n []S) {
+	for _, name := range []S{h.Subject, h.CN, h.SANs, h.Fingerprint} {
+		if name != "" {
+			n = append(n, name)
+		}
+	}
+	return
+}
+
+// Set puts the details of cert in the headers of r.
+func (h Headers) Set(r *http.Request, cert *x509.Certificate) {
+	set := func(name, value S) {
+		if name != "" {
+			r.Header.Set(name, value)
+		}
+	}
+	set(h.Subject, cert.Subject.String())
+	set(h.CN, cert.Subject.CommonName)
+	set(h.SANs, SANs(cert))
+	sum := sha256.Sum256(cert.Raw)
+	set(h.Fingerprint, hex.EncodeToString(sum[:]))
+}
+
+// SANs returns the subject alternative names of cert in the form
+// "DNS:example.com, email:user@example.com, IP:10.0.0.1, URI:spiffe://x".
+func SANs(cert *x509.Certificate) S {
+	var sans []S
+	for _, n := range cert.DNSNames {
+		sans = append(sans, "DNS:"+n)
+	}
+	for _, n := range cert.EmailAddresses {
+		sans = append(sans, "email:"+n)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, u := range cert.URIs {
+		sans = append(sans, "URI:"+u.String())
+	}
+	return strings.Join(sans, ", ")
+}
+
+// Pools maps hostnames to the CAs client certificates for them must chain to.
+type Pools map[S]*x509.CertPool
+
+// Load reads CA bundles given in the form "example.com:/path/to/ca.pem". The
+// host "*" applies the bundle to all hosts without one of their own.
+func Load(specs []S) (p Pools, err E) {
+	p = make(Pools)
+	for _, spec := range specs {
+		host, path, ok := strings.Cut(spec, ":")
+		if !ok || host == "" || path == "" {
+			err = log.E.Err("invalid client CA parameter format: `%s`", spec)
+			return
+		}
+		var pem B
+		if pem, err = os.ReadFile(path); chk.E(err) {
+			return
+		}
+		pool, ok := p[host]
+		if !ok {
+			pool = x509.NewCertPool()
+			p[host] = pool
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			err = log.E.Err("no certificates found in client CA file %s", path)
+			return
+		}
+	}
+	return
+}
+
+// For returns the pool for host, if client certificates are required for it.
+func (p Pools) For(host S) (pool *x509.CertPool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	var ok bool
+	if pool, ok = p[strings.ToLower(host)]; ok {
+		return
+	}
+	return p[Any]
+}
+
+// Configure makes tc request and verify client certificates for handshakes
+// whose SNI has a pool. Other hosts, and the tls-alpn-01 validation
+// handshakes of the ACME CA, which has no client certificate, are served with
+// tc unchanged.
+func Configure(tc *tls.Config, p Pools) {
+	if len(p) == 0 {
+		return
+	}
+	tc.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config,
+		error) {
+
+		if slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			return nil, nil
+		}
+		pool := p.For(hello.ServerName)
+		if pool == nil {
+			return nil, nil
+		}
+		c := tc.Clone()
+		c.GetConfigForClient = nil
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+		c.ClientCAs = pool
+		return c, nil
+	}
+}
+
+// Handler rejects requests for hosts with a pool that were not made over a
+// connection with a client certificate verified against it, which can happen
+// when the SNI differs from the Host header. Verified requests are forwarded
+// with the certificate details in Headers, DefaultHeaders if it is empty.
+type Handler struct {
+	http.Handler
+	Pools
+	Headers
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	headers := h.Headers
+	if headers == (Headers{}) {
+		headers = DefaultHeaders
+	}
+	// never trust details sent by the client itself, under either name.
+	for _, name := range append(DefaultHeaders.names(), headers.names()...) {
+		r.Header.Del(name)
+	}
+	host := router.Host(r)
+	pool := h.Pools.For(host)
+	if pool == nil {
+		h.Handler.ServeHTTP(w, r)
+		return
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		log.W.F("rejecting request for %s from %s without client certificate",
+			host, r.RemoteAddr)
+		http.Error(w, "client certificate required", http.StatusForbidden)
+		return
+	}
+	certs := r.TLS.PeerCertificates
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		log.W.F("rejecting request for %s from %s: %v", host, r.RemoteAddr,
+			err)
+		http.Error(w, "client certificate not accepted", http.StatusForbidden)
+		return
+	}
+	headers.Set(r, certs[0])
+	h.Handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,183 @@
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// issue returns a certificate for cn signed by parent, or self-signed as a
+// CA if parent is nil, with its key.
+func issue(t *testing.T, cn S, parent *x509.Certificate,
+	parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []S{"lerproxy"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		tmpl.DNSNames = []S{"client.example.com"}
+		tmpl.EmailAddresses = []S{"user@example.com"}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("10.0.0.1")}
+		u, _ := url.Parse("spiffe://example.com/client")
+		tmpl.URIs = []*url.URL{u}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent,
+		&key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestParseHeaders(t *testing.T) {
+	h, err := ParseHeaders([]S{"cn:x-ssl-client-cn", "sans:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DefaultHeaders
+	want.CN, want.SANs = "X-Ssl-Client-Cn", ""
+	if h != want {
+		t.Errorf("got %+v, want %+v", h, want)
+	}
+	for _, spec := range []S{"serial:X-Serial", "cn"} {
+		if _, err = ParseHeaders([]S{spec}); err == nil {
+			t.Errorf("%q parsed without error", spec)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ca, caKey := issue(t, "CA", nil, nil)
+	other, otherKey := issue(t, "other CA", nil, nil)
+	client, _ := issue(t, "client", ca, caKey)
+	stranger, _ := issue(t, "stranger", other, otherKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	custom, _ := ParseHeaders([]S{"cn:X-SSL-CN", "sans:"})
+	var got http.Header
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	})
+	sum := sha256.Sum256(client.Raw)
+	for _, tc := range []struct {
+		name    S
+		headers Headers
+		host    S
+		cert    *x509.Certificate
+		status  int
+		want    map[S]S
+	}{
+		{name: "verified", host: "secure.test", cert: client,
+			status: http.StatusOK, want: map[S]S{
+				"X-Client-Cert-Subject": "CN=client,O=lerproxy",
+				"X-Client-Cert-CN":      "client",
+				"X-Client-Cert-SANs": "DNS:client.example.com, " +
+					"email:user@example.com, IP:10.0.0.1, " +
+					"URI:spiffe://example.com/client",
+				"X-Client-Cert-Fingerprint": hex.EncodeToString(sum[:]),
+			}},
+		{name: "custom names", headers: custom, host: "secure.test",
+			cert: client, status: http.StatusOK, want: map[S]S{
+				"X-Ssl-Cn":           "client",
+				"X-Client-Cert-CN":   "",
+				"X-Client-Cert-SANs": "",
+			}},
+		{name: "no certificate", host: "secure.test",
+			status: http.StatusForbidden},
+		{name: "other CA", host: "secure.test", cert: stranger,
+			status: http.StatusForbidden},
+		{name: "no pool", host: "open.test", status: http.StatusOK,
+			want: map[S]S{"X-Client-Cert-CN": ""}},
+	} {
+		got = nil
+		r := httptest.NewRequest(http.MethodGet, "https://"+tc.host+"/", nil)
+		// details sent by the client must never reach the backend.
+		r.Header.Set("X-Client-Cert-CN", "admin")
+		r.Header.Set("X-SSL-CN", "admin")
+		r.TLS = &tls.ConnectionState{}
+		if tc.cert != nil {
+			r.TLS.PeerCertificates = []*x509.Certificate{tc.cert}
+		}
+		w := httptest.NewRecorder()
+		h := &Handler{Handler: next, Pools: Pools{"secure.test": pool},
+			Headers: tc.headers}
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+			continue
+		}
+		if tc.status != http.StatusOK {
+			if got != nil {
+				t.Errorf("%s: rejected request reached the backend", tc.name)
+			}
+			continue
+		}
+		for name, value := range tc.want {
+			if v := got.Get(name); v != value {
+				t.Errorf("%s: %s %q, want %q", tc.name, name, v, value)
+			}
+		}
+	}
+}
+
+func TestConfigureACME(t *testing.T) {
+	ca, _ := issue(t, "ca", nil, nil)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	for _, host := range []S{"secure.test", Any} {
+		tc := &tls.Config{}
+		Configure(tc, Pools{host: pool})
+		for _, h := range []struct {
+			hello   tls.ClientHelloInfo
+			require bool
+		}{
+			{tls.ClientHelloInfo{ServerName: "secure.test",
+				SupportedProtos: []S{"h2", "http/1.1"}}, true},
+			{tls.ClientHelloInfo{ServerName: "secure.test"}, true},
+			// the CA validating a tls-alpn-01 challenge.
+			{tls.ClientHelloInfo{ServerName: "secure.test",
+				SupportedProtos: []S{"acme-tls/1"}}, false},
+		} {
+			c, err := tc.GetConfigForClient(&h.hello)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c != nil &&
+				c.ClientAuth == tls.RequireAndVerifyClientCert; got !=
+				h.require {
+				t.Errorf("pool for %s, ALPN %q: client certificate required "+
+					"%v, want %v", host, h.hello.SupportedProtos, got,
+					h.require)
+			}
+		}
+	}
+}
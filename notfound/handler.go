@@ -0,0 +1,65 @@
+// Package notfound answers requests that match no mapped host or route with
+// a configurable page, logging them to help spot misconfiguration and
+// probing.
+package notfound
+
+import (
+	"fmt"
+	"net/http"
+
+	"lerproxy.mleku.dev/routelog"
+)
+
+// Page serves a 404 response.
+type Page struct {
+	// Body is served with the 404 status. If empty, the standard "404 page
+	// not found" text is used.
+	Body B
+	// ContentType of Body. If empty, it is detected from the content.
+	ContentType S
+	// Favicon, if set, answers requests for /favicon.ico instead, which
+	// browsers send to every host.
+	Favicon http.Handler
+}
+
+func (p *Page) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p != nil && p.Favicon != nil && r.URL.Path == "/favicon.ico" &&
+		(r.Method == http.MethodGet || r.Method == http.MethodHead) {
+
+		p.Favicon.ServeHTTP(w, r)
+		return
+	}
+	log.W.F("request for unmapped route %q %s from %s", r.Host, r.URL.Path,
+		r.RemoteAddr)
+	if p == nil || len(p.Body) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	ct := p.ContentType
+	if ct == "" {
+		ct = http.DetectContentType(p.Body)
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Length", fmt.Sprint(len(p.Body)))
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(p.Body)
+	}
+}
+
+// Handler serves a ServeMux, answering requests that match none of its
+// patterns with Page.
+type Handler struct {
+	*http.ServeMux
+	Page *Page
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, pattern := h.ServeMux.Handler(r); pattern != "" {
+		routelog.Add(r.Context(), "route %s", pattern)
+		h.ServeMux.ServeHTTP(w, r)
+		return
+	}
+	routelog.Add(r.Context(), "no route matched")
+	h.Page.ServeHTTP(w, r)
+}
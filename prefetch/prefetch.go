@@ -0,0 +1,74 @@
+// Package prefetch warms up the certificate cache for a list of hosts with
+// bounded parallelism, so that many hosts can be issued certificates without
+// tripping the CA's rate limits.
+package prefetch
+
+import (
+	"context"
+	"crypto/tls"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// GetCertificate is the signature of tls.Config.GetCertificate.
+type GetCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+// Certificates calls get for each host, running at most concurrency requests
+// at once and waiting at least delay between starting each one. Failures are
+// logged and do not stop the remaining hosts.
+func Certificates(ctx context.Context, get GetCertificate, hosts []S,
+	concurrency int, delay time.Duration) {
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	total := len(hosts)
+	log.I.F("prefetching certificates for %d hosts, %d at a time", total,
+		concurrency)
+	var done, failed atomic.Int64
+	var g errgroup.Group
+	g.SetLimit(concurrency)
+	for i, host := range hosts {
+		if i > 0 && delay > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		g.Go(func() error {
+			hello := &tls.ClientHelloInfo{
+				ServerName: host,
+				// advertise ECDSA support so the same certificate a modern
+				// client would be served is the one that gets issued.
+				CipherSuites: []uint16{
+					tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				},
+				SignatureSchemes: []tls.SignatureScheme{
+					tls.ECDSAWithP256AndSHA256,
+				},
+				SupportedCurves: []tls.CurveID{tls.CurveP256},
+				SupportedVersions: []uint16{
+					tls.VersionTLS13, tls.VersionTLS12,
+				},
+			}
+			_, err := get(hello)
+			n := done.Add(1)
+			if err != nil {
+				failed.Add(1)
+				log.E.F("prefetching certificate for %s failed, %d/%d: %v",
+					host, n, total, err)
+				return nil
+			}
+			log.I.F("prefetched %d/%d certificates", n, total)
+			return nil
+		})
+	}
+	_ = g.Wait()
+	log.I.F("prefetch finished: %d/%d certificates, %d failed", done.Load(),
+		total, failed.Load())
+}
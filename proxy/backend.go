@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Kind is the type of a backend, as given by the form of its mapping value.
+type Kind int
+
+const (
+	// TCP is http over a tcp connection to host:port.
+	TCP Kind = iota
+	// Unix is http over a unix socket at an absolute path.
+	Unix
+	// AbstractUnix is http over an abstract unix socket given as @name.
+	AbstractUnix
+	// Static serves files from a directory given with a trailing slash, or
+	// the first of a comma separated list of them that has the file.
+	Static
+	// Nostr serves a NIP-05 nostr.json file at /.well-known/nostr.json.
+	Nostr
+	// Exec runs a command per request, given as exec:/path/to/command.
+	Exec
+	// GoVanity serves go-import meta tags for git+https://repo/address.
+	GoVanity
+	// HTTP is http or https to a URL.
+	HTTP
+	// SRV is http to a target from the DNS SRV records of srv://name.
+	SRV
+	// GRPC is gRPC over HTTP/2 to grpc://host:port in cleartext (h2c), or
+	// to grpcs://host:port over TLS.
+	GRPC
+	// Connect tunnels CONNECT requests to the host:port targets given as
+	// connect:db.internal:5432,cache.internal:6379, and nothing else.
+	Connect
+)
+
+var kindNames = [...]S{"tcp", "unix", "abstract-unix", "static", "nostr.json",
+	"exec", "go-vanity", "http", "srv", "grpc", "connect"}
+
+func (k Kind) String() S { return kindNames[k] }
+
+// Backend is a parsed mapping value.
+type Backend struct {
+	Kind
+	// Network and Address are dialed for TCP, Unix and AbstractUnix.
+	Network, Address S
+	// Path is the file or directory of Static, Nostr and Exec, and the
+	// repository address of GoVanity.
+	Path S
+	// Dirs are the directories of Static, in the order they are searched.
+	Dirs []S
+	// Targets are the host:port addresses Connect may tunnel to.
+	Targets []S
+	// URL is the target of HTTP and GRPC, and the SRV name of SRV in its
+	// Host.
+	URL *url.URL
+	Options
+}
+
+// Options are set in a mapping value after the backend, separated by spaces,
+// such as "http://127.0.0.1:8000 rto=10s wto=2m".
+type Options struct {
+	// ReadTimeout bounds reading a request, its body included, as rto=10s,
+	// instead of --rto.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response, as wto=2m, instead of --wto.
+	WriteTimeout time.Duration
+}
+
+// splitOptions splits the trailing options off the mapping value v. Only the
+// fields after the first one that are a lowercase name, = and a value
+// without a slash are taken for options, so that the = in the query of a
+// URL or in a path are left alone.
+func splitOptions(v S) (target S, o Options, err E) {
+	target = strings.TrimSpace(v)
+	seen := make(map[S]bool)
+	for {
+		i := strings.LastIndexAny(target, " \t")
+		if i < 0 {
+			return
+		}
+		field := target[i+1:]
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || !optionName(name) || strings.Contains(value, "/") {
+			return
+		}
+		if seen[name] {
+			err = fmt.Errorf("duplicate option %q in %q", name, v)
+			return
+		}
+		seen[name] = true
+		var d *time.Duration
+		switch name {
+		case "rto":
+			d = &o.ReadTimeout
+		case "wto":
+			d = &o.WriteTimeout
+		default:
+			err = fmt.Errorf("unknown option %q in %q, expected rto or wto",
+				name, v)
+			return
+		}
+		if *d, err = time.ParseDuration(value); err != nil || *d < 0 {
+			err = fmt.Errorf("invalid duration %q of option %s in %q",
+				value, name, v)
+			return
+		}
+		target = strings.TrimSpace(target[:i])
+	}
+}
+
+// optionName reports whether s may be the name of an option.
+func optionName(s S) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseBackend determines the kind of backend from a mapping value and its
+// options. Invalid options are ignored, ReadMapping reports them.
+func ParseBackend(v S) (b Backend) {
+	target, o, _ := splitOptions(v)
+	b = parseTarget(target)
+	b.Options = o
+	return
+}
+
+// parseTarget determines the kind of backend from a mapping value without
+// options.
+func parseTarget(v S) (b Backend) {
+	switch {
+	case v != "" && v[0] == '@' && runtime.GOOS == "linux":
+		// append \0 to address so addrlen for connect(2) is calculated in a
+		// way compatible with some other implementations (i.e. uwsgi)
+		return Backend{Kind: AbstractUnix, Network: "unix",
+			Address: v + string(byte(0))}
+	case strings.HasPrefix(v, "exec:"):
+		return Backend{Kind: Exec, Path: strings.TrimPrefix(v, "exec:")}
+	case strings.HasPrefix(v, "connect:"):
+		return Backend{Kind: Connect,
+			Targets: strings.Split(strings.TrimPrefix(v, "connect:"), ",")}
+	case strings.HasPrefix(v, "git+"):
+		return Backend{Kind: GoVanity, Path: strings.TrimPrefix(v, "git+")}
+	case filepath.IsAbs(v):
+		switch {
+		case strings.HasSuffix(v, string(os.PathSeparator)):
+			return Backend{Kind: Static, Path: v, Dirs: dirs(v)}
+		case strings.HasSuffix(v, "nostr.json"):
+			return Backend{Kind: Nostr, Path: v}
+		}
+		return Backend{Kind: Unix, Network: "unix", Address: v}
+	}
+	if u, err := url.Parse(v); err == nil {
+		switch u.Scheme {
+		case "http", "https":
+			return Backend{Kind: HTTP, URL: u}
+		case "srv":
+			return Backend{Kind: SRV, URL: u}
+		case "grpc", "grpcs":
+			return Backend{Kind: GRPC, URL: u}
+		}
+	}
+	return Backend{Kind: TCP, Network: "tcp", Address: v}
+}
+
+// validBackend reports why the mapping value v is not a usable backend,
+// rather than one that only fails once dialed, such as a URL with a scheme
+// that is not supported being taken for a tcp address.
+func validBackend(v S) (err E) {
+	if v, _, err = splitOptions(v); err != nil {
+		return
+	}
+	b := parseTarget(v)
+	switch b.Kind {
+	case TCP:
+		if scheme, _, ok := strings.Cut(v, "://"); ok {
+			if _, perr := url.Parse(v); perr != nil {
+				return fmt.Errorf("malformed URL %q: %w", v, perr)
+			}
+			return fmt.Errorf("unsupported scheme %q in %q, expected http, "+
+				"https, srv, grpc or grpcs", scheme, v)
+		}
+		if _, _, err = net.SplitHostPort(v); err != nil {
+			return fmt.Errorf("invalid backend %q: %w", v, err)
+		}
+	case HTTP, SRV, GRPC:
+		if b.URL.Host == "" {
+			return fmt.Errorf("no host in backend URL %q", v)
+		}
+	case Connect:
+		for _, t := range b.Targets {
+			if _, _, err = net.SplitHostPort(t); err != nil {
+				return fmt.Errorf("invalid tunnel target %q in %q: %w", t, v,
+					err)
+			}
+		}
+	}
+	return
+}
+
+// dirs splits the comma separated directories of a Static backend, or
+// returns v itself if they are not all absolute directories, since it may
+// be one with a comma in its name.
+func dirs(v S) (d []S) {
+	d = strings.Split(v, ",")
+	for _, dir := range d {
+		if !filepath.IsAbs(dir) ||
+			!strings.HasSuffix(dir, string(os.PathSeparator)) {
+
+			return []S{v}
+		}
+	}
+	return
+}
+
+// Target describes where the backend sends requests.
+func (b Backend) Target() S {
+	switch b.Kind {
+	case TCP, Unix:
+		return b.Address
+	case AbstractUnix:
+		return strings.TrimSuffix(b.Address, string(byte(0)))
+	case HTTP, SRV, GRPC:
+		return b.URL.String()
+	case Connect:
+		return strings.Join(b.Targets, ",")
+	}
+	return b.Path
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseBackendDirs(t *testing.T) {
+	for _, tc := range []struct {
+		v    S
+		dirs []S
+	}{
+		{"/var/www/", []S{"/var/www/"}},
+		{"/var/www/site/,/var/www/shared/",
+			[]S{"/var/www/site/", "/var/www/shared/"}},
+		// not all absolute directories, so one name with a comma.
+		{"/var/www/a,b/", []S{"/var/www/a,b/"}},
+		{"/var/www/site/,shared/", []S{"/var/www/site/,shared/"}},
+	} {
+		b := ParseBackend(tc.v)
+		if b.Kind != Static || !slices.Equal(b.Dirs, tc.dirs) {
+			t.Errorf("ParseBackend(%q) = %v %q, want static %q", tc.v,
+				b.Kind, b.Dirs, tc.dirs)
+		}
+	}
+}
+
+func TestCheckStaticDirs(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	sep := string(filepath.Separator)
+	ok := ParseBackend(a + sep + "," + b + sep)
+	if err := ok.Check(context.Background(), time.Second); err != nil {
+		t.Errorf("existing directories: %v", err)
+	}
+	missing := ParseBackend(a + sep + "," + filepath.Join(b, "gone") + sep)
+	if err := missing.Check(context.Background(), time.Second); err == nil {
+		t.Error("missing second directory passed the check")
+	}
+}
+
+func TestValidBackend(t *testing.T) {
+	for _, tc := range []struct {
+		v  S
+		ok bool
+	}{
+		{"127.0.0.1:8080", true},
+		{"localhost:8080", true},
+		{"[::1]:8080", true},
+		{"http://127.0.0.1:8000", true},
+		{"https://backend.internal/app", true},
+		{"srv://_http._tcp.example.com", true},
+		{"grpc://127.0.0.1:9000", true},
+		{"grpcs://backend.internal:443", true},
+		{"connect:db.internal:5432,cache.internal:6379", true},
+		{"http://127.0.0.1:8000 rto=10s", true},
+		{"127.0.0.1", false},
+		{"localhost", false},
+		{"ftp://files.example.com", false},
+		{"htp://127.0.0.1:8000", false},
+		{"http://", false},
+		{"grpc:///path", false},
+		{"http://[::1", false},
+		{"connect:db.internal", false},
+	} {
+		if err := validBackend(tc.v); (err == nil) != tc.ok {
+			t.Errorf("validBackend(%q) = %v", tc.v, err)
+		}
+	}
+}
+
+func TestParseBackendOptions(t *testing.T) {
+	for _, tc := range []struct {
+		v      S
+		target S
+		o      Options
+	}{
+		{"http://127.0.0.1:8000", "http://127.0.0.1:8000", Options{}},
+		{"http://127.0.0.1:8000 rto=10s wto=2m", "http://127.0.0.1:8000",
+			Options{ReadTimeout: 10 * time.Second,
+				WriteTimeout: 2 * time.Minute}},
+		{"127.0.0.1:8080\twto=1m  ", "127.0.0.1:8080",
+			Options{WriteTimeout: time.Minute}},
+		{"http://127.0.0.1:8000/?a=b&c=d", "http://127.0.0.1:8000/?a=b&c=d",
+			Options{}},
+		{"http://127.0.0.1:8000/?a=b rto=5s", "http://127.0.0.1:8000/?a=b",
+			Options{ReadTimeout: 5 * time.Second}},
+		{"http://127.0.0.1:8000/?rto=5s", "http://127.0.0.1:8000/?rto=5s",
+			Options{}},
+		{"/var/www/a=b/", "/var/www/a=b/", Options{}},
+	} {
+		target, o, err := splitOptions(tc.v)
+		if err != nil || target != tc.target || o != tc.o {
+			t.Errorf("splitOptions(%q) = %q, %+v, %v, want %q, %+v", tc.v,
+				target, o, err, tc.target, tc.o)
+		}
+		if b := ParseBackend(tc.v); b.Options != tc.o {
+			t.Errorf("ParseBackend(%q) options %+v, want %+v", tc.v,
+				b.Options, tc.o)
+		}
+		if err = validBackend(tc.v); err != nil {
+			t.Errorf("validBackend(%q) = %v", tc.v, err)
+		}
+	}
+	if b := ParseBackend("http://127.0.0.1:8000/?a=b rto=5s"); b.Kind !=
+		HTTP || b.URL.RawQuery != "a=b" {
+		t.Errorf("got %v backend with query %q", b.Kind, b.URL.RawQuery)
+	}
+	for _, v := range []S{
+		"http://127.0.0.1:8000 rto=10s rto=20s",
+		"http://127.0.0.1:8000 timeout=10s",
+		"http://127.0.0.1:8000 rto=ten",
+		"http://127.0.0.1:8000 wto=-1s",
+		"http://127.0.0.1:8000 rto=",
+	} {
+		if _, _, err := splitOptions(v); err == nil {
+			t.Errorf("splitOptions(%q) accepted", v)
+		}
+		if err := validBackend(v); err == nil {
+			t.Errorf("validBackend(%q) accepted", v)
+		}
+	}
+	// options come after the backend.
+	if err := validBackend("rto=10s http://127.0.0.1:8000"); err == nil {
+		t.Error("options before the backend accepted")
+	}
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"lerproxy.mleku.dev/srv"
+	"lerproxy.mleku.dev/util"
+)
+
+// CheckBackends probes the backend of each host in the mapping once: network
+// backends are dialed and file based ones checked to exist and be readable.
+// It logs the result for each host and returns the hosts that failed.
+func CheckBackends(ctx context.Context, mapping map[S]S,
+	timeout time.Duration) (failed []S) {
+
+	for _, host := range util.GetKeys(mapping) {
+		b := ParseBackend(mapping[host])
+		if err := b.Check(ctx, timeout); err != nil {
+			log.E.F("backend %s %s for %s is not available: %v", b.Kind,
+				b.Target(), host, err)
+			failed = append(failed, host)
+			continue
+		}
+		log.I.F("backend %s %s for %s is available", b.Kind, b.Target(), host)
+	}
+	return
+}
+
+// ProbeBackends checks the backend of each host in the mapping like
+// CheckBackends, only logging failures at debug level, and counts those
+// that are available and not.
+func ProbeBackends(ctx context.Context, mapping map[S]S,
+	timeout time.Duration) (healthy, unhealthy int) {
+
+	for host, v := range mapping {
+		b := ParseBackend(v)
+		if err := b.Check(ctx, timeout); err != nil {
+			log.D.F("backend %s %s for %s is not available: %v", b.Kind,
+				b.Target(), host, err)
+			unhealthy++
+			continue
+		}
+		healthy++
+	}
+	return
+}
+
+// defaultPorts are the ports dialed for URL backends that do not name one;
+// gRPC runs over HTTP/2 so uses the same ports as http and https.
+var defaultPorts = map[S]S{
+	"http":  "80",
+	"https": "443",
+	"grpc":  "80",
+	"grpcs": "443",
+}
+
+// dialAddr returns the host:port to dial for a URL backend.
+func dialAddr(u *url.URL) S {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), defaultPorts[u.Scheme])
+}
+
+// Check probes the backend, dialing it with the given timeout or checking
+// its file or directory.
+func (b Backend) Check(ctx context.Context, timeout time.Duration) (err E) {
+	d := net.Dialer{Timeout: timeout}
+	var conn net.Conn
+	switch b.Kind {
+	case TCP, Unix, AbstractUnix:
+		conn, err = d.DialContext(ctx, b.Network, b.Address)
+	case HTTP, GRPC:
+		conn, err = d.DialContext(ctx, "tcp", dialAddr(b.URL))
+	case SRV:
+		var addr S
+		if addr, err = srv.New(b.URL.Host, 0, srv.Weighted).Pick(ctx); err != nil {
+			return
+		}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	case Connect:
+		for _, t := range b.Targets {
+			if conn, err = d.DialContext(ctx, "tcp", t); err != nil {
+				return
+			}
+			chk.E(conn.Close())
+		}
+		return
+	case Static:
+		for _, dir := range b.Dirs {
+			if err = checkDir(dir); err != nil {
+				return
+			}
+		}
+		return
+	case Nostr:
+		var f *os.File
+		if f, err = os.Open(b.Path); err != nil {
+			return
+		}
+		return f.Close()
+	case Exec:
+		var fi os.FileInfo
+		if fi, err = os.Stat(b.Path); err != nil {
+			return
+		}
+		if fi.IsDir() || fi.Mode()&0111 == 0 {
+			err = fmt.Errorf("%s is not executable", b.Path)
+		}
+		return
+	}
+	if conn != nil {
+		err = conn.Close()
+	}
+	return
+}
+
+// checkDir checks that dir is a directory that can be listed.
+func checkDir(dir S) (err E) {
+	var f *os.File
+	if f, err = os.Open(dir); err != nil {
+		return
+	}
+	defer f.Close()
+	// an empty directory can be listed, it just has nothing in it.
+	if _, err = f.Readdirnames(1); err == io.EOF {
+		err = nil
+	} else if err != nil {
+		err = fmt.Errorf("cannot list directory %s: %w", dir, err)
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDialAddr(t *testing.T) {
+	for _, tc := range []struct{ backend, addr S }{
+		{"http://example.com", "example.com:80"},
+		{"https://example.com", "example.com:443"},
+		{"grpc://example.com", "example.com:80"},
+		{"grpcs://example.com", "example.com:443"},
+		{"grpc://example.com:9000", "example.com:9000"},
+		{"https://[::1]", "[::1]:443"},
+	} {
+		b := ParseBackend(tc.backend)
+		if b.URL == nil {
+			t.Fatalf("%q did not parse as a URL backend", tc.backend)
+		}
+		if addr := dialAddr(b.URL); addr != tc.addr {
+			t.Errorf("dialAddr(%q) = %q, want %q", tc.backend, addr, tc.addr)
+		}
+	}
+}
+
+func TestCheckGRPC(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	// nothing listens on the port any more.
+	b := ParseBackend("grpc://" + addr)
+	if err = b.Check(context.Background(), time.Second); err == nil {
+		t.Errorf("check of closed %s succeeded", addr)
+	}
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	if err = b.Check(context.Background(), time.Second); err != nil {
+		t.Errorf("check of listening %s: %v", addr, err)
+	}
+}
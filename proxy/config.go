@@ -0,0 +1,305 @@
+package proxy
+
+import (
+	"fmt"
+	stdLog "log"
+	"net/http"
+	"strings"
+	"time"
+
+	"lerproxy.mleku.dev/bluegreen"
+	"lerproxy.mleku.dev/mtls"
+)
+
+// Config is the configuration of a Server.
+type Config struct {
+	// Mapping is the path of the file with the host to backend mapping, or
+	// an http or https URL it is fetched from.
+	Mapping S
+	// AllowEmptyMapping starts the server with no hosts when the mapping file
+	// is missing or empty, rather than failing, so it can be created later
+	// and picked up by Reload.
+	AllowEmptyMapping bool
+	// CheckBackends probes each backend once at startup, logging which are
+	// available.
+	CheckBackends bool
+	// RequireBackends is CheckBackends, but failing if any is unavailable.
+	RequireBackends bool
+	// DialTimeout bounds connecting to unix socket, tcp, SRV and gRPC
+	// backends and the backend probes of CheckBackends. Zero leaves it to
+	// the system.
+	DialTimeout time.Duration
+	// HostDialTimeouts override DialTimeout for a host in the form
+	// "example.com:30s", for backends that are slow to accept.
+	HostDialTimeouts []S
+	// ResponseBudgets are the longest a host waits for its backend to start
+	// responding, in the form "example.com:2s", after which the request to
+	// the backend is cancelled and the client gets 504.
+	ResponseBudgets []S
+	// PinDNS caches the addresses of the hostname of a host's http or https
+	// backend for a while, in the form "example.com:5m", resolving it again
+	// in the background and keeping the last addresses if that fails.
+	PinDNS []S
+	// Cache is the directory where the ACME account key and certificates
+	// are stored.
+	Cache S
+	// Email is the contact address presented to the ACME CA.
+	Email S
+	// RequireEmail fails to start if Email or the email of an ACME profile
+	// is empty, rather than warning.
+	RequireEmail bool
+	// ACMEProfiles are additional ACME accounts in the form "name:email",
+	// each cached in its own subdirectory of Cache.
+	ACMEProfiles []S
+	// ACMEProfileHosts assign hosts to ACME profiles in the form
+	// "example.com:name".
+	ACMEProfileHosts []S
+	// ACMEDirectory is the directory URL of the ACME CA, LetsEncrypt if
+	// empty. It can point at a test CA such as Pebble.
+	ACMEDirectory S
+	// ACMERootCA is the path of a PEM file with the root certificates that
+	// the ACME CA's own https certificate is verified with, instead of those
+	// of the system.
+	ACMERootCA S
+	// ACMERetry bounds the time spent retrying to obtain a certificate after
+	// transient failures of the ACME CA. If zero, failures are not retried.
+	ACMERetry time.Duration
+	// ACMENegativeTTL is how long a failure to obtain a certificate for a
+	// host is returned to further handshakes without asking the CA again.
+	ACMENegativeTTL time.Duration
+	// IssuingRetryAfter answers plain http requests for a host whose
+	// certificate is being obtained with 503 and a Retry-After of this
+	// duration, instead of redirecting them to https. Disabled if zero.
+	IssuingRetryAfter time.Duration
+	// AllowAnyHost lets any public hostname obtain a certificate, not only
+	// those in the mapping, for hosts created on the fly. Each one counts
+	// against the rate limits of the ACME CA.
+	AllowAnyHost bool
+	// DenyHosts are hosts refused a certificate with AllowAnyHost, with
+	// *.example.com denying the names ending in .example.com.
+	DenyHosts []S
+	// HSTS adds a Strict-Transport-Security header to all responses,
+	// including redirects from http.
+	HSTS bool
+	// RedirectStatus is the status code of redirects from http to https, 308
+	// if zero.
+	RedirectStatus int
+	// RedirectPort is the public https port that redirects from http point
+	// at, 443 if empty.
+	RedirectPort S
+	// ALPN are the protocols negotiated with clients, h2 and http/1.1, in
+	// order of preference, such as to prefer http/1.1 for clients whose
+	// HTTP/2 is broken. The default of autocert, h2 first, if empty.
+	ALPN []S
+	// Certs are static certificates in the form "example.com:/path/to/cert",
+	// loaded from /path/to/cert.crt and /path/to/cert.key.
+	Certs []S
+	// AllowMethods restricts the request methods of a host in the form
+	// "example.com:GET,HEAD", answering others with 405.
+	AllowMethods []S
+	// CacheControl are the Cache-Control headers of the files of static
+	// backends in the form "example.com:/path/pattern:value", where the
+	// first matching pattern for the host applies.
+	CacheControl []S
+	// DefaultHost is the host that requests without a Host header are routed
+	// to, rather than matching none.
+	DefaultHost S
+	// RejectEmptyHost answers requests without a Host header with 400,
+	// taking precedence over DefaultHost.
+	RejectEmptyHost bool
+	// NotFound is the path of a file served for hosts not in the mapping.
+	NotFound S
+	// Favicon is the path of an icon served as /favicon.ico for hosts not in
+	// the mapping and those whose backend answers it with 404.
+	Favicon S
+	// ExecTimeout bounds the run time of exec: backend processes.
+	ExecTimeout time.Duration
+	// ExecMaxOutput bounds the bytes read from exec: backend processes.
+	ExecMaxOutput int64
+	// SRVTTL is how long the DNS SRV records of srv:// backends are cached.
+	SRVTTL time.Duration
+	// LBStrategy is how srv:// backends choose among their targets: weighted,
+	// round-robin or latency. Weighted if empty.
+	LBStrategy S
+	// InstrumentBackend, if set, wraps the transport of each proxied backend
+	// of a host, such as to count its requests. backend is the backend as it
+	// is in the mapping.
+	InstrumentBackend func(host, backend S,
+		rt http.RoundTripper) http.RoundTripper
+	// ErrorLog receives the failures of requests to backends, with the reason
+	// classified. If nil, they are written with the other logs.
+	ErrorLog *stdLog.Logger
+	// RewriteLocation lists the hosts whose backend redirects are rewritten
+	// to point at the public host over https.
+	RewriteLocation []S
+	// RewriteBody are strings replaced in the response bodies of a host in
+	// the form "example.com:old new".
+	RewriteBody []S
+	// RewriteBodyTypes are the media types of the responses RewriteBody
+	// applies to, text/html if empty.
+	RewriteBodyTypes []S
+	// RewriteBodyMaxSize is the largest body rewritten, unlimited if zero.
+	RewriteBodyMaxSize int64
+	// Compress lists the hosts whose backend responses are compressed for
+	// clients that accept it.
+	Compress []S
+	// CompressEncoders are the encodings used by Compress in order of
+	// preference, zstd and gzip if empty.
+	CompressEncoders []S
+	// CompressTypes are the media types Compress applies to,
+	// compression.DefaultTypes if empty.
+	CompressTypes []S
+	// CompressMinSize is the smallest response compressed.
+	CompressMinSize int64
+	// TrustedProxies are the addresses and CIDR networks of proxies in front
+	// of the server, whose X-Forwarded-For is passed on to backends with the
+	// client address appended. Other clients' X-Forwarded-For is replaced.
+	TrustedProxies []S
+	// ClientIPHeaders name the headers backends get the client address in
+	// instead of X-Forwarded-For, in the form "example.com:True-Client-IP",
+	// or "*:True-Client-IP" for all hosts. X-Forwarded-For is only kept if
+	// named too.
+	ClientIPHeaders []S
+	// Via adds lerproxy to the Via header of the requests to backends and
+	// of their responses.
+	Via bool
+	// BackendHeader is the name of a response header reporting the address
+	// of the backend that answered the request, such as X-Backend. It is
+	// not added if empty, so that the internal addresses are not exposed.
+	BackendHeader S
+	// StripRequestHeaders are removed from the requests to all backends,
+	// such as headers that only proxies in front of the server may set.
+	StripRequestHeaders []S
+	// StripResponseHeaders are removed from the responses of all backends,
+	// such as internal headers that clients must not see.
+	StripResponseHeaders []S
+	// Tracing creates an OpenTelemetry span for each request to a backend,
+	// propagating the trace to it. The exporter is set up with
+	// tracing.Setup.
+	Tracing bool
+	// DebugHeaders lists the hosts whose forwarded request and backend
+	// response headers are logged at trace level.
+	DebugHeaders []S
+	// TraceRouting records the address each request to a backend is sent
+	// over for routelog, which the server's handler must be wrapped in.
+	TraceRouting bool
+	// Headers are response headers in the form
+	// "example.com:Header-Name: value", added when the backend did not set
+	// the header itself.
+	Headers []S
+	// HeadersOverride are like Headers, but replace the backend's values.
+	HeadersOverride []S
+	// RemapStatus replace a status of the backend responses of a host with
+	// another in the form "example.com:500=503", or only for the paths
+	// matching a pattern, "example.com:/api/:500=503". The first matching
+	// rule for the host applies.
+	RemapStatus []S
+	// AltSvc are the Alt-Svc headers of the responses of a host in the form
+	// `example.com:h3=":443"; ma=86400`, advertising an HTTP/3 endpoint
+	// served by something else, replacing any the backend set.
+	AltSvc []S
+	// VerbatimPath lists the hosts whose request paths are passed to the
+	// backend as they are, rather than redirecting those with . or ..
+	// elements or repeated slashes to their clean form.
+	VerbatimPath []S
+	// Stream lists the hosts whose backend responses are flushed to the
+	// client after each write, rather than when the buffer fills or
+	// periodically, for low latency over throughput.
+	Stream []S
+	// Coalesce lists the hosts whose identical GET requests in flight at the
+	// same time are sent to the backend as one, sharing its response.
+	Coalesce []S
+	// CoalesceMaxSize is the largest response body Coalesce shares.
+	CoalesceMaxSize int64
+	// NoKeepAlive lists the hosts whose backends get a new connection for
+	// each request, closed after the response, for backends that break on
+	// keep-alive.
+	NoKeepAlive []S
+	// BackendHosts are the Host headers sent to a host's unix socket,
+	// abstract socket or tcp backend in the form "example.com:app.internal",
+	// instead of the one the client sent.
+	BackendHosts []S
+	// RouteHeaders send the requests to a host with a header value to
+	// another backend, in the form "example.com:X-Experiment=beta:backend".
+	// They are tried in order, before RouteCookies.
+	RouteHeaders []S
+	// RouteCookies are RouteHeaders matching a cookie, in the form
+	// "example.com:experiment=beta:backend".
+	RouteCookies []S
+	// Stale are directories with a snapshot of a host's site in the form
+	// "example.com:/path/to/dir", served when its backend cannot be reached
+	// or answers with a server error.
+	Stale []S
+	// Gunzip lists the hosts whose gzip encoded backend responses are
+	// decompressed for clients that did not ask for gzip.
+	Gunzip []S
+	// Origins are the Origin headers sent to a host's backend in the form
+	// "example.com:https://app.internal", instead of the one the client
+	// sent, or "example.com:-" to remove it, for backends that check it
+	// against an allowlist. Other hosts pass it through unchanged.
+	Origins []S
+	// ClientCAs are CA bundles in the form "example.com:/path/to/ca.pem"
+	// that client certificates for the host must chain to. The host "*"
+	// applies to all hosts.
+	ClientCAs []S
+	// ClientCertHeaders rename the headers the details of verified client
+	// certificates are sent to backends in, in the form
+	// "fingerprint:X-SSL-Client-Fingerprint", for the details subject, cn,
+	// sans and fingerprint. "sans:" leaves a detail out.
+	ClientCertHeaders []S
+	// Green are the second backends of hosts in the form
+	// "example.com:backend", with the one in the mapping being the first,
+	// blue. Requests go to blue until Server.Switch sends a share of them to
+	// green.
+	Green []S
+
+	clientCAs         mtls.Pools
+	clientCertHeaders mtls.Headers
+	splits            *bluegreen.Splits
+	remote            *remoteMapping
+}
+
+// green returns the green backends of c.Green by host.
+func (c *Config) green() (m map[S]S, err E) {
+	return values("green backend", c.Green)
+}
+
+// values reads the per host values of specs in the form
+// "example.com:value", naming what they are in errors.
+func values(what S, specs []S) (m map[S]S, err E) {
+	m = make(map[S]S, len(specs))
+	for _, spec := range specs {
+		host, v, _ := strings.Cut(spec, ":")
+		if v = strings.TrimSpace(v); host == "" || v == "" {
+			err = fmt.Errorf("invalid %s parameter format: `%s`", what, spec)
+			return
+		}
+		m[strings.ToLower(host)] = v
+	}
+	return
+}
+
+// durations reads the per host durations of specs in the form
+// "example.com:30s".
+func durations(specs []S) (m map[S]time.Duration, err E) {
+	m = make(map[S]time.Duration, len(specs))
+	for _, spec := range specs {
+		host, v, _ := strings.Cut(spec, ":")
+		var d time.Duration
+		if d, err = time.ParseDuration(v); host == "" || err != nil {
+			err = fmt.Errorf("invalid duration parameter format: `%s`", spec)
+			return
+		}
+		m[strings.ToLower(host)] = d
+	}
+	return
+}
+
+// set returns the hosts as a set.
+func set(hosts []S) (m map[S]bool) {
+	m = make(map[S]bool, len(hosts))
+	for _, h := range hosts {
+		m[strings.ToLower(h)] = true
+	}
+	return
+}
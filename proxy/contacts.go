@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// accountKeyName is where autocert caches the ACME account key.
+const accountKeyName = "acme_account+key"
+
+// contacts registers the ACME account of a manager with several contact
+// addresses before its first order, since autocert only registers it with
+// the one of its Email. The key of the account is set on the client of the
+// manager when it is created, so autocert then finds the account already
+// registered under the key and uses it as it is.
+type contacts struct {
+	m      *autocert.Manager
+	emails []S
+	mx     sync.Mutex
+	done   bool
+}
+
+// register registers the account, or replaces the contacts of the account
+// if it exists already, once.
+func (c *contacts) register(ctx context.Context) (err E) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	if c.done {
+		return
+	}
+	client := c.m.Client
+	a := &acme.Account{}
+	for _, email := range c.emails {
+		a.Contact = append(a.Contact, "mailto:"+email)
+	}
+	if _, err = client.Register(ctx, a, c.m.Prompt); errors.Is(err,
+		acme.ErrAccountAlreadyExists) {
+
+		// registered before, by autocert or with other contacts.
+		_, err = client.UpdateReg(ctx, a)
+	}
+	if err != nil {
+		return fmt.Errorf("acme/autocert: registering account with "+
+			"contacts %s: %w", strings.Join(c.emails, ", "), err)
+	}
+	log.I.F("ACME account registered with contacts %s",
+		strings.Join(c.emails, ", "))
+	c.done = true
+	return
+}
+
+// accountKey reads the account key from cache as autocert does, or
+// generates and stores one like it would.
+func accountKey(ctx context.Context, cache autocert.Cache) (key crypto.Signer,
+	err E) {
+
+	var data B
+	if data, err = cache.Get(ctx, accountKeyName); errors.Is(err,
+		autocert.ErrCacheMiss) {
+
+		var k *ecdsa.PrivateKey
+		if k, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			return
+		}
+		var der B
+		if der, err = x509.MarshalECPrivateKey(k); err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err = pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY",
+			Bytes: der}); err != nil {
+			return
+		}
+		if err = cache.Put(ctx, accountKeyName, buf.Bytes()); err != nil {
+			return
+		}
+		return k, nil
+	} else if err != nil {
+		return
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || !strings.Contains(block.Type, "PRIVATE") {
+		return nil, fmt.Errorf("invalid ACME account key in cache")
+	}
+	if key, err = x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return
+	}
+	if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return
+	}
+	var k any
+	if k, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		return
+	}
+	var ok bool
+	if key, ok = k.(crypto.Signer); !ok {
+		err = fmt.Errorf("invalid ACME account key type %T in cache", k)
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package proxy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestContactsKey(t *testing.T) {
+	allow := func(context.Context, S) E { return nil }
+	c := &Config{Cache: t.TempDir(),
+		Email: "admin@example.com,ops@example.com"}
+	p, err := newProfiles(c, allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the key has to be set before autocert uses the manager.
+	key := p.Default.Client.Key
+	if key == nil {
+		t.Fatal("account key not set on the client")
+	}
+	if _, err = os.Stat(filepath.Join(c.Cache, accountKeyName)); err != nil {
+		t.Fatalf("account key not cached: %v", err)
+	}
+	if p, err = newProfiles(c, allow); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.Default.Client.Key.Public(), key.Public()) {
+		t.Error("cached account key not reused")
+	}
+	// with one contact autocert registers the account itself.
+	c = &Config{Cache: t.TempDir(), Email: "admin@example.com"}
+	if p, err = newProfiles(c, allow); err != nil {
+		t.Fatal(err)
+	}
+	if p.Default.Client != nil {
+		t.Error("client set for a single contact")
+	}
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"golang.org/x/net/http2"
+	"lerproxy.mleku.dev/buf"
+)
+
+// grpcProxy proxies gRPC to the backend at u over HTTP/2, in cleartext (h2c)
+// for grpc:// and over TLS for grpcs://. Clients reach lerproxy over TLS
+// with HTTP/2 as negotiated by ALPN.
+//
+// The trailers carrying the grpc-status are forwarded by the ReverseProxy,
+// and responses are flushed as each message arrives so that streaming calls
+// work.
+func grpcProxy(opts *options, hn S, u *url.URL) http.Handler {
+	target := &url.URL{Scheme: "https", Host: u.Host}
+	timeout := opts.dialTimeoutFor(hn)
+	// the timeout covers the TLS handshake as well as connecting.
+	t := &http2.Transport{DialTLSContext: func(ctx context.Context, network,
+		addr S, cfg *tls.Config) (conn net.Conn, err error) {
+
+		d := &tls.Dialer{NetDialer: &net.Dialer{Timeout: timeout},
+			Config: cfg}
+		if conn, err = d.DialContext(ctx, network, addr); err != nil {
+			return
+		}
+		if p := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; p !=
+			http2.NextProtoTLS {
+			conn.Close()
+			return nil, fmt.Errorf("backend %s negotiated %q, not h2", addr,
+				p)
+		}
+		return
+	}}
+	if u.Scheme == "grpc" {
+		target.Scheme = "http"
+		t.AllowHTTP = true
+		t.DialTLSContext = func(ctx context.Context, network, addr S,
+			_ *tls.Config) (net.Conn, error) {
+
+			d := net.Dialer{Timeout: timeout}
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	rp := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			log.D.Ln(pr.Out.URL, pr.In.RemoteAddr)
+		},
+		Transport:     t,
+		FlushInterval: -1,
+		BufferPool:    buf.Pool{},
+	}
+	opts.configure(rp, hn, u.String(), nil)
+	return rp
+}
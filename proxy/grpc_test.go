@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// grpcEcho answers every length-prefixed message with itself as soon as it
+// arrives, then ends the call with an OK grpc-status trailer.
+func grpcEcho(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 ||
+		r.Header.Get("Content-Type") != "application/grpc" {
+		http.Error(w, "not grpc", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.WriteHeader(http.StatusOK)
+	var prefix [5]byte
+	for {
+		if _, err := io.ReadFull(r.Body, prefix[:]); err != nil {
+			break
+		}
+		msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(r.Body, msg); err != nil {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Status", "13")
+			return
+		}
+		w.Write(prefix[:])
+		w.Write(msg)
+		w.(http.Flusher).Flush()
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+}
+
+func grpcFrame(msg S) []byte {
+	b := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(b[1:], uint32(len(msg)))
+	return append(b, msg...)
+}
+
+func readGRPCFrame(t *testing.T, r io.Reader) S {
+	t.Helper()
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	return S(msg)
+}
+
+// grpcFront proxies grpc.test to an in-process h2c echo server and returns
+// an HTTP/2 TLS front end for it.
+func grpcFront(t *testing.T) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(grpcEcho),
+		&http2.Server{}))
+	t.Cleanup(backend.Close)
+	h, err := NewHandler(&Config{DialTimeout: time.Second},
+		map[S]S{"grpc.test": "grpc://" + backend.Listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveH2(t, h)
+}
+
+// serveH2 returns an HTTP/2 TLS server of h.
+func serveH2(t *testing.T, h http.Handler) *httptest.Server {
+	t.Helper()
+	front := httptest.NewUnstartedServer(h)
+	front.EnableHTTP2 = true
+	front.StartTLS()
+	t.Cleanup(front.Close)
+	return front
+}
+
+func grpcRequest(t *testing.T, front *httptest.Server,
+	body io.Reader) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost,
+		front.URL+"/echo.Echo/Call", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "grpc.test"
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	return req
+}
+
+func TestGRPCUnary(t *testing.T) {
+	front := grpcFront(t)
+	res, err := front.Client().Do(grpcRequest(t, front,
+		strings.NewReader(S(grpcFrame("hello")))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.ProtoMajor != 2 {
+		t.Fatalf("got %s over HTTP/%d", res.Status, res.ProtoMajor)
+	}
+	if msg := readGRPCFrame(t, res.Body); msg != "hello" {
+		t.Errorf("got message %q", msg)
+	}
+	if _, err = io.Copy(io.Discard, res.Body); err != nil {
+		t.Fatal(err)
+	}
+	if s := res.Trailer.Get("Grpc-Status"); s != "0" {
+		t.Errorf("grpc-status trailer = %q, want 0", s)
+	}
+}
+
+func TestGRPCStreaming(t *testing.T) {
+	front := grpcFront(t)
+	pr, pw := io.Pipe()
+	msgs := []S{"one", "two", "three"}
+	// the echo server sends its headers with the first reply, so the
+	// first message has to go out before the response can arrive.
+	go pw.Write(grpcFrame(msgs[0]))
+	res, err := front.Client().Do(grpcRequest(t, front, pr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	// each reply is read before the next message is sent, so both
+	// directions have to be streamed through the proxy.
+	for i, msg := range msgs {
+		if i > 0 {
+			if _, err = pw.Write(grpcFrame(msg)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := readGRPCFrame(t, res.Body); got != msg {
+			t.Errorf("got message %q, want %q", got, msg)
+		}
+	}
+	pw.Close()
+	if _, err = io.Copy(io.Discard, res.Body); err != nil {
+		t.Fatal(err)
+	}
+	if s := res.Trailer.Get("Grpc-Status"); s != "0" {
+		t.Errorf("grpc-status trailer = %q, want 0", s)
+	}
+}
+
+func TestGRPCS(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(grpcEcho))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+	opts, err := (&Config{DialTimeout: time.Second}).options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("grpcs://" + backend.Listener.Addr().String())
+	rp := grpcProxy(opts, "grpc.test", u).(*httputil.ReverseProxy)
+	// trust the test server's certificate.
+	rp.Transport.(*http2.Transport).TLSClientConfig =
+		backend.Client().Transport.(*http.Transport).TLSClientConfig
+	front := serveH2(t, rp)
+	res, err := front.Client().Do(grpcRequest(t, front,
+		strings.NewReader(S(grpcFrame("hello")))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if msg := readGRPCFrame(t, res.Body); msg != "hello" {
+		t.Errorf("got message %q", msg)
+	}
+	io.Copy(io.Discard, res.Body)
+	if s := res.Trailer.Get("Grpc-Status"); s != "0" {
+		t.Errorf("grpc-status trailer = %q, want 0", s)
+	}
+}
+
+func TestGRPCSDialTimeout(t *testing.T) {
+	// the backend accepts connections but never answers the TLS handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	h, err := NewHandler(&Config{DialTimeout: time.Minute,
+		HostDialTimeouts: []S{"grpc.test:200ms"}},
+		map[S]S{"grpc.test": "grpcs://" + l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := serveH2(t, h)
+	start := time.Now()
+	res, err := front.Client().Do(grpcRequest(t, front,
+		strings.NewReader(S(grpcFrame("hello")))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusGatewayTimeout {
+		t.Errorf("status %d, want %d", res.StatusCode,
+			http.StatusGatewayTimeout)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("took %v with a 200ms dial timeout", d)
+	}
+}
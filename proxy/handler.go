@@ -0,0 +1,325 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"lerproxy.mleku.dev/bluegreen"
+	"lerproxy.mleku.dev/buf"
+	"lerproxy.mleku.dev/cachepolicy"
+	"lerproxy.mleku.dev/command"
+	"lerproxy.mleku.dev/cors"
+	"lerproxy.mleku.dev/favicon"
+	"lerproxy.mleku.dev/matchroute"
+	"lerproxy.mleku.dev/methods"
+	"lerproxy.mleku.dev/notfound"
+	"lerproxy.mleku.dev/reverse"
+	"lerproxy.mleku.dev/router"
+	"lerproxy.mleku.dev/srv"
+	"lerproxy.mleku.dev/timeout"
+	"lerproxy.mleku.dev/tunnel"
+	"lerproxy.mleku.dev/union"
+)
+
+// NostrJSON is the content of a NIP-05 nostr.json file.
+type NostrJSON struct {
+	Names  map[string]string   `json:"names"`
+	Relays map[string][]string `json:"relays"`
+}
+
+// NewHandler builds the handler that routes requests for each host in the
+// mapping to its backend.
+func NewHandler(c *Config, mapping map[string]string) (h http.Handler,
+	err error) {
+	if len(mapping) == 0 && !c.AllowEmptyMapping {
+		return nil, fmt.Errorf("empty mapping")
+	}
+	routes := make(map[S][]hostRoute)
+	var opts *options
+	if opts, err = c.options(); chk.E(err) {
+		return
+	}
+	for key, ba := range mapping {
+		hn, method, path, perr := ParseRoute(key)
+		if perr != nil {
+			// one bad line should not take down the other hosts.
+			log.W.F("skipping mapping of %q to %q: %v", key, ba, perr)
+			continue
+		}
+		var bh http.Handler
+		if bh, path = backend(c, opts, hn, path, ba); bh != nil {
+			routes[hn] = append(routes[hn], hostRoute{method, path, bh})
+		}
+	}
+	page := &notfound.Page{}
+	if c.NotFound != "" {
+		if page.Body, err = os.ReadFile(c.NotFound); chk.E(err) {
+			return
+		}
+		page.ContentType = mime.TypeByExtension(filepath.Ext(c.NotFound))
+	}
+	var icon *favicon.Icon
+	if c.Favicon != "" {
+		icon = &favicon.Icon{
+			ContentType: mime.TypeByExtension(filepath.Ext(c.Favicon)),
+		}
+		if icon.Body, err = os.ReadFile(c.Favicon); chk.E(err) {
+			return
+		}
+		page.Favicon = icon
+	}
+	rt := router.New(page)
+	if c.splits == nil {
+		c.splits = &bluegreen.Splits{}
+	}
+	for hn := range opts.green {
+		if _, ok := routes[hn]; !ok {
+			log.W.F("green backend for %s is not used, the host is not "+
+				"mapped", hn)
+		}
+	}
+	for hn, rs := range routes {
+		var hh http.Handler
+		// a host routed as a whole needs no pattern matching at all.
+		if len(rs) == 1 && rs[0].method == "" && rs[0].path == "/" {
+			hh = rs[0].handler
+		} else {
+			hh = hostMux(hn, rs, page)
+		}
+		if ba, ok := opts.green[hn]; ok {
+			// green replaces the host as a whole, whatever routes blue has.
+			if gh, _ := backend(c, opts, hn, "/", ba); gh != nil {
+				hh = &bluegreen.Handler{Blue: hh, Green: gh,
+					Split: c.splits.For(hn)}
+			}
+		}
+		if mrs := opts.matchRoutes[hn]; len(mrs) > 0 {
+			mh := &matchroute.Handler{Default: hh}
+			for _, mr := range mrs {
+				if mr.rule.Handler, _ = backend(c, opts, hn, "/",
+					mr.backend); mr.rule.Handler != nil {
+					mh.Rules = append(mh.Rules, mr.rule)
+				}
+			}
+			hh = mh
+		}
+		if allowed := opts.allowMethods[hn]; len(allowed) > 0 {
+			hh = &methods.Handler{Handler: hh, Methods: allowed}
+		}
+		if opts.verbatimPath[hn] {
+			hh = router.Verbatim{Handler: hh}
+		}
+		rt.Handle(hn, hh)
+	}
+	if icon != nil {
+		return &favicon.Handler{Handler: rt, Icon: icon}, nil
+	}
+	return rt, nil
+}
+
+// backend builds the handler for the backend ba of the host hn, returning
+// nil if it cannot be used. The path of the route is returned, which a
+// backend serving a fixed location replaces.
+func backend(c *Config, opts *options, hn, path, ba S) (bh http.Handler,
+	p S) {
+
+	p = path
+	var err E
+	b := ParseBackend(ba)
+	switch b.Kind {
+	case Exec:
+		if !filepath.IsAbs(b.Path) {
+			log.E.F("exec backend for %s must be an absolute path: %s",
+				hn, b.Path)
+			return
+		}
+		bh = &command.Handler{
+			Path:      b.Path,
+			Timeout:   c.ExecTimeout,
+			MaxOutput: c.ExecMaxOutput,
+		}
+	case GoVanity:
+		repo := b.Path
+		redirector := fmt.Sprintf(
+			`<html><head><meta name="go-import" content="%s git %s"/><meta http-equiv = "refresh" content = " 3 ; url = %s"/></head><body>redirecting to <a href="%s">%s</a></body></html>`,
+			hn, repo, repo, repo, repo)
+		bh = http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			cors.Default.Set(writer.Header())
+			writer.Header().Set("Content-Type", "text/html")
+			writer.Header().Set("Content-Length", fmt.Sprint(len(redirector)))
+			writer.Header().Set("strict-transport-security", "max-age=0; includeSubDomains")
+			fmt.Fprint(writer, redirector)
+		})
+	case Static:
+		// path specified as directory with explicit trailing slash; add
+		// this path as static site
+		var root http.FileSystem = http.Dir(b.Path)
+		if len(b.Dirs) > 1 {
+			root = union.Dirs(b.Dirs...)
+		}
+		bh = http.FileServer(root)
+		if rules := opts.cacheControl[hn]; len(rules) > 0 {
+			bh = &cachepolicy.Handler{Handler: bh, Rules: rules}
+		}
+	case Nostr:
+		log.I.Ln(hn, b.Path)
+		var fb []byte
+		if fb, err = os.ReadFile(b.Path); chk.E(err) {
+			return
+		}
+		var v NostrJSON
+		if err = json.Unmarshal(fb, &v); chk.E(err) {
+			return
+		}
+		var jb []byte
+		if jb, err = json.Marshal(v); chk.E(err) {
+			return
+		}
+		nostrJSON := string(jb)
+		p = "/.well-known/nostr.json"
+		// NIP-05 clients are mostly web apps on other origins, so
+		// preflight requests are answered too.
+		bh = &cors.Handler{
+			Policy: cors.Default,
+			Handler: http.HandlerFunc(
+				func(writer http.ResponseWriter, request *http.Request) {
+					log.I.Ln("serving nostr json to", hn)
+					writer.Header().Set("Content-Type", "application/json")
+					writer.Header().Set("Content-Length", fmt.Sprint(len(nostrJSON)))
+					writer.Header().Set("strict-transport-security",
+						"max-age=0; includeSubDomains")
+					fmt.Fprint(writer, nostrJSON)
+				}),
+		}
+	case HTTP:
+		u := b.URL
+		rp := reverse.NewSingleHostReverseProxy(u)
+		modifyCORSResponse := func(res *http.Response) error {
+			// res.Header.Set("Access-Control-Allow-Credentials", "true")
+			cors.Default.Set(res.Header)
+			return nil
+		}
+		rp.ModifyResponse = modifyCORSResponse
+		rp.BufferPool = buf.Pool{}
+		opts.configure(rp, hn, b.Target(), u)
+		bh = rp
+	case SRV:
+		name := b.URL.Host
+		res := srv.New(name, c.SRVTTL, opts.lbStrategy)
+		timeout := opts.dialTimeoutFor(hn)
+		var transport http.RoundTripper = &http.Transport{
+			DialContext: func(ctx context.Context, n,
+				_ string) (net.Conn, error) {
+
+				return res.Dial(ctx, n, timeout)
+			},
+		}
+		if res.Strategy == srv.Latency {
+			transport = &srv.Transport{RoundTripper: transport, Resolver: res}
+		}
+		rp := &httputil.ReverseProxy{
+			Director: func(req *http.Request) {
+				req.URL.Scheme = "http"
+				// the target is chosen when dialing, this only keys the
+				// connection pool.
+				req.URL.Host = name
+				req.Header.Set("X-Forwarded-Proto", "https")
+				log.D.Ln(req.URL, req.RemoteAddr)
+			},
+			Transport:  transport,
+			BufferPool: buf.Pool{},
+		}
+		opts.configure(rp, hn, b.Target(), nil)
+		bh = rp
+	case GRPC:
+		bh = grpcProxy(opts, hn, b.URL)
+	case Connect:
+		// the request target of CONNECT has no path to route by.
+		if path != "/" {
+			log.E.F("connect backend for %s must route the whole host, "+
+				"not %s", hn, path)
+			return
+		}
+		bh = &tunnel.Handler{Allow: b.Targets,
+			DialTimeout: opts.dialTimeoutFor(hn)}
+	default:
+		if b.Kind == Unix {
+			if _, serr := os.Stat(b.Address); serr != nil {
+				// the backend may create it later, so only warn.
+				log.W.F("unix socket %s for %s does not exist yet, "+
+					"requests will fail until it does", b.Address, hn)
+			}
+		}
+		bh = fallbackProxy(opts, hn, b.Network, b.Address)
+	}
+	if b.ReadTimeout > 0 || b.WriteTimeout > 0 {
+		bh = &timeout.Handler{Handler: bh, Read: b.ReadTimeout,
+			Write: b.WriteTimeout}
+	}
+	return
+}
+
+// hostMux routes the requests for a host with patterns by their method and
+// path, answering those matching none with page.
+func hostMux(hn S, rs []hostRoute, page *notfound.Page) http.Handler {
+	// the router has matched the host already, and wildcard hosts are not
+	// valid in patterns, so they only have the method and path.
+	mux := http.NewServeMux()
+	for _, r := range rs {
+		if err := handle(mux, pattern("", r.method, r.path),
+			r.handler); err != nil {
+			log.W.F("skipping route for %s: %v", hn, err)
+		}
+	}
+	return &notfound.Handler{ServeMux: mux, Page: page}
+}
+
+// hostRoute is the method and path pattern of a route of a host, and its
+// handler.
+type hostRoute struct {
+	method, path S
+	handler      http.Handler
+}
+
+// fallbackProxy proxies http to a tcp or unix socket address, passing the
+// Host of the request through unless another is configured for the host.
+// The address dialed does not depend on it.
+func fallbackProxy(opts *options, hn, network, addr S) http.Handler {
+	timeout := opts.dialTimeoutFor(hn)
+	backendHost := opts.backendHost[hn]
+	rp := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			if backendHost != "" {
+				req.Host = backendHost
+			}
+			req.URL.Scheme = "http"
+			req.URL.Host = req.Host
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("Access-Control-Allow-Methods", "GET,HEAD,PUT,PATCH,POST,DELETE")
+			// req.Header.Set("Access-Control-Allow-Credentials", "true")
+			req.Header.Set("Access-Control-Allow-Origin", "*")
+			log.D.Ln(req.URL, req.RemoteAddr)
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, n, _ string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, timeout)
+			},
+		},
+		BufferPool: buf.Pool{},
+	}
+	var target *url.URL
+	if network == "tcp" {
+		target = &url.URL{Scheme: "http", Host: addr}
+	}
+	opts.configure(rp, hn, strings.TrimSuffix(addr, "\x00"), target)
+	return rp
+}
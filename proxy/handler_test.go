@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAllowMethods(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	h, err := NewHandler(&Config{AllowMethods: []S{"ro.test:GET"}},
+		map[S]S{"ro.test": backend.URL, "rw.test": backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		method, host S
+		status       int
+	}{
+		{http.MethodGet, "ro.test", http.StatusOK},
+		{http.MethodHead, "ro.test", http.StatusOK},
+		{http.MethodPost, "ro.test", http.StatusMethodNotAllowed},
+		{http.MethodPost, "rw.test", http.StatusOK},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method,
+			"https://"+tc.host+"/", nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.host, w.Code,
+				tc.status)
+		}
+		if tc.status == http.StatusMethodNotAllowed &&
+			w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s %s: Allow %q", tc.method, tc.host,
+				w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestUnixSocketStartedLater(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	h, err := NewHandler(&Config{}, map[S]S{"sock.test": path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func() int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+			"https://sock.test/", nil))
+		return w.Code
+	}
+	if status := get(); status != http.StatusBadGateway {
+		t.Errorf("missing socket: status %d", status)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	if status := get(); status != http.StatusOK {
+		t.Errorf("socket created after start: status %d", status)
+	}
+}
+
+func TestHostCase(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	h, err := NewHandler(&Config{},
+		map[S]S{"Example.COM": backend.URL, "*.Wild.example": backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		host   S
+		status int
+	}{
+		{"example.com", http.StatusOK},
+		{"EXAMPLE.Com", http.StatusOK},
+		{"Example.COM:443", http.StatusOK},
+		{"A.WILD.example", http.StatusOK},
+		{"other.com", http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("Host %s: status %d, want %d", tc.host, w.Code,
+				tc.status)
+		}
+	}
+}
+
+// TestBackendHostUnix checks the Host a unix socket backend receives, that
+// of the request unless --backend-host sets another for the host.
+func TestBackendHostUnix(t *testing.T) {
+	sockets := map[S]S{"path": filepath.Join(t.TempDir(), "app.sock")}
+	if runtime.GOOS == "linux" {
+		sockets["abstract"] = fmt.Sprintf("@lerproxy-test-%d", os.Getpid())
+	}
+	for kind, v := range sockets {
+		// listen where the backend dials, which for an abstract socket
+		// ends with a NUL.
+		l, err := net.Listen("unix", ParseBackend(v).Address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		go http.Serve(l, http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("X-Host", r.Host)
+			}))
+		h, err := NewHandler(&Config{
+			BackendHosts: []S{"set.test:app.internal"}},
+			map[S]S{"set.test": v, "plain.test": v})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for host, want := range map[S]S{"set.test": "app.internal",
+			"plain.test": "plain.test"} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+				"https://"+host+"/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s %s: status %d", kind, host, w.Code)
+			}
+			if got := w.Header().Get("X-Host"); got != want {
+				t.Errorf("%s %s: backend got Host %q, want %q", kind, host,
+					got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ReadMapping reads a mapping file of "host: backend" lines. Empty lines and
+// lines starting with # are ignored, and a host may only appear once. file
+// may also be an http or https URL that the mapping is fetched from.
+func ReadMapping(file string) (m map[string]string, err error) {
+	if IsURL(file) {
+		m, _, err = (&remoteMapping{url: file}).fetch(context.Background())
+		return
+	}
+	if err = regularFile(file); chk.E(err) {
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(file); chk.E(err) {
+		return
+	}
+	m, err = parseMapping(f, file)
+	chk.E(f.Close())
+	return
+}
+
+// parseMapping reads the mapping lines of r, naming it in errors.
+func parseMapping(r io.Reader, name S) (m map[S]S, err E) {
+	m = make(map[S]S)
+	// lines records where each host was defined to report duplicates.
+	lines := make(map[string]int)
+	sc := bufio.NewScanner(r)
+	for line := 1; sc.Scan(); line++ {
+		if b := sc.Bytes(); len(b) == 0 || b[0] == '#' {
+			continue
+		}
+		s := strings.SplitN(sc.Text(), ":", 2)
+		if len(s) != 2 {
+			err = fmt.Errorf("invalid line: %q", sc.Text())
+			log.E.Ln(err)
+			return
+		}
+		host := strings.TrimSpace(s[0])
+		// hosts differing only in case are the same route to the router.
+		route := host
+		if h, method, path, perr := ParseRoute(host); perr == nil {
+			route = method + " " + h + path
+		}
+		if prev, ok := lines[route]; ok {
+			err = fmt.Errorf("%s:%d: duplicate host %q, first defined on line %d",
+				name, line, host, prev)
+			log.E.Ln(err)
+			return
+		}
+		lines[route] = line
+		v := strings.TrimSpace(s[1])
+		if err = validBackend(v); err != nil {
+			err = fmt.Errorf("%s:%d: %w", name, line, err)
+			log.E.Ln(err)
+			return
+		}
+		m[host] = v
+	}
+	err = sc.Err()
+	chk.E(err)
+	return
+}
+
+// regularFile checks that file is, or is a symlink to, a regular file,
+// since reading a directory or a device line by line fails confusingly.
+func regularFile(file S) (err E) {
+	var fi os.FileInfo
+	if fi, err = os.Stat(file); err != nil {
+		if _, lerr := os.Lstat(file); lerr == nil {
+			err = fmt.Errorf("%s is a broken symlink: %w", file, err)
+		}
+		return
+	}
+	switch {
+	case fi.IsDir():
+		err = fmt.Errorf("%s is a directory, not a mapping file", file)
+	case !fi.Mode().IsRegular():
+		err = fmt.Errorf("%s is not a regular file", file)
+	}
+	return
+}
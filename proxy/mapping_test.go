@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeMapping writes a mapping file with content to a temporary directory
+// and returns its path.
+func writeMapping(t *testing.T, content S) (file S) {
+	t.Helper()
+	file = filepath.Join(t.TempDir(), "mapping.txt")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestReadMappingDuplicateHost(t *testing.T) {
+	file := writeMapping(t, "# hosts\n"+
+		"example.com: 127.0.0.1:8080\n"+
+		"other.com: 127.0.0.1:8081\n"+
+		"\n"+
+		"example.com: 127.0.0.1:9090\n")
+	_, err := ReadMapping(file)
+	if err == nil {
+		t.Fatal("duplicate host accepted")
+	}
+	for _, want := range []S{file + ":5:", `"example.com"`, "line 2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestReadMapping(t *testing.T) {
+	m, err := ReadMapping(writeMapping(t, "example.com: 127.0.0.1:8080\n"+
+		"example.com/api/: 127.0.0.1:9000\n"+
+		"GET example.com/api/: 127.0.0.1:9001\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 3 {
+		t.Errorf("got %d entries, want 3: %v", len(m), m)
+	}
+}
+
+func TestReadMappingDuplicateHostCase(t *testing.T) {
+	file := writeMapping(t, "example.com: 127.0.0.1:8080\n"+
+		"Example.COM: 127.0.0.1:9090\n")
+	_, err := ReadMapping(file)
+	if err == nil {
+		t.Fatal("host differing only in case accepted")
+	}
+	for _, want := range []S{file + ":2:", `"Example.COM"`, "line 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	// paths are case sensitive, so these are different routes.
+	if _, err = ReadMapping(writeMapping(t,
+		"GET Example.com/API/: 127.0.0.1:8080\n"+
+			"GET example.com/api/: 127.0.0.1:9090\n")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadMappingNotRegular(t *testing.T) {
+	dir := t.TempDir()
+	file := writeMapping(t, "example.com: 127.0.0.1:8080\n")
+	link := filepath.Join(dir, "link.txt")
+	broken := filepath.Join(dir, "broken.txt")
+	if err := os.Symlink(file, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "gone"), broken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMapping(link); err != nil {
+		t.Errorf("symlink to a mapping file: %v", err)
+	}
+	for _, tc := range []struct {
+		file, want S
+	}{
+		{dir, "is a directory"},
+		{broken, "is a broken symlink"},
+		{os.DevNull, "is not a regular file"},
+	} {
+		_, err := ReadMapping(tc.file)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("ReadMapping(%q) = %v, want %q", tc.file, err, tc.want)
+		}
+	}
+	missing := filepath.Join(dir, "missing.txt")
+	if _, err := ReadMapping(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadMapping(%q) = %v, want not exist", missing, err)
+	}
+}
+
+func TestReadMappingInvalidBackend(t *testing.T) {
+	file := writeMapping(t, "example.com: 127.0.0.1:8080\n"+
+		"other.com: ftp://files.example.com\n")
+	_, err := ReadMapping(file)
+	if err == nil {
+		t.Fatal("unsupported scheme accepted")
+	}
+	for _, want := range []S{file + ":2:", `"ftp"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+}
+
+func TestReadMappingOptions(t *testing.T) {
+	m, err := ReadMapping(writeMapping(t,
+		"example.com: http://127.0.0.1:8000/?a=b rto=10s wto=2m\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := ParseBackend(m["example.com"]); b.ReadTimeout != 10*time.Second ||
+		b.WriteTimeout != 2*time.Minute || b.URL.RawQuery != "a=b" {
+		t.Errorf("got %+v", b)
+	}
+	file := writeMapping(t, "example.com: 127.0.0.1:8080\n"+
+		"other.com: 127.0.0.1:8081 rto=soon\n")
+	if _, err = ReadMapping(file); err == nil ||
+		!strings.Contains(err.Error(), file+":2:") {
+		t.Errorf("got %v, want an error on line 2", err)
+	}
+}
@@ -0,0 +1,466 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	stdLog "log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"lerproxy.mleku.dev/cachepolicy"
+	"lerproxy.mleku.dev/coalesce"
+	"lerproxy.mleku.dev/compression"
+	"lerproxy.mleku.dev/dnscache"
+	"lerproxy.mleku.dev/headerlog"
+	"lerproxy.mleku.dev/headers"
+	"lerproxy.mleku.dev/logging"
+	"lerproxy.mleku.dev/matchroute"
+	"lerproxy.mleku.dev/methods"
+	"lerproxy.mleku.dev/reverse"
+	"lerproxy.mleku.dev/routelog"
+	"lerproxy.mleku.dev/srv"
+	"lerproxy.mleku.dev/tracing"
+)
+
+// options are the per host settings of the Config, indexed by host.
+type options struct {
+	rewriteLocation map[S]bool
+	debugHeaders    map[S]bool
+	headers         headers.Rules
+	gunzip          map[S]bool
+	errorLog        *stdLog.Logger
+	lbStrategy      srv.Strategy
+	cacheControl    cachepolicy.Rules
+	origin          map[S]S
+	rewriteBody     reverse.BodyRewriters
+	remapStatus     reverse.StatusRemaps
+	tracing         bool
+	traceRouting    bool
+	allowMethods    methods.Allowed
+	trusted         reverse.Trusted
+	compress        map[S]*compression.Compressor
+	dialTimeout     time.Duration
+	dialTimeouts    map[S]time.Duration
+	green           map[S]S
+	clientIP        reverse.ClientIPHeaders
+	noKeepAlive     map[S]bool
+	budgets         map[S]time.Duration
+	stale           map[S]S
+	pinDNS          map[S]time.Duration
+	backendHost     map[S]S
+	matchRoutes     map[S][]matchRoute
+	verbatimPath    map[S]bool
+	stream          map[S]bool
+	altSvc          map[S]S
+	coalesce        map[S]bool
+	coalesceMax     int64
+	via             bool
+	backendHeader   S
+	stripRequest    []S
+	stripResponse   []S
+	instrument      func(host, backend S,
+		rt http.RoundTripper) http.RoundTripper
+}
+
+// matchRoute is a rule sending requests to a host that match it to another
+// backend.
+type matchRoute struct {
+	rule    matchroute.Rule
+	backend S
+}
+
+func (c *Config) options() (o *options, err error) {
+	o = &options{
+		rewriteLocation: set(c.RewriteLocation),
+		debugHeaders:    set(c.DebugHeaders),
+		headers:         make(headers.Rules),
+		cacheControl:    make(cachepolicy.Rules),
+		rewriteBody:     make(reverse.BodyRewriters),
+		remapStatus:     make(reverse.StatusRemaps),
+		gunzip:          set(c.Gunzip),
+		noKeepAlive:     set(c.NoKeepAlive),
+		verbatimPath:    set(c.VerbatimPath),
+		stream:          set(c.Stream),
+		coalesce:        set(c.Coalesce),
+		coalesceMax:     c.CoalesceMaxSize,
+		errorLog:        c.ErrorLog,
+		tracing:         c.Tracing,
+		traceRouting:    c.TraceRouting,
+		via:             c.Via,
+		backendHeader:   http.CanonicalHeaderKey(c.BackendHeader),
+		instrument:      c.InstrumentBackend,
+		stripRequest:    canonical(c.StripRequestHeaders),
+		stripResponse:   canonical(c.StripResponseHeaders),
+		allowMethods:    make(methods.Allowed),
+		dialTimeout:     c.DialTimeout,
+		clientIP:        make(reverse.ClientIPHeaders),
+	}
+	if o.errorLog == nil {
+		o.errorLog = stdLog.New(logging.Writer, "", 0)
+	}
+	if c.LBStrategy != "" {
+		if o.lbStrategy, err = srv.ParseStrategy(c.LBStrategy); chk.E(err) {
+			return
+		}
+	}
+	if err = o.headers.Parse(c.Headers, false); chk.E(err) {
+		return
+	}
+	if err = o.headers.Parse(c.HeadersOverride, true); chk.E(err) {
+		return
+	}
+	if err = o.clientIP.Parse(c.ClientIPHeaders); chk.E(err) {
+		return
+	}
+	if o.backendHost, err = values("backend host",
+		c.BackendHosts); chk.E(err) {
+		return
+	}
+	o.matchRoutes = make(map[S][]matchRoute)
+	if err = o.parseMatchRoutes(c.RouteHeaders, false); chk.E(err) {
+		return
+	}
+	if err = o.parseMatchRoutes(c.RouteCookies, true); chk.E(err) {
+		return
+	}
+	if o.stale, err = values("stale directory", c.Stale); chk.E(err) {
+		return
+	}
+	if o.altSvc, err = values("alt-svc", c.AltSvc); chk.E(err) {
+		return
+	}
+	if o.green, err = c.green(); chk.E(err) {
+		return
+	}
+	if o.dialTimeouts, err = durations(c.HostDialTimeouts); chk.E(err) {
+		return
+	}
+	if o.budgets, err = durations(c.ResponseBudgets); chk.E(err) {
+		return
+	}
+	if o.pinDNS, err = durations(c.PinDNS); chk.E(err) {
+		return
+	}
+	if o.trusted, err = reverse.ParseTrusted(c.TrustedProxies); chk.E(err) {
+		return
+	}
+	if err = o.allowMethods.Parse(c.AllowMethods); chk.E(err) {
+		return
+	}
+	if err = o.remapStatus.Parse(c.RemapStatus); chk.E(err) {
+		return
+	}
+	if err = o.cacheControl.Parse(c.CacheControl); chk.E(err) {
+		return
+	}
+	if o.origin, err = values("origin", c.Origins); chk.E(err) {
+		return
+	}
+	if err = o.rewriteBody.Parse(c.RewriteBody, c.RewriteBodyTypes,
+		c.RewriteBodyMaxSize); chk.E(err) {
+		return
+	}
+	if len(c.Compress) > 0 {
+		cp := &compression.Compressor{
+			Types:   c.CompressTypes,
+			MinSize: c.CompressMinSize,
+		}
+		encoders := c.CompressEncoders
+		if len(encoders) == 0 {
+			encoders = []S{"zstd", "gzip"}
+		}
+		if cp.Encoders, err = compression.ParseEncoders(encoders); chk.E(err) {
+			return
+		}
+		if len(cp.Types) == 0 {
+			cp.Types = compression.DefaultTypes
+		}
+		o.compress = make(map[S]*compression.Compressor)
+		for _, host := range c.Compress {
+			o.compress[strings.ToLower(host)] = cp
+		}
+	}
+	return
+}
+
+// setOrigin replaces the Origin header of a request to the backend of host
+// with the one configured for it, or removes it if that is "-". Requests
+// without one are left alone, as only browsers send it.
+func (o *options) setOrigin(host S, h http.Header) {
+	origin, ok := o.origin[host]
+	if !ok || h.Get("Origin") == "" {
+		return
+	}
+	if origin == "-" {
+		h.Del("Origin")
+		return
+	}
+	h.Set("Origin", origin)
+}
+
+// parseMatchRoutes reads routes in the form
+// "example.com:X-Experiment=beta:backend", matching a cookie instead of a
+// header if cookie is set.
+func (o *options) parseMatchRoutes(specs []S, cookie bool) (err E) {
+	for _, spec := range specs {
+		host, rest, _ := strings.Cut(spec, ":")
+		cond, ba, _ := strings.Cut(rest, ":")
+		host = strings.ToLower(host)
+		if host == "" || strings.TrimSpace(ba) == "" {
+			err = fmt.Errorf("invalid route parameter format: `%s`", spec)
+			return
+		}
+		var r matchroute.Rule
+		if r, err = matchroute.Parse(cond, cookie); chk.E(err) {
+			return
+		}
+		o.matchRoutes[host] = append(o.matchRoutes[host],
+			matchRoute{r, strings.TrimSpace(ba)})
+	}
+	return
+}
+
+// canonical returns the canonical form of the header names.
+func canonical(names []S) (c []S) {
+	for _, n := range names {
+		c = append(c, http.CanonicalHeaderKey(strings.TrimSpace(n)))
+	}
+	return
+}
+
+// strip removes the headers named from h.
+func strip(h http.Header, names []S) {
+	for _, n := range names {
+		delete(h, n)
+	}
+}
+
+// stripTrailer removes the headers named from the trailer of a response once
+// its body is read, since the transport only fills in the values then.
+type stripTrailer struct {
+	io.ReadCloser
+	trailer http.Header
+	names   []S
+}
+
+func (b *stripTrailer) Read(p []byte) (n int, err E) {
+	if n, err = b.ReadCloser.Read(p); err == io.EOF {
+		strip(b.trailer, b.names)
+	}
+	return
+}
+
+// dialTimeoutFor returns how long connecting to the backend of host may
+// take, zero for no limit.
+func (o *options) dialTimeoutFor(host S) time.Duration {
+	if d, ok := o.dialTimeouts[host]; ok {
+		return d
+	}
+	return o.dialTimeout
+}
+
+// noKeepAlive makes rt open a new connection for each request and close it
+// after the response, asking the backend to with Connection: close.
+func noKeepAlive(host S, rt http.RoundTripper) http.RoundTripper {
+	switch t := rt.(type) {
+	case nil:
+		dt := http.DefaultTransport.(*http.Transport).Clone()
+		dt.DisableKeepAlives = true
+		return dt
+	case *http.Transport:
+		t.DisableKeepAlives = true
+	case *srv.Transport:
+		t.RoundTripper = noKeepAlive(host, t.RoundTripper)
+	default:
+		log.W.F("keep-alive cannot be disabled for the backend of %s", host)
+	}
+	return rt
+}
+
+// pinDNS makes rt, the transport of an http backend, dial the addresses of
+// the backend's hostname cached for ttl.
+func pinDNS(host S, rt http.RoundTripper, ttl time.Duration) http.RoundTripper {
+	t, ok := rt.(*http.Transport)
+	if rt == nil {
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	} else if !ok {
+		log.W.F("backend addresses of %s cannot be cached", host)
+		return rt
+	}
+	dial := t.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	t.DialContext = (&dnscache.Cache{TTL: ttl}).Dial(dial)
+	return t
+}
+
+// configure applies the options for host to the reverse proxy for it, after
+// whatever ModifyResponse and Transport it already has. backend is the
+// backend as configured, which metrics are labeled with, and target its URL,
+// or nil if it has none that redirects could point at.
+func (o *options) configure(rp *httputil.ReverseProxy, host, backend S,
+	target *url.URL) {
+
+	// every kind of backend gets the same X-Forwarded-For: the chain of
+	// trusted proxies, if any, ending with the client.
+	if d := rp.Director; d != nil {
+		rp.Director = func(req *http.Request) {
+			d(req)
+			routelog.Add(req.Context(), "backend %s", backend)
+			o.trusted.StripForwardedFor(req.Header, req.RemoteAddr)
+			o.clientIP.Set(host, req.Header, req.RemoteAddr)
+			o.setOrigin(host, req.Header)
+			if o.via {
+				reverse.AddVia(req.Header, req.ProtoMajor, req.ProtoMinor)
+			}
+			strip(req.Header, o.stripRequest)
+		}
+	}
+	if rw := rp.Rewrite; rw != nil {
+		rp.Rewrite = func(pr *httputil.ProxyRequest) {
+			rw(pr)
+			routelog.Add(pr.In.Context(), "backend %s", backend)
+			// SetXForwarded appends to the incoming chain.
+			if !o.trusted.Contains(pr.In.RemoteAddr) {
+				pr.Out.Header.Del("X-Forwarded-For")
+				if ip, _, err := net.SplitHostPort(
+					pr.In.RemoteAddr); err == nil {
+
+					pr.Out.Header.Set("X-Forwarded-For", ip)
+				}
+			}
+			o.clientIP.Set(host, pr.Out.Header, pr.In.RemoteAddr)
+			o.setOrigin(host, pr.Out.Header)
+			if o.via {
+				reverse.AddVia(pr.Out.Header, pr.In.ProtoMajor,
+					pr.In.ProtoMinor)
+			}
+			strip(pr.Out.Header, o.stripRequest)
+		}
+	}
+	var mods []func(*http.Response) error
+	if rules := o.remapStatus[host]; len(rules) > 0 {
+		// first, so that the others see the status the client gets.
+		mods = append(mods, reverse.RemapStatus(rules))
+	}
+	stale, hasStale := o.stale[host]
+	if hasStale {
+		// after the remapping, before the others, which have no business
+		// with a failed response.
+		mods = append(mods, reverse.FailServerErrors)
+	}
+	if rp.ModifyResponse != nil {
+		mods = append(mods, rp.ModifyResponse)
+	}
+	if target != nil && o.rewriteLocation[host] {
+		mods = append(mods, func(res *http.Response) error {
+			reverse.RewriteLocation(res, target)
+			return nil
+		})
+	}
+	if br := o.rewriteBody[host]; br != nil {
+		mods = append(mods, br.ModifyResponse)
+	}
+	if o.gunzip[host] {
+		mods = append(mods, reverse.Gunzip)
+	}
+	if cp := o.compress[host]; cp != nil {
+		mods = append(mods, cp.ModifyResponse)
+	}
+	if name := o.backendHeader; name != "" {
+		mods = append(mods, func(res *http.Response) error {
+			if addr := reverse.BackendAddr(res.Request); addr != "" {
+				res.Header.Set(name, addr)
+			}
+			return nil
+		})
+	}
+	if o.via {
+		mods = append(mods, func(res *http.Response) error {
+			reverse.AddVia(res.Header, res.ProtoMajor, res.ProtoMinor)
+			return nil
+		})
+	}
+	if altSvc, ok := o.altSvc[host]; ok {
+		mods = append(mods, func(res *http.Response) error {
+			res.Header.Set("Alt-Svc", altSvc)
+			return nil
+		})
+	}
+	if rules := o.headers[host]; len(rules) > 0 {
+		mods = append(mods, func(res *http.Response) error {
+			headers.Apply(res.Header, rules)
+			return nil
+		})
+	}
+	if len(o.stripResponse) > 0 {
+		// last, so that nothing added before leaks either.
+		mods = append(mods, func(res *http.Response) error {
+			strip(res.Header, o.stripResponse)
+			strip(res.Trailer, o.stripResponse)
+			if res.Trailer != nil {
+				res.Body = &stripTrailer{res.Body, res.Trailer,
+					o.stripResponse}
+			}
+			return nil
+		})
+	}
+	if len(mods) > 0 {
+		rp.ModifyResponse = func(res *http.Response) (err error) {
+			for _, m := range mods {
+				if err = m(res); err != nil {
+					return
+				}
+			}
+			return
+		}
+	}
+	if ttl := o.pinDNS[host]; ttl > 0 {
+		rp.Transport = pinDNS(host, rp.Transport, ttl)
+	}
+	if o.noKeepAlive[host] {
+		rp.Transport = noKeepAlive(host, rp.Transport)
+	}
+	if o.backendHeader != "" {
+		rp.Transport = &reverse.TraceBackend{RoundTripper: rp.Transport}
+	}
+	if o.traceRouting {
+		rp.Transport = &routelog.Transport{RoundTripper: rp.Transport}
+	}
+	if d := o.budgets[host]; d > 0 {
+		rp.Transport = &reverse.Budget{RoundTripper: rp.Transport, Timeout: d}
+	}
+	if o.instrument != nil {
+		// outside the budget, so that the requests it cancels count too.
+		rp.Transport = o.instrument(host, backend, rp.Transport)
+	}
+	if o.coalesce[host] {
+		// outside the instrumentation, which counts what reaches the
+		// backend.
+		rp.Transport = &coalesce.Transport{RoundTripper: rp.Transport,
+			MaxSize: o.coalesceMax}
+	}
+	if o.stream[host] {
+		rp.FlushInterval = -1
+	}
+	rp.ErrorLog = o.errorLog
+	rp.ErrorHandler = reverse.ErrorHandler(host, o.errorLog)
+	if hasStale {
+		rp.ErrorHandler = reverse.StaleHandler(host, o.errorLog,
+			http.FileServer(http.Dir(stale)))
+	}
+	if o.debugHeaders[host] {
+		rp.Transport = &headerlog.Transport{
+			RoundTripper: rp.Transport,
+			Host:         host,
+		}
+	}
+	if o.tracing {
+		rp.Transport = tracing.Transport(rp.Transport)
+	}
+}
@@ -0,0 +1,429 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNoKeepAlive(t *testing.T) {
+	var conns atomic.Int32
+	var closes atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Close {
+				closes.Add(1)
+			}
+		}))
+	backend.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+		if s == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+	for _, tc := range []struct {
+		name            S
+		noKeepAlive     []S
+		conns, closeReq int32
+	}{
+		{"keep-alive", nil, 1, 0},
+		{"no keep-alive", []S{"old.test"}, 3, 3},
+	} {
+		conns.Store(0)
+		closes.Store(0)
+		h, err := NewHandler(&Config{NoKeepAlive: tc.noKeepAlive},
+			map[S]S{"old.test": backend.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+				"https://old.test/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("%s: status %d", tc.name, w.Code)
+			}
+		}
+		if n := conns.Load(); n != tc.conns {
+			t.Errorf("%s: %d backend connections, want %d", tc.name, n,
+				tc.conns)
+		}
+		if n := closes.Load(); n != tc.closeReq {
+			t.Errorf("%s: %d requests with Connection: close, want %d",
+				tc.name, n, tc.closeReq)
+		}
+	}
+}
+
+func TestStale(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/broken" {
+				http.Error(w, "broken", http.StatusInternalServerError)
+				return
+			}
+			io.WriteString(w, "live")
+		}))
+	defer backend.Close()
+	down := httptest.NewServer(nil)
+	down.Close()
+	snapshot := t.TempDir()
+	for _, name := range []S{"broken", "page"} {
+		if err := os.WriteFile(filepath.Join(snapshot, name),
+			[]byte("snapshot"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := NewHandler(&Config{Stale: []S{"up.test:" + snapshot,
+		"down.test:" + snapshot}},
+		map[S]S{"up.test": backend.URL, "down.test": down.URL,
+			"plain.test": backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		method, url S
+		status      int
+		body        S
+		noStore     bool
+	}{
+		{http.MethodGet, "https://up.test/page", http.StatusOK, "live",
+			false},
+		{http.MethodGet, "https://up.test/broken", http.StatusOK,
+			"snapshot", true},
+		{http.MethodPost, "https://up.test/broken",
+			http.StatusInternalServerError, "", false},
+		{http.MethodGet, "https://down.test/page", http.StatusOK, "snapshot",
+			true},
+		{http.MethodGet, "https://down.test/missing", http.StatusNotFound,
+			"", true},
+		{http.MethodPost, "https://down.test/page", http.StatusBadGateway, "",
+			false},
+		{http.MethodGet, "https://plain.test/broken",
+			http.StatusInternalServerError, "broken\n", false},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.url, w.Code,
+				tc.status)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s %s: body %q, want %q", tc.method, tc.url,
+				w.Body.String(), tc.body)
+		}
+		if noStore := w.Header().Get("Cache-Control") ==
+			"no-store"; noStore != tc.noStore {
+			t.Errorf("%s %s: Cache-Control %q", tc.method, tc.url,
+				w.Header().Get("Cache-Control"))
+		}
+	}
+}
+
+func TestRouteHeaders(t *testing.T) {
+	backend := func(name S) *httptest.Server {
+		s := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, name)
+			}))
+		t.Cleanup(s.Close)
+		return s
+	}
+	stable, beta, canary := backend("stable"), backend("beta"),
+		backend("canary")
+	h, err := NewHandler(&Config{
+		RouteHeaders: []S{"exp.test:X-Experiment=beta:" + beta.URL},
+		RouteCookies: []S{"exp.test:group=canary:" + canary.URL},
+	}, map[S]S{"exp.test": stable.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		header, value, want S
+	}{
+		{"", "", "stable"},
+		{"X-Experiment", "beta", "beta"},
+		{"Cookie", "group=canary", "canary"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "https://exp.test/", nil)
+		if tc.header != "" {
+			r.Header.Set(tc.header, tc.value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Body.String(); got != tc.want {
+			t.Errorf("%s %s: served by %q, want %q", tc.header, tc.value,
+				got, tc.want)
+		}
+	}
+	for _, spec := range []S{"exp.test:X-Experiment=beta", ":a=b:" +
+		beta.URL, "exp.test:nocondition:" + beta.URL} {
+		if _, err = NewHandler(&Config{RouteHeaders: []S{spec}},
+			map[S]S{"exp.test": stable.URL}); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+func TestVia(t *testing.T) {
+	var via []S
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			via = r.Header.Values("Via")
+			w.Header().Set("Via", "1.1 app")
+		}))
+	defer backend.Close()
+	for _, on := range []bool{false, true} {
+		h, err := NewHandler(&Config{Via: on},
+			map[S]S{"via.test": backend.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest(http.MethodGet, "https://via.test/", nil)
+		r.Header.Set("Via", "1.1 cdn")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		wantReq, wantRes := []S{"1.1 cdn"}, []S{"1.1 app"}
+		if on {
+			wantReq = append(wantReq, "1.1 lerproxy")
+			wantRes = append(wantRes, "1.1 lerproxy")
+		}
+		if !slices.Equal(via, wantReq) {
+			t.Errorf("via %v: request Via %q, want %q", on, via, wantReq)
+		}
+		if res := w.Header().Values("Via"); !slices.Equal(res, wantRes) {
+			t.Errorf("via %v: response Via %q, want %q", on, res, wantRes)
+		}
+	}
+}
+
+func TestBackendHeader(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	addr := backend.Listener.Addr().String()
+	for _, name := range []S{"", "x-backend"} {
+		h, err := NewHandler(&Config{BackendHeader: name},
+			map[S]S{"url.test": backend.URL, "tcp.test": addr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, host := range []S{"url.test", "tcp.test"} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+				"https://"+host+"/", nil))
+			want := addr
+			if name == "" {
+				want = ""
+			}
+			if got := w.Header().Get("X-Backend"); got != want {
+				t.Errorf("%q %s: X-Backend %q, want %q", name, host, got, want)
+			}
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// a known length, which the proxy would otherwise buffer.
+			w.Header().Set("Content-Length", "10")
+			io.WriteString(w, "first")
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			io.WriteString(w, "later")
+		}))
+	defer backend.Close()
+	for _, tc := range []struct {
+		stream []S
+		early  bool
+	}{{nil, false}, {[]S{"live.test"}, true}} {
+		h, err := NewHandler(&Config{Stream: tc.stream},
+			map[S]S{"live.test": backend.URL})
+		if err != nil {
+			t.Fatal(err)
+		}
+		front := httptest.NewServer(h)
+		req, _ := http.NewRequest(http.MethodGet, front.URL, nil)
+		req.Host = "live.test"
+		got := make(chan S, 1)
+		go func() {
+			// without streaming, not even the headers arrive yet.
+			res, err := front.Client().Do(req)
+			if err != nil {
+				got <- err.Error()
+				return
+			}
+			defer res.Body.Close()
+			b := make([]byte, 5)
+			if _, err = io.ReadFull(res.Body, b); err != nil {
+				got <- err.Error()
+				return
+			}
+			got <- S(b)
+		}()
+		select {
+		case s := <-got:
+			if !tc.early || s != "first" {
+				t.Errorf("stream %v: read %q before the backend finished",
+					tc.stream, s)
+			}
+		case <-time.After(300 * time.Millisecond):
+			if tc.early {
+				t.Errorf("stream %v: first write not flushed", tc.stream)
+			}
+			front.CloseClientConnections()
+			<-got
+		}
+		front.Close()
+	}
+}
+
+func TestStripHeaders(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Clone()
+			w.Header().Set("X-Internal-Id", "42")
+			w.Header().Set("Server", "app/1.0")
+			w.Header().Set("X-Kept", "yes")
+			w.Header().Set("Trailer", "X-Internal-Trace")
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("X-Internal-Trace", "abc")
+		}))
+	defer backend.Close()
+	addr := backend.Listener.Addr().String()
+	h, err := NewHandler(&Config{
+		StripRequestHeaders: []S{"x-real-ip", " X-Debug "},
+		StripResponseHeaders: []S{"x-internal-id", "server",
+			"x-internal-trace"},
+	}, map[S]S{"url.test": backend.URL, "tcp.test": addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(h)
+	defer front.Close()
+	// both the Director of tcp backends and the Rewrite of url ones.
+	for _, host := range []S{"url.test", "tcp.test"} {
+		req, _ := http.NewRequest(http.MethodGet, front.URL, nil)
+		req.Host = host
+		req.Header.Set("X-Real-Ip", "203.0.113.1")
+		req.Header.Set("X-Debug", "1")
+		req.Header.Set("X-Kept", "yes")
+		res, err := front.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		for _, name := range []S{"X-Real-Ip", "X-Debug"} {
+			if v := got.Get(name); v != "" {
+				t.Errorf("%s: request %s %q reached the backend", host, name,
+					v)
+			}
+		}
+		if got.Get("X-Kept") != "yes" {
+			t.Errorf("%s: request X-Kept removed", host)
+		}
+		for _, name := range []S{"X-Internal-Id", "Server"} {
+			if v := res.Header.Get(name); v != "" {
+				t.Errorf("%s: response %s %q reached the client", host, name,
+					v)
+			}
+		}
+		if v := res.Trailer.Get("X-Internal-Trace"); v != "" {
+			t.Errorf("%s: trailer X-Internal-Trace %q reached the client",
+				host, v)
+		}
+		if res.Header.Get("X-Kept") != "yes" {
+			t.Errorf("%s: response X-Kept removed", host)
+		}
+	}
+}
+
+func TestAltSvc(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Alt-Svc", `h2=":8443"`)
+		}))
+	defer backend.Close()
+	altSvc := `h3=":443"; ma=86400`
+	h, err := NewHandler(&Config{AltSvc: []S{"h3.test:" + altSvc}},
+		map[S]S{"h3.test": backend.URL, "other.test": backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[S]S{"h3.test": altSvc,
+		"other.test": `h2=":8443"`} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+			"https://"+host+"/", nil))
+		if got := w.Header().Values("Alt-Svc"); len(got) != 1 ||
+			got[0] != want {
+			t.Errorf("%s: Alt-Svc %q, want %q", host, got, want)
+		}
+	}
+	if _, err = NewHandler(&Config{AltSvc: []S{"h3.test"}},
+		map[S]S{"h3.test": backend.URL}); err == nil {
+		t.Error("--alt-svc without a value accepted")
+	}
+}
+
+func TestRemapStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			status := http.StatusInternalServerError
+			if r.URL.Path == "/missing" {
+				status = http.StatusNotFound
+			}
+			w.Header().Set("X-Backend-Status", fmt.Sprint(status))
+			w.WriteHeader(status)
+			io.WriteString(w, "backend body")
+		}))
+	defer backend.Close()
+	h, err := NewHandler(&Config{RemapStatus: []S{"remap.test:500=503"}},
+		map[S]S{"remap.test": backend.URL, "other.test": backend.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		host, path S
+		want       int
+	}{
+		{"remap.test", "/", http.StatusServiceUnavailable},
+		{"remap.test", "/missing", http.StatusNotFound},
+		{"other.test", "/", http.StatusInternalServerError},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet,
+			"https://"+tc.host+tc.path, nil))
+		if w.Code != tc.want {
+			t.Errorf("%s%s: status %d, want %d", tc.host, tc.path, w.Code,
+				tc.want)
+		}
+		if body := w.Body.String(); body != "backend body" {
+			t.Errorf("%s%s: body %q", tc.host, tc.path, body)
+		}
+		if w.Header().Get("X-Backend-Status") == "" {
+			t.Errorf("%s%s: backend headers dropped", tc.host, tc.path)
+		}
+	}
+	if _, err = NewHandler(&Config{RemapStatus: []S{"remap.test:500"}},
+		map[S]S{"remap.test": backend.URL}); err == nil {
+		t.Error("--remap-status without a replacement accepted")
+	}
+}
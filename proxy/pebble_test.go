@@ -0,0 +1,159 @@
+//go:build pebble
+
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPebble obtains a certificate through the whole ACME issuance path from
+// a Pebble test CA, which must already be running, answering its http-01 and
+// tls-alpn-01 challenges itself. It is only built with the pebble tag:
+//
+//	pebble -config test/config/pebble-config.json -dnsserver 127.0.0.1:8053 &
+//	pebble-challtestsrv -defaultIPv4 127.0.0.1 -dnsserver 127.0.0.1:8053 \
+//	  -http01 "" -https01 "" -tlsalpn01 "" &
+//	PEBBLE_ROOT_CA=test/certs/pebble.minica.pem go test -tags pebble \
+//	  -run Pebble ./proxy/
+//
+// The environment variables PEBBLE_DIRECTORY, PEBBLE_MANAGEMENT, PEBBLE_HOST,
+// PEBBLE_HTTP and PEBBLE_TLS override the directory URL, the management
+// interface URL, the host issued for, and the addresses the challenges are
+// answered on, which default to those of Pebble's test configuration.
+func TestPebble(t *testing.T) {
+	rootCA := os.Getenv("PEBBLE_ROOT_CA")
+	if rootCA == "" {
+		t.Fatal("PEBBLE_ROOT_CA must be the path of pebble.minica.pem")
+	}
+	host := env("PEBBLE_HOST", "example.test")
+	mapping := filepath.Join(t.TempDir(), "mapping.txt")
+	if err := os.WriteFile(mapping, []byte(host+": 127.0.0.1:1\n"),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	cache := t.TempDir()
+	s, err := New(Config{
+		Mapping:       mapping,
+		Cache:         cache,
+		Email:         "ops@" + host,
+		ACMEDirectory: env("PEBBLE_DIRECTORY", "https://localhost:14000/dir"),
+		ACMERootCA:    rootCA,
+		DialTimeout:   time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.profiles.client.Transport = orderLocation{s.profiles.client.Transport}
+	httpLn, err := net.Listen("tcp", env("PEBBLE_HTTP", ":5002"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpLn.Close()
+	go http.Serve(httpLn, s.Challenge)
+	tlsLn, err := net.Listen("tcp", env("PEBBLE_TLS", ":5001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsLn.Close()
+	go http.Serve(tls.NewListener(tlsLn, s.TLSConfig), s)
+
+	var cert *tls.Certificate
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		cert, err = s.TLSConfig.GetCertificate(
+			&tls.ClientHelloInfo{ServerName: host})
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Minute):
+		t.Fatal("no certificate after 2 minutes")
+	}
+
+	// the root Pebble signs with changes on every start, so it is fetched
+	// from its management interface.
+	client, err := acmeHTTPClient(rootCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Get(env("PEBBLE_MANAGEMENT",
+		"https://localhost:15000") + "/roots/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	pem, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		t.Fatalf("no root certificate from Pebble: %s", pem)
+	}
+	intermediates := x509.NewCertPool()
+	var leaf *x509.Certificate
+	for i, der := range cert.Certificate {
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			leaf = c
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	if _, err = leaf.Verify(x509.VerifyOptions{DNSName: host,
+		Roots: roots, Intermediates: intermediates}); err != nil {
+		t.Fatal(err)
+	}
+	// the hello has no ECDSA support, so an RSA certificate is issued.
+	if _, err = os.Stat(filepath.Join(cache, host+"+rsa")); err != nil {
+		t.Errorf("certificate not cached: %v", err)
+	}
+}
+
+// orderLocation adds the Location header that Pebble leaves out of its
+// answer to finalizing an order, which it finalizes asynchronously, and
+// without which the acme package cannot wait for the order to be valid.
+type orderLocation struct {
+	http.RoundTripper
+}
+
+func (o orderLocation) RoundTrip(r *http.Request) (res *http.Response,
+	err E) {
+
+	if res, err = o.RoundTripper.RoundTrip(r); err != nil {
+		return
+	}
+	if id, ok := strings.CutPrefix(r.URL.Path,
+		"/finalize-order/"); ok && res.Header.Get("Location") == "" {
+
+		u := *r.URL
+		u.Path = "/my-order/" + id
+		res.Header.Set("Location", u.String())
+	}
+	return
+}
+
+// env returns the value of the environment variable name, or def if it is
+// not set.
+func env(name, def S) S {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
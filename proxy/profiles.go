@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Issuer obtains certificates on demand, as autocert.Manager does.
+type Issuer interface {
+	TLSConfig() *tls.Config
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// Profiles is a set of ACME accounts, each with its own autocert.Manager and
+// cache directory, that certificates are obtained with depending on the
+// host. Hosts not assigned to a profile use the Default manager.
+//
+// The default account is cached in the cache directory itself, and each
+// profile in a subdirectory named after it:
+//
+//	/var/cache/letsencrypt/acme_account+key
+//	/var/cache/letsencrypt/example.com
+//	/var/cache/letsencrypt/tenant1/acme_account+key
+//	/var/cache/letsencrypt/tenant1/tenant1.example.com
+type Profiles struct {
+	Default   *autocert.Manager
+	hosts     map[S]*autocert.Manager
+	handlers  map[*autocert.Manager]http.Handler
+	directory S
+	client    *http.Client
+}
+
+// checkEmail fails if the contact address of an ACME account, named by
+// what, is not a plain address, or if it is empty and required. The CA
+// warns of expiring certificates at this address, so a missing one is
+// warned about anyway.
+func checkEmail(what, email S, required bool) (err E) {
+	if email == "" {
+		if required {
+			return fmt.Errorf("no contact email for %s", what)
+		}
+		log.W.F("no contact email for %s, the CA cannot warn about "+
+			"certificates that fail to renew", what)
+		return
+	}
+	a, perr := mail.ParseAddress(email)
+	if perr != nil || a.Address != email || a.Name != "" {
+		return fmt.Errorf("invalid contact email %q for %s", email, what)
+	}
+	return
+}
+
+// checkEmails splits the comma separated contact addresses of an ACME
+// account and checks each like checkEmail.
+func checkEmails(what, list S, required bool) (emails []S, err E) {
+	for _, email := range strings.Split(list, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 {
+		return nil, checkEmail(what, "", required)
+	}
+	for _, email := range emails {
+		if err = checkEmail(what, email, required); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// newProfiles creates the managers for the configured profiles. A host is
+// only issued a certificate by the manager of its profile, and only if
+// allowed by policy.
+func newProfiles(c *Config, policy autocert.HostPolicy) (p *Profiles,
+	err error) {
+
+	p = &Profiles{hosts: make(map[S]*autocert.Manager)}
+	if p.client, err = acmeHTTPClient(c.ACMERootCA); chk.E(err) {
+		return
+	}
+	p.directory = c.ACMEDirectory
+	var emails []S
+	if emails, err = checkEmails("--email", c.Email,
+		c.RequireEmail); chk.E(err) {
+		return
+	}
+	if p.Default, err = p.manager(c.Cache, emails, policy); chk.E(err) {
+		return
+	}
+	named := make(map[S]*autocert.Manager)
+	for _, spec := range c.ACMEProfiles {
+		name, email, _ := strings.Cut(spec, ":")
+		if name == "" || name == "." || name == ".." ||
+			strings.ContainsRune(name, os.PathSeparator) {
+			err = fmt.Errorf("invalid ACME profile parameter: `%s`", spec)
+			return
+		}
+		if _, ok := named[name]; ok {
+			err = fmt.Errorf("duplicate ACME profile %q", name)
+			return
+		}
+		if emails, err = checkEmails("ACME profile "+name, email,
+			c.RequireEmail); chk.E(err) {
+			return
+		}
+		dir := filepath.Join(c.Cache, name)
+		if err = os.MkdirAll(dir, 0700); chk.E(err) {
+			return
+		}
+		if named[name], err = p.manager(dir, emails, policy); chk.E(err) {
+			return
+		}
+	}
+	for _, spec := range c.ACMEProfileHosts {
+		host, name, _ := strings.Cut(spec, ":")
+		m, ok := named[name]
+		if host == "" || !ok {
+			err = fmt.Errorf("invalid ACME profile host parameter: `%s`", spec)
+			return
+		}
+		p.hosts[strings.ToLower(host)] = m
+	}
+	return
+}
+
+// manager creates an autocert.Manager caching in dir that only accepts the
+// hosts assigned to it, with emails as the contacts of its account.
+func (p *Profiles) manager(dir S, emails []S,
+	policy autocert.HostPolicy) (m *autocert.Manager, err E) {
+
+	m = &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(dir),
+	}
+	if len(emails) > 0 {
+		m.Email = emails[0]
+	}
+	var several *contacts
+	if len(emails) > 1 {
+		several = &contacts{m: m, emails: emails}
+	}
+	if p.directory != "" || p.client != nil || several != nil {
+		// each manager registers its own account with its client.
+		m.Client = &acme.Client{
+			DirectoryURL: p.directory,
+			HTTPClient:   p.client,
+		}
+	}
+	if several != nil {
+		// set before the manager is used, as autocert sets it itself when it
+		// is nil.
+		if m.Client.Key, err = accountKey(context.Background(),
+			m.Cache); chk.E(err) {
+			return
+		}
+	}
+	m.HostPolicy = func(ctx context.Context, host S) (err error) {
+		if p.For(host) != m {
+			return fmt.Errorf("acme/autocert: host %q belongs to another "+
+				"ACME profile", host)
+		}
+		if err = policy(ctx, host); err != nil || several == nil {
+			return
+		}
+		// only called before ordering a certificate, so the account is
+		// registered when it is first needed.
+		return several.register(ctx)
+	}
+	return
+}
+
+// acmeHTTPClient returns a client for talking to the ACME CA that trusts the
+// root certificates in the PEM file at path, or nil if path is empty.
+func acmeHTTPClient(path S) (c *http.Client, err error) {
+	if path == "" {
+		return
+	}
+	var pem []byte
+	if pem, err = os.ReadFile(path); chk.E(err) {
+		return
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		err = fmt.Errorf("no certificates found in ACME root CA file %s", path)
+		return
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: roots}
+	c = &http.Client{Transport: t}
+	return
+}
+
+// For returns the manager that issues certificates for host.
+func (p *Profiles) For(host S) (m *autocert.Manager) {
+	var ok bool
+	if m, ok = p.hosts[strings.ToLower(host)]; ok {
+		return
+	}
+	return p.Default
+}
+
+// TLSConfig returns the tls.Config of the default manager; its GetCertificate
+// must be replaced with the one of p to dispatch to the right manager.
+func (p *Profiles) TLSConfig() *tls.Config { return p.Default.TLSConfig() }
+
+// GetCertificate obtains the certificate for the SNI of hello from the
+// manager of its profile.
+func (p *Profiles) GetCertificate(hello *tls.ClientHelloInfo) (
+	*tls.Certificate, error) {
+
+	return p.For(hello.ServerName).GetCertificate(hello)
+}
+
+// HTTPHandler answers http-01 challenges with the manager of the requested
+// host's profile, passing other requests to fallback as
+// autocert.Manager.HTTPHandler does.
+func (p *Profiles) HTTPHandler(fallback http.Handler) http.Handler {
+	p.handlers = map[*autocert.Manager]http.Handler{
+		p.Default: p.Default.HTTPHandler(fallback),
+	}
+	for _, m := range p.hosts {
+		if _, ok := p.handlers[m]; !ok {
+			p.handlers[m] = m.HTTPHandler(fallback)
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		p.handlers[p.For(host)].ServeHTTP(w, r)
+	})
+}
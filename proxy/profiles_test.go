@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckEmail(t *testing.T) {
+	for _, tc := range []struct {
+		email    S
+		required bool
+		ok       bool
+	}{
+		{"admin@example.com", false, true},
+		{"admin@example.com", true, true},
+		{"", false, true},
+		{"", true, false},
+		{"admin", false, false},
+		{"admin@", false, false},
+		{"Admin <admin@example.com>", false, false},
+		{" admin@example.com", false, false},
+		{"a@b@example.com", false, false},
+	} {
+		if err := checkEmail("--email", tc.email, tc.required); (err ==
+			nil) != tc.ok {
+			t.Errorf("checkEmail(%q, %v) = %v", tc.email, tc.required, err)
+		}
+	}
+}
+
+func TestNewProfilesEmail(t *testing.T) {
+	allow := func(context.Context, S) E { return nil }
+	for _, tc := range []struct {
+		name S
+		c    Config
+		ok   bool
+	}{
+		{"valid", Config{Email: "admin@example.com",
+			ACMEProfiles: []S{"staging:ops@example.com"}}, true},
+		{"missing warned", Config{}, true},
+		{"missing required", Config{RequireEmail: true}, false},
+		{"invalid", Config{Email: "admin"}, false},
+		{"invalid profile", Config{Email: "admin@example.com",
+			ACMEProfiles: []S{"staging:ops"}}, false},
+		{"missing profile required", Config{Email: "admin@example.com",
+			RequireEmail: true, ACMEProfiles: []S{"staging:"}}, false},
+	} {
+		tc.c.Cache = t.TempDir()
+		if _, err := newProfiles(&tc.c, allow); (err == nil) != tc.ok {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lerproxy.mleku.dev/hostpolicy"
+)
+
+// pruneMinAge is how old a cache file must be to be pruned, so that one
+// autocert is writing is left alone.
+const pruneMinAge = time.Minute
+
+// PruneCache removes the certificates cached for hosts that are not in the
+// mapping, such as hosts removed from it, from the cache directory and those
+// of the ACME profiles. Account keys, pending challenges and files that are
+// not certificates named after a host are kept. With dryRun nothing is
+// removed. The files removed, or that would be, are returned.
+func (c *Config) PruneCache(mapping map[S]S, dryRun bool) (pruned []S,
+	err E) {
+
+	if c.AllowAnyHost {
+		err = fmt.Errorf("not pruning the cache, any host may have a " +
+			"certificate with AllowAnyHost")
+		return
+	}
+	if len(mapping) == 0 {
+		err = fmt.Errorf("not pruning the cache, the mapping has no hosts")
+		return
+	}
+	mapped := hostpolicy.New(Hosts(mapping)...)
+	dirs := []S{c.Cache}
+	for _, spec := range c.ACMEProfiles {
+		name, _, _ := strings.Cut(spec, ":")
+		dirs = append(dirs, filepath.Join(c.Cache, name))
+	}
+	for _, dir := range dirs {
+		var entries []os.DirEntry
+		if entries, err = os.ReadDir(dir); err != nil {
+			if os.IsNotExist(err) {
+				err = nil
+				continue
+			}
+			chk.E(err)
+			return
+		}
+		for _, e := range entries {
+			host, ok := cachedHost(e.Name())
+			if !ok || !e.Type().IsRegular() || mapped.Contains(host) {
+				continue
+			}
+			var fi os.FileInfo
+			if fi, err = e.Info(); err != nil {
+				err = nil
+				continue
+			}
+			file := filepath.Join(dir, e.Name())
+			if time.Since(fi.ModTime()) < pruneMinAge || !isCert(file) {
+				continue
+			}
+			pruned = append(pruned, file)
+			if dryRun {
+				log.I.F("would remove %s, %s is not mapped", file, host)
+				continue
+			}
+			if err = os.Remove(file); chk.E(err) {
+				return
+			}
+			log.I.F("removed %s, %s is not mapped", file, host)
+		}
+	}
+	return
+}
+
+// cachedHost returns the host of the certificate autocert caches under name,
+// which is the host, followed by +rsa for an RSA certificate or +token for a
+// tls-alpn-01 challenge certificate. The host is checked as those of the
+// mapping are, and must have a dot, unlike the names of account keys.
+func cachedHost(name S) (host S, ok bool) {
+	host = strings.TrimSuffix(strings.TrimSuffix(name, "+rsa"), "+token")
+	ok = strings.Contains(host, ".") && validHost(host)
+	return
+}
+
+// isCert reports whether file holds a certificate as autocert caches them,
+// PEM encoded after its private key.
+func isCert(file S) bool {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	for {
+		var block *pem.Block
+		if block, b = pem.Decode(b); block == nil {
+			return false
+		}
+		if block.Type == "CERTIFICATE" {
+			return true
+		}
+	}
+}
+
+// PruneCache removes the certificates cached for hosts that are not in the
+// current mapping, as Config.PruneCache does.
+func (s *Server) PruneCache(dryRun bool) (pruned []S, err E) {
+	return s.config.PruneCache(*s.mapping.Load(), dryRun)
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeCached writes a file to the cache directory dir as old as age, a
+// certificate after its key as autocert caches them if cert is set.
+func writeCached(t *testing.T, dir, name S, cert bool, age time.Duration) {
+	t.Helper()
+	b := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: B("k")})
+	if cert {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+			Bytes: B("c")})...)
+	}
+	file := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(file, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPruneCache(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Hour
+	writeCached(t, dir, "mapped.com", true, old)
+	writeCached(t, dir, "mapped.com+rsa", true, old)
+	writeCached(t, dir, "gone.com", true, old)
+	writeCached(t, dir, "gone.com+rsa", true, old)
+	writeCached(t, dir, "gone.com+token", true, old)
+	writeCached(t, dir, "_dmarc.gone.com", true, old)
+	writeCached(t, dir, "fresh.com", true, 0)
+	writeCached(t, dir, "notcert.com", false, old)
+	writeCached(t, dir, accountKeyName, false, old)
+	writeCached(t, dir, "abc+http-01", false, old)
+	writeCached(t, dir, "staging/gone.com", true, old)
+	writeCached(t, dir, "staging/mapped.com", true, old)
+	if err := os.Mkdir(filepath.Join(dir, "dir.com"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	c := &Config{Cache: dir, ACMEProfiles: []S{"staging:ops@example.com",
+		"missing:ops@example.com"}}
+	mapping := map[S]S{"mapped.com": "127.0.0.1:8080"}
+	want := []S{"_dmarc.gone.com", "gone.com", "gone.com+rsa",
+		"gone.com+token", "staging/gone.com"}
+	for i := range want {
+		want[i] = filepath.Join(dir, want[i])
+	}
+	pruned, err := c.PruneCache(mapping, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(pruned)
+	if !slices.Equal(pruned, want) {
+		t.Fatalf("dry run pruned %q, want %q", pruned, want)
+	}
+	for _, file := range want {
+		if _, err = os.Stat(file); err != nil {
+			t.Errorf("dry run removed %s", file)
+		}
+	}
+	if pruned, err = c.PruneCache(mapping, false); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(pruned)
+	if !slices.Equal(pruned, want) {
+		t.Fatalf("pruned %q, want %q", pruned, want)
+	}
+	for _, file := range want {
+		if _, err = os.Stat(file); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", file)
+		}
+	}
+	for _, name := range []S{"mapped.com", "mapped.com+rsa", "fresh.com",
+		"notcert.com", accountKeyName, "abc+http-01", "staging/mapped.com",
+		"dir.com"} {
+		if _, err = os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s removed", name)
+		}
+	}
+}
+
+func TestPruneCacheRefused(t *testing.T) {
+	dir := t.TempDir()
+	writeCached(t, dir, "gone.com", true, time.Hour)
+	for _, tc := range []struct {
+		name    S
+		c       Config
+		mapping map[S]S
+	}{
+		{"any host", Config{Cache: dir, AllowAnyHost: true},
+			map[S]S{"mapped.com": "127.0.0.1:8080"}},
+		{"empty mapping", Config{Cache: dir}, map[S]S{}},
+	} {
+		if _, err := tc.c.PruneCache(tc.mapping, false); err == nil {
+			t.Errorf("%s: pruned", tc.name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gone.com")); err != nil {
+		t.Error("certificate removed")
+	}
+}
+
+func TestCachedHost(t *testing.T) {
+	for _, tc := range []struct {
+		name S
+		host S
+		ok   bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com+rsa", "example.com", true},
+		{"example.com+token", "example.com", true},
+		{"_acme.example.com", "_acme.example.com", true},
+		{"my_host.example.com", "my_host.example.com", true},
+		{accountKeyName, "", false},
+		{"abc+http-01", "", false},
+		{"localhost", "", false},
+		{".example.com", "", false},
+		{"example..com", "", false},
+		{"example.com.tmp 1", "", false},
+	} {
+		host, ok := cachedHost(tc.name)
+		if ok != tc.ok || (ok && host != tc.host) {
+			t.Errorf("cachedHost(%q) = %q, %v", tc.name, host, ok)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteTimeout bounds fetching a mapping from a URL.
+const remoteTimeout = 30 * time.Second
+
+// IsURL reports whether the mapping is an http or https URL to fetch rather
+// than a file.
+func IsURL(mapping S) bool {
+	return strings.HasPrefix(mapping, "http://") ||
+		strings.HasPrefix(mapping, "https://")
+}
+
+// remoteMapping fetches a mapping served over http, remembering the ETag and
+// Last-Modified of the one in effect so that it is only fetched again, and
+// the handler rebuilt, when it has changed.
+type remoteMapping struct {
+	url S
+
+	mx                 sync.Mutex
+	etag, lastModified S
+}
+
+// fetch gets the mapping, or nil if it has not changed since the last one
+// applied. The mapping is applied, so that later fetches are conditional on
+// it having changed, by calling apply once it is in effect.
+func (r *remoteMapping) fetch(ctx context.Context) (m map[S]S, apply func(),
+	err E) {
+
+	apply = func() {}
+	ctx, cancel := context.WithTimeout(ctx, remoteTimeout)
+	defer cancel()
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, r.url,
+		nil); chk.E(err) {
+
+		return
+	}
+	r.mx.Lock()
+	if r.etag != "" {
+		req.Header.Set("If-None-Match", r.etag)
+	}
+	if r.lastModified != "" {
+		req.Header.Set("If-Modified-Since", r.lastModified)
+	}
+	r.mx.Unlock()
+	var res *http.Response
+	if res, err = http.DefaultClient.Do(req); chk.E(err) {
+		return
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return
+	default:
+		err = fmt.Errorf("fetching mapping from %s: %s", r.url, res.Status)
+		log.E.Ln(err)
+		return
+	}
+	if m, err = parseMapping(res.Body, r.url); err != nil {
+		return
+	}
+	etag, lastModified := res.Header.Get("ETag"),
+		res.Header.Get("Last-Modified")
+	apply = func() {
+		r.mx.Lock()
+		r.etag, r.lastModified = etag, lastModified
+		r.mx.Unlock()
+	}
+	return
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ParseRoute splits the host side of a mapping line into the host and the
+// ServeMux pattern requests for it are routed with.
+//
+// Besides a plain hostname, which routes every request for the host, it
+// accepts the Go 1.22 ServeMux pattern syntax with an optional method and a
+// path with wildcards:
+//
+//	example.com                  example.com/
+//	example.com/api/             example.com/api/
+//	GET example.com/items/{id}   GET example.com/items/{id}
+//
+// The host may be a wildcard such as *.example.com, matching all names
+// ending in .example.com that are not mapped themselves.
+func ParseRoute(key S) (host, method, path S, err E) {
+	rest := key
+	if m, r, ok := strings.Cut(key, " "); ok {
+		method, rest = m, strings.TrimSpace(r)
+	}
+	host, path, _ = strings.Cut(rest, "/")
+	// DNS names are case insensitive, the router compares them in lower
+	// case.
+	host = strings.ToLower(host)
+	path = "/" + path
+	if !validHost(host) {
+		err = fmt.Errorf("invalid hostname %q in route %q", host, key)
+	}
+	return
+}
+
+// validHost reports whether host only has the letters, digits, hyphens,
+// underscores and dots of DNS names, with no empty labels, or is such a name
+// preceded by *. for a wildcard.
+func validHost(host S) bool {
+	host = strings.TrimPrefix(host, "*.")
+	if host == "" || len(host) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, c := range label {
+			switch {
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z',
+				c >= '0' && c <= '9', c == '-', c == '_':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// pattern builds a ServeMux pattern from its parts.
+func pattern(host, method, path S) (p S) {
+	p = host + path
+	if method != "" {
+		p = method + " " + p
+	}
+	return
+}
+
+// handle registers h for pattern, returning an error rather than panicking
+// if the pattern is invalid or conflicts with another.
+func handle(mux *http.ServeMux, pattern S, h http.Handler) (err E) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("cannot route %q: %v", pattern, r)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return
+}
+
+// Hosts returns the hostnames routed by the mapping in sorted order.
+func Hosts(mapping map[S]S) (hosts []S) {
+	seen := make(map[S]bool, len(mapping))
+	for key := range mapping {
+		host, _, _, err := ParseRoute(key)
+		if err != nil || seen[host] {
+			continue
+		}
+		seen[host] = true
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRoute(t *testing.T) {
+	for _, tc := range []struct {
+		key, host, method, path S
+	}{
+		{"example.com", "example.com", "", "/"},
+		{"Example.COM", "example.com", "", "/"},
+		{"example.com/api/", "example.com", "", "/api/"},
+		{"GET example.com/items/{id}", "example.com", "GET", "/items/{id}"},
+		{"*.example.com", "*.example.com", "", "/"},
+		{"under_score.example.com", "under_score.example.com", "", "/"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example", "", "/"},
+	} {
+		host, method, path, err := ParseRoute(tc.key)
+		if err != nil || host != tc.host || method != tc.method ||
+			path != tc.path {
+			t.Errorf("ParseRoute(%q) = %q, %q, %q, %v", tc.key, host, method,
+				path, err)
+		}
+	}
+}
+
+func TestParseRouteInvalidHost(t *testing.T) {
+	for _, key := range []S{
+		"",
+		"/var/www",
+		"GET /api/",
+		"ex@mple.com",
+		"example.com\\api",
+		"exa%mple.com",
+		"example..com",
+		".example.com",
+		"example.com.",
+		"exämple.com",
+		"*example.com",
+		"foo.*.example.com",
+		strings.Repeat("a", 64) + ".com",
+	} {
+		if host, _, _, err := ParseRoute(key); err == nil {
+			t.Errorf("ParseRoute(%q) accepted host %q", key, host)
+		}
+	}
+}
+
+// TestNewHandlerSkipsInvalidHost checks that a mapping line with an invalid
+// hostname does not stop the other hosts from being served.
+func TestNewHandlerSkipsInvalidHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+	defer backend.Close()
+	h, err := NewHandler(&Config{}, map[S]S{
+		"ex@mple.com":  backend.URL,
+		"/var/www":     backend.URL,
+		"example.com":  backend.URL,
+		"example..org": backend.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "ok" {
+		t.Errorf("valid host got %d %q", w.Code, w.Body)
+	}
+	if hosts := Hosts(map[S]S{"ex@mple.com": "x", "example.com": "y"}); len(
+		hosts) != 1 || hosts[0] != "example.com" {
+		t.Errorf("Hosts = %q", hosts)
+	}
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+
+	"lerproxy.mleku.dev/util"
+)
+
+// WriteRoutes writes a line for each entry of the mapping, sorted, with the
+// route, the kind of backend it resolves to and its target separated by
+// tabs. Entries that would be skipped are written with the kind "invalid"
+// and the reason.
+func WriteRoutes(w io.Writer, mapping map[S]S) (err E) {
+	for _, key := range util.GetKeys(mapping) {
+		kind, target := "invalid", mapping[key]
+		if _, _, _, perr := ParseRoute(key); perr != nil {
+			target = perr.Error()
+		} else {
+			b := ParseBackend(mapping[key])
+			kind, target = b.Kind.String(), b.Target()
+		}
+		if _, err = fmt.Fprintf(w, "%s\t%s\t%s\n", key, kind,
+			target); err != nil {
+			return
+		}
+	}
+	return
+}
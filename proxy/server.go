@@ -0,0 +1,247 @@
+// Package proxy builds the https reverse proxy from a host mapping, with
+// certificates obtained automatically from LetsEncrypt or loaded from disk.
+//
+// It is the core of the lerproxy command, usable from other programs:
+//
+//	s, err := proxy.New(proxy.Config{Mapping: "mapping.txt", Cache: dir})
+//	srv := &http.Server{Handler: s, TLSConfig: s.TLSConfig}
+//	go http.ListenAndServe(":http", s.Challenge)
+//	srv.ListenAndServeTLS("", "")
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/acme/autocert"
+	"lerproxy.mleku.dev/acmeretry"
+	"lerproxy.mleku.dev/bluegreen"
+	"lerproxy.mleku.dev/hostpolicy"
+	"lerproxy.mleku.dev/hsts"
+	"lerproxy.mleku.dev/mtls"
+	"lerproxy.mleku.dev/redirect"
+	"lerproxy.mleku.dev/router"
+	"lerproxy.mleku.dev/swap"
+)
+
+// Server is the proxy handler along with the certificate management for the
+// hosts it serves. Its handler and set of hosts can be replaced by Reload
+// while it is serving.
+type Server struct {
+	*swap.Handler
+	// TLSConfig serves the certificates for the mapped hosts.
+	TLSConfig *tls.Config
+	// Challenge answers ACME http-01 challenges and redirects other plain
+	// http requests to https.
+	Challenge http.Handler
+	// Manager obtains and renews the LetsEncrypt certificates of hosts that
+	// are not assigned to another ACME profile.
+	Manager   *autocert.Manager
+	profiles  *Profiles
+	whitelist *hostpolicy.Whitelist
+	mapping   atomic.Pointer[map[S]S]
+	config    Config
+	// reload serializes reloads, so that an older mapping is never stored
+	// after a newer one.
+	reload sync.Mutex
+}
+
+// New reads the mapping and creates a Server for it.
+func New(c Config) (s *Server, err error) {
+	if c.clientCAs, err = mtls.Load(c.ClientCAs); chk.E(err) {
+		return
+	}
+	if c.clientCertHeaders, err = mtls.ParseHeaders(
+		c.ClientCertHeaders); chk.E(err) {
+
+		return
+	}
+	// the splits outlive reloads, so that a switch stays in effect.
+	c.splits = &bluegreen.Splits{}
+	if IsURL(c.Mapping) {
+		c.remote = &remoteMapping{url: c.Mapping}
+	}
+	var mapping map[S]S
+	var apply func()
+	if mapping, apply, err = c.readMapping(); chk.E(err) {
+		return
+	}
+	var h http.Handler
+	if h, err = c.build(mapping); chk.E(err) {
+		return
+	}
+	apply()
+	if c.CheckBackends || c.RequireBackends {
+		failed := CheckBackends(context.Background(), mapping, c.DialTimeout)
+		if len(failed) > 0 && c.RequireBackends {
+			err = fmt.Errorf("backends not available for %s",
+				strings.Join(failed, ", "))
+			return
+		}
+	}
+	if err = os.MkdirAll(c.Cache, 0700); chk.E(err) {
+		err = fmt.Errorf("cannot create cache directory %q: %v",
+			c.Cache, err)
+		chk.E(err)
+		return
+	}
+	s = &Server{
+		Handler:   swap.New(h),
+		whitelist: hostpolicy.New(Hosts(mapping)...),
+		config:    c,
+	}
+	s.mapping.Store(&mapping)
+	certs := LoadCerts(c.Certs...)
+	hostPolicy := s.whitelist.Policy
+	if c.AllowAnyHost {
+		log.W.Ln("issuing certificates for ANY host that a client asks " +
+			"for, each one counts against the rate limits of the ACME CA")
+		hostPolicy = (&hostpolicy.Any{
+			Deny: hostpolicy.New(c.DenyHosts...),
+		}).Policy
+	}
+	// hosts with a certificate of their own never need one from the CA,
+	// so orders for them would only use up the rate limits.
+	policy := func(ctx context.Context, host S) (err E) {
+		if certs.For(host) != nil {
+			return fmt.Errorf("acme/autocert: host %q has a static "+
+				"certificate", host)
+		}
+		return hostPolicy(ctx, host)
+	}
+	if s.profiles, err = newProfiles(&c, policy); chk.E(err) {
+		return
+	}
+	s.Manager = s.profiles.Default
+	retrier := &acmeretry.Retrier{
+		Issuer:      s.profiles,
+		Timeout:     c.ACMERetry,
+		NegativeTTL: c.ACMENegativeTTL,
+	}
+	s.TLSConfig = TLSConfig(retrier, certs)
+	if len(c.ALPN) > 0 {
+		if s.TLSConfig.NextProtos, err = NextProtos(c.ALPN); chk.E(err) {
+			return
+		}
+	}
+	mtls.Configure(s.TLSConfig, c.clientCAs)
+	s.Challenge = s.profiles.HTTPHandler(&redirect.Handler{
+		Status: c.RedirectStatus,
+		HSTS:   c.HSTS,
+		Port:   c.RedirectPort,
+		// while a certificate is obtained, the redirect would only lead to
+		// a stalled handshake.
+		Pending:    retrier.Issuing,
+		RetryAfter: c.IssuingRetryAfter,
+	})
+	return
+}
+
+// Hosts returns the mapped hosts in sorted order.
+func (s *Server) Hosts() []S { return s.whitelist.Hosts() }
+
+// ProbeBackends checks the backends of the current mapping, counting those
+// that are available and not.
+func (s *Server) ProbeBackends(ctx context.Context) (healthy, unhealthy int) {
+	return ProbeBackends(ctx, *s.mapping.Load(), s.config.DialTimeout)
+}
+
+// Switch sends percent of the requests for host to the group to, and the
+// rest to the other one. The host must have a green backend.
+func (s *Server) Switch(host S, to bluegreen.Group, percent int) (err E) {
+	host = strings.ToLower(host)
+	var green map[S]S
+	if green, err = s.config.green(); chk.E(err) {
+		return
+	}
+	if _, ok := green[host]; !ok {
+		err = fmt.Errorf("%s has no green backend", host)
+		return
+	}
+	if err = s.config.splits.For(host).Set(to, percent); err != nil {
+		return
+	}
+	log.I.F("sending %d%% of the requests for %s to %s", percent, host, to)
+	return
+}
+
+// Reload re-reads the mapping, swapping in the new proxy handler and the set
+// of hosts allowed to obtain certificates. On error the previous
+// configuration stays in effect. A mapping fetched from a URL that the
+// server answers as not modified is left as it is.
+//
+// Only what build constructs is replaced: the autocert managers, with the
+// certificates they hold in memory, and the cache directory are created once
+// by New and kept, so a reload does not cause certificates to be issued
+// again. Reloads run one at a time, such as those of a signal and of the
+// admin server at once.
+func (s *Server) Reload() (err error) {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+	var mapping map[S]S
+	var apply func()
+	if mapping, apply, err = s.config.readMapping(); chk.E(err) {
+		return
+	}
+	if mapping == nil {
+		log.I.Ln("mapping at", s.config.Mapping, "is not modified")
+		return
+	}
+	var h http.Handler
+	if h, err = s.config.build(mapping); chk.E(err) {
+		return
+	}
+	apply()
+	hosts := Hosts(mapping)
+	s.whitelist.Set(hosts...)
+	s.mapping.Store(&mapping)
+	s.Handler.Store(h)
+	log.I.Ln("reloaded mapping with", len(hosts), "hosts")
+	return
+}
+
+// readMapping reads the mapping, or returns nil if it is fetched from a URL
+// and has not changed since apply was last called.
+func (c *Config) readMapping() (mapping map[S]S, apply func(), err E) {
+	if c.remote != nil {
+		return c.remote.fetch(context.Background())
+	}
+	apply = func() {}
+	if mapping, err = ReadMapping(c.Mapping); err != nil {
+		if !c.AllowEmptyMapping || !errors.Is(err, fs.ErrNotExist) {
+			chk.E(err)
+			return
+		}
+		log.W.F("mapping file %s does not exist, starting with no hosts",
+			c.Mapping)
+		mapping, err = make(map[S]S), nil
+	}
+	return
+}
+
+// build constructs the proxy handler for the mapping.
+func (c *Config) build(mapping map[S]S) (h http.Handler, err error) {
+	if h, err = NewHandler(c, mapping); chk.E(err) {
+		return
+	}
+	if c.DefaultHost != "" || c.RejectEmptyHost {
+		h = &router.EmptyHost{Handler: h, Host: c.DefaultHost,
+			Reject: c.RejectEmptyHost}
+	}
+	if len(c.clientCAs) > 0 {
+		h = &mtls.Handler{Handler: h, Pools: c.clientCAs,
+			Headers: c.clientCertHeaders}
+	}
+	if c.HSTS {
+		h = &hsts.Proxy{Handler: h}
+	}
+	return
+}
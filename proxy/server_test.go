@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAllowAnyHost(t *testing.T) {
+	s, err := New(Config{
+		Mapping:      writeMapping(t, "mapped.com: 127.0.0.1:1\n"),
+		Cache:        filepath.Join(t.TempDir(), "cache"),
+		AllowAnyHost: true,
+		DenyHosts:    []S{"denied.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		host S
+		ok   bool
+	}{
+		{"mapped.com", true},
+		{"unmapped.com", true},
+		{"denied.com", false},
+		{"192.0.2.1", false},
+		{"app.local", false},
+	} {
+		if err = s.Manager.HostPolicy(context.Background(),
+			tc.host); (err == nil) != tc.ok {
+			t.Errorf("HostPolicy(%q) = %v", tc.host, err)
+		}
+	}
+}
+
+func TestALPN(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		alpn []S
+		want S
+	}{
+		{nil, "h2"},
+		{[]S{"http/1.1", "h2"}, "http/1.1"},
+		{[]S{"http/1.1"}, "http/1.1"},
+	} {
+		s, err := New(Config{
+			Mapping: writeMapping(t, "mapped.com: 127.0.0.1:1\n"),
+			Cache:   filepath.Join(dir, "cache"),
+			Certs:   []S{"mapped.com:" + writeCert(t, dir, "mapped.com")},
+			ALPN:    tc.alpn,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, sc := net.Pipe()
+		go func() {
+			defer sc.Close()
+			tls.Server(sc, s.TLSConfig).Handshake()
+		}()
+		client := tls.Client(c, &tls.Config{ServerName: "mapped.com",
+			InsecureSkipVerify: true, NextProtos: []S{"h2", "http/1.1"}})
+		if err = client.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		if got := client.ConnectionState().NegotiatedProtocol; got != tc.want {
+			t.Errorf("--alpn %q negotiated %q, want %q", tc.alpn, got, tc.want)
+		}
+		client.Close()
+	}
+	if _, err := New(Config{
+		Mapping: writeMapping(t, "mapped.com: 127.0.0.1:1\n"),
+		Cache:   filepath.Join(dir, "cache"),
+		ALPN:    []S{"h3"},
+	}); err == nil {
+		t.Error("unsupported ALPN protocol accepted")
+	}
+}
+
+// TestConcurrentReload reloads a mapping served over http from several
+// goroutines while it changes, after which the mapping in effect must be the
+// last one, and the one of the ETag remembered, or later reloads would be
+// answered as not modified and keep an older one.
+func TestConcurrentReload(t *testing.T) {
+	var version atomic.Int32
+	remote := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			v := version.Load()
+			etag := fmt.Sprintf(`"%d"`, v)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			fmt.Fprintf(w, "v%d.test: 127.0.0.1:1\n", v)
+		}))
+	defer remote.Close()
+	s, err := New(Config{Mapping: remote.URL,
+		Cache: filepath.Join(t.TempDir(), "cache")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const last = 20
+	var changed atomic.Bool
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// some reloads start after the last change.
+			for extra := 3; extra > 0; {
+				if changed.Load() {
+					extra--
+				}
+				if err := s.Reload(); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	for i := 1; i <= last; i++ {
+		version.Store(int32(i))
+		time.Sleep(time.Millisecond)
+	}
+	changed.Store(true)
+	wg.Wait()
+	want := fmt.Sprintf("v%d.test", last)
+	if _, ok := (*s.mapping.Load())[want]; !ok ||
+		s.config.remote.etag != fmt.Sprintf(`"%d"`, last) {
+		t.Errorf("mapping %v in effect with ETag %s, want %s",
+			*s.mapping.Load(), s.config.remote.etag, want)
+	}
+	if err = s.Manager.HostPolicy(context.Background(), want); err != nil {
+		t.Errorf("%s not allowed: %v", want, err)
+	}
+}
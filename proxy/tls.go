@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+)
+
+// StaticCerts are certificates from providers other than LetsEncrypt, by the
+// domain they are used for.
+type StaticCerts map[S]*tls.Certificate
+
+// LoadCerts loads the certificates given in the form
+// "example.com:/path/to/cert", from the files with the extensions .crt and
+// .key. Certificates that cannot be loaded are logged and skipped.
+func LoadCerts(certs ...string) (sc StaticCerts) {
+	sc = make(StaticCerts)
+	for _, cert := range certs {
+		split := strings.Split(cert, ":")
+		if len(split) != 2 {
+			log.E.F("invalid certificate parameter format: `%s`", cert)
+			continue
+		}
+		var err E
+		var c tls.Certificate
+		if c, err = tls.LoadX509KeyPair(split[1]+".crt", split[1]+".key"); chk.E(err) {
+			continue
+		}
+		sc[strings.ToLower(split[0])] = &c
+	}
+	return
+}
+
+// For returns the certificate for the server name, or nil if there is none.
+func (sc StaticCerts) For(serverName S) (cert *tls.Certificate) {
+	name := strings.ToLower(serverName)
+	// to also handle explicit subdomain certs, prioritize over a root wildcard.
+	if cert = sc[name]; cert != nil {
+		return
+	}
+	var longest S
+	for i, c := range sc {
+		// if it got to us and ends in the same name dot tld assume the subdomain was
+		// redirected or it's a wildcard certificate, thus only the ending needs to match,
+		// on a label boundary so that notexample.com is not taken for example.com.
+		if strings.HasSuffix(name, "."+i) && len(i) > len(longest) {
+			cert, longest = c, i
+		}
+	}
+	return
+}
+
+// NextProtos returns the ALPN protocols of order, h2 and http/1.1 in order
+// of preference, followed by acme-tls/1, which the CA needs to validate
+// tls-alpn-01 challenges.
+func NextProtos(order []S) (protos []S, err E) {
+	for _, p := range order {
+		switch p = strings.ToLower(strings.TrimSpace(p)); p {
+		case "":
+			continue
+		case "h2", "http/1.1":
+		case acme.ALPNProto:
+			// kept last regardless.
+			continue
+		default:
+			err = fmt.Errorf("unsupported ALPN protocol %q, want h2 or "+
+				"http/1.1", p)
+			return
+		}
+		if !slices.Contains(protos, p) {
+			protos = append(protos, p)
+		}
+	}
+	if len(protos) == 0 {
+		err = fmt.Errorf("no ALPN protocols in %q", strings.Join(order, ","))
+		return
+	}
+	protos = append(protos, acme.ALPNProto)
+	return
+}
+
+// TLSConfig returns a TLSConfig that works with a LetsEncrypt automatic SSL cert issuer as well
+// as any provided .pem certificates from providers, which are preferred.
+func TLSConfig(m Issuer, certs StaticCerts) (tc *tls.Config) {
+	tc = m.TLSConfig()
+	tc.GetCertificate = func(helo *tls.ClientHelloInfo) (cert *tls.Certificate, err E) {
+		if cert = certs.For(helo.ServerName); cert != nil {
+			return
+		}
+		return m.GetCertificate(helo)
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for name to dir as name.crt
+// and name.key, returning the path they share without the extension.
+func writeCert(t *testing.T, dir, name S) (base S) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []S{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey,
+		key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base = filepath.Join(dir, name)
+	for ext, block := range map[S]*pem.Block{
+		".crt": {Type: "CERTIFICATE", Bytes: der},
+		".key": {Type: "EC PRIVATE KEY", Bytes: kb},
+	} {
+		if err = os.WriteFile(base+ext, pem.EncodeToMemory(block),
+			0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return
+}
+
+// issuer is an Issuer that records the server names it was asked for.
+type issuer struct {
+	names []S
+	cert  *tls.Certificate
+}
+
+func (i *issuer) TLSConfig() *tls.Config { return &tls.Config{} }
+
+func (i *issuer) GetCertificate(hello *tls.ClientHelloInfo) (
+	*tls.Certificate, error) {
+
+	i.names = append(i.names, hello.ServerName)
+	return i.cert, nil
+}
+
+func TestStaticCerts(t *testing.T) {
+	dir := t.TempDir()
+	certs := LoadCerts("Static.test:"+writeCert(t, dir, "static.test"),
+		"sub.wild.test:"+writeCert(t, dir, "sub.wild.test"),
+		"wild.test:"+writeCert(t, dir, "wild.test"),
+		"broken.test:"+filepath.Join(dir, "missing"), "nocolon")
+	if len(certs) != 3 {
+		t.Fatalf("loaded %d certificates, want 3", len(certs))
+	}
+	acme := &issuer{cert: &tls.Certificate{}}
+	cfg := TLSConfig(acme, certs)
+	for _, tc := range []struct {
+		name, cert S
+	}{
+		{"static.test", "static.test"},
+		{"STATIC.test", "static.test"},
+		{"sub.wild.test", "sub.wild.test"},
+		{"other.wild.test", "wild.test"},
+		{"deep.sub.wild.test", "sub.wild.test"},
+		{"notwild.test", ""},
+		{"acme.test", ""},
+	} {
+		cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{
+			ServerName: tc.name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.cert == "" {
+			if cert != acme.cert {
+				t.Errorf("%s: not issued by ACME", tc.name)
+			}
+			continue
+		}
+		if cert != certs[tc.cert] {
+			t.Errorf("%s: not served the certificate of %s", tc.name,
+				tc.cert)
+		}
+	}
+	if !slices.Equal(acme.names, []S{"notwild.test", "acme.test"}) {
+		t.Errorf("ACME asked for %q", acme.names)
+	}
+}
+
+func TestStaticCertsPolicy(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(Config{
+		Mapping: writeMapping(t, "static.test: 127.0.0.1:1\n"+
+			"www.static.test: 127.0.0.1:1\n"+
+			"notstatic.test: 127.0.0.1:1\n"+
+			"acme.test: 127.0.0.1:1\n"),
+		Cache: filepath.Join(dir, "cache"),
+		Certs: []S{"static.test:" + writeCert(t, dir, "static.test")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, host := range []S{"static.test", "www.static.test"} {
+		if err = s.Manager.HostPolicy(ctx, host); err == nil {
+			t.Errorf("ACME issuance allowed for %s with a static "+
+				"certificate", host)
+		}
+	}
+	for _, host := range []S{"acme.test", "notstatic.test"} {
+		if err = s.Manager.HostPolicy(ctx, host); err != nil {
+			t.Errorf("mapped host %s refused: %v", host, err)
+		}
+	}
+	if err = s.Manager.HostPolicy(ctx, "unmapped.test"); err == nil {
+		t.Error("unmapped host allowed")
+	}
+}
+
+func TestNextProtos(t *testing.T) {
+	for _, tc := range []struct {
+		order []S
+		want  []S
+	}{
+		{[]S{"h2", "http/1.1"}, []S{"h2", "http/1.1", "acme-tls/1"}},
+		{[]S{"http/1.1", "h2"}, []S{"http/1.1", "h2", "acme-tls/1"}},
+		{[]S{" HTTP/1.1 ", "", "http/1.1"}, []S{"http/1.1", "acme-tls/1"}},
+		{[]S{"acme-tls/1", "h2"}, []S{"h2", "acme-tls/1"}},
+		{[]S{"h3"}, nil},
+		{[]S{"acme-tls/1"}, nil},
+		{[]S{""}, nil},
+	} {
+		got, err := NextProtos(tc.order)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("NextProtos(%q) = %q, want an error", tc.order, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("NextProtos(%q) = %q, %v, want %q", tc.order, got, err,
+				tc.want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"lerproxy.mleku.dev/proxy"
+)
+
+// pruneArgs are the arguments of the prune-cache subcommand.
+type pruneArgs struct {
+	DryRun bool `arg:"--dry-run" help:"list the files that would be removed without removing them"`
+}
+
+// pruneCache removes the cached certificates of the hosts that are not in the
+// mapping, printing each file removed.
+func pruneCache(args runArgs) (err error) {
+	if args.Cache == "" {
+		err = log.E.Err("no cache specified")
+		return
+	}
+	var mapping map[string]string
+	if mapping, err = proxy.ReadMapping(args.Conf); chk.E(err) {
+		return
+	}
+	c := &proxy.Config{
+		Cache:        args.Cache,
+		ACMEProfiles: args.ACMEProfiles,
+		AllowAnyHost: args.AllowAnyHost,
+	}
+	var pruned []string
+	if pruned, err = c.PruneCache(mapping, args.Prune.DryRun); chk.E(err) {
+		return
+	}
+	for _, file := range pruned {
+		fmt.Println(file)
+	}
+	return
+}
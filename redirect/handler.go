@@ -0,0 +1,65 @@
+// Package redirect sends plain http requests to the same URL over https.
+package redirect
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handler redirects every request to https on the same host, path and query.
+type Handler struct {
+	// Status is the redirect status code. 307 and 308 make clients repeat
+	// the method and body, where 301 and 302 turn most into a GET.
+	Status int
+	// HSTS adds a Strict-Transport-Security header to the redirect.
+	HSTS bool
+	// Port is the public https port redirects point at, which may differ
+	// from the one listened on behind NAT. The default 443 is left out of
+	// the URL.
+	Port S
+	// Pending reports whether the certificate of a host is being obtained.
+	// If set along with RetryAfter, requests for such a host are answered
+	// with 503 Service Unavailable, rather than redirected to a handshake
+	// that stalls until the certificate is issued.
+	Pending func(host S) bool
+	// RetryAfter is the delay the 503 tells clients to retry after.
+	RetryAfter time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if hn, _, err := net.SplitHostPort(host); err == nil {
+		host = hn
+	}
+	if host == "" {
+		http.Error(w, "missing Host header", http.StatusBadRequest)
+		return
+	}
+	if h.RetryAfter > 0 && h.Pending != nil && h.Pending(host) {
+		log.I.F("certificate for %s is being obtained, asking %s to retry",
+			host, r.RemoteAddr)
+		w.Header().Set("Retry-After",
+			strconv.Itoa(int((h.RetryAfter+time.Second-1)/time.Second)))
+		http.Error(w, "the certificate for "+host+" is being obtained, "+
+			"please retry shortly", http.StatusServiceUnavailable)
+		return
+	}
+	status := h.Status
+	if status == 0 {
+		status = http.StatusPermanentRedirect
+	}
+	if h.HSTS {
+		w.Header().Set("Strict-Transport-Security",
+			"max-age=31536000; includeSubDomains; preload")
+	}
+	if h.Port != "" && h.Port != "443" {
+		host = net.JoinHostPort(host, h.Port)
+	} else if strings.Contains(host, ":") {
+		// an IPv6 literal.
+		host = "[" + host + "]"
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+}
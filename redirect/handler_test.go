@@ -0,0 +1,126 @@
+package redirect
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		name, method, url S
+		h                 Handler
+		status            int
+		loc               S
+	}{
+		{"default permanent", "GET", "http://example.com/a/b?x=1&y=%2F",
+			Handler{}, 308, "https://example.com/a/b?x=1&y=%2F"},
+		{"post keeps the method", "POST", "http://example.com/upload",
+			Handler{Status: 307}, 307, "https://example.com/upload"},
+		{"moved", "GET", "http://example.com/", Handler{Status: 301}, 301,
+			"https://example.com/"},
+		{"port dropped", "GET", "http://example.com:80/p", Handler{}, 308,
+			"https://example.com/p"},
+		{"public port", "GET", "http://example.com/p", Handler{Port: "8443"},
+			308, "https://example.com:8443/p"},
+		{"default port", "GET", "http://example.com/p", Handler{Port: "443"},
+			308, "https://example.com/p"},
+		{"ipv6", "GET", "http://[::1]:80/p", Handler{}, 308, "https://[::1]/p"},
+		{"escaped path", "GET", "http://example.com/a%2Fb/%20c", Handler{},
+			308, "https://example.com/a%2Fb/%20c"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tc.h.ServeHTTP(w, httptest.NewRequest(tc.method, tc.url, nil))
+			if w.Code != tc.status {
+				t.Errorf("status = %d, want %d", w.Code, tc.status)
+			}
+			if loc := w.Header().Get("Location"); loc != tc.loc {
+				t.Errorf("Location = %q, want %q", loc, tc.loc)
+			}
+			if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "" {
+				t.Errorf("unexpected Strict-Transport-Security %q", hsts)
+			}
+		})
+	}
+}
+
+func TestRedirectHSTS(t *testing.T) {
+	w := httptest.NewRecorder()
+	(&Handler{HSTS: true}).ServeHTTP(w,
+		httptest.NewRequest("GET", "http://example.com/", nil))
+	if hsts := w.Header().Get("Strict-Transport-Security"); !strings.Contains(
+		hsts, "preload") {
+		t.Errorf("Strict-Transport-Security = %q", hsts)
+	}
+}
+
+func TestRedirectMissingHost(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Host = ""
+	w := httptest.NewRecorder()
+	(&Handler{}).ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+}
+
+func TestRedirectPending(t *testing.T) {
+	h := &Handler{RetryAfter: 1500 * time.Millisecond,
+		Pending: func(host S) bool { return host == "new.example.com" }}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://new.example.com/", nil))
+	if w.Code != http.StatusServiceUnavailable ||
+		w.Header().Get("Retry-After") != "2" {
+		t.Errorf("status = %d, Retry-After = %q", w.Code,
+			w.Header().Get("Retry-After"))
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	if w.Code != http.StatusPermanentRedirect {
+		t.Errorf("status = %d, want 308", w.Code)
+	}
+}
+
+// TestRedirectPreservesBody follows the redirect of a POST with a client, to
+// an https server that every address dials.
+func TestRedirectPreservesBody(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			io.WriteString(w, r.Method+" "+r.Host+r.URL.RequestURI()+" "+
+				string(b))
+		}))
+	defer backend.Close()
+	front := httptest.NewServer(&Handler{})
+	defer front.Close()
+	c := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr S) (net.Conn,
+			error) {
+
+			if strings.HasSuffix(addr, ":443") {
+				addr = backend.Listener.Addr().String()
+			} else {
+				addr = front.Listener.Addr().String()
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	res, err := c.Post("http://example.com/upload?x=1", "text/plain",
+		strings.NewReader("the body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	if want := "POST example.com/upload?x=1 the body"; string(b) != want {
+		t.Errorf("backend got %q, want %q", b, want)
+	}
+}
@@ -0,0 +1,47 @@
+package reverse
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+)
+
+// TraceBackend is a RoundTripper recording the address of the connection
+// each request is sent to the backend over, which BackendAddr returns for
+// the request of the response. For srv:// backends and those with several
+// addresses it is the one actually chosen.
+type TraceBackend struct {
+	http.RoundTripper
+}
+
+type backendAddrKey struct{}
+
+func (t *TraceBackend) RoundTrip(req *http.Request) (res *http.Response,
+	err E) {
+
+	rt := t.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	addr := &atomic.Value{}
+	ctx := httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			addr.Store(info.Conn.RemoteAddr().String())
+		},
+	})
+	ctx = context.WithValue(ctx, backendAddrKey{}, addr)
+	return rt.RoundTrip(req.WithContext(ctx))
+}
+
+// BackendAddr returns the address of the backend req was sent to by a
+// TraceBackend, or "" if unknown.
+func BackendAddr(req *http.Request) (addr S) {
+	if req == nil {
+		return
+	}
+	if v, ok := req.Context().Value(backendAddrKey{}).(*atomic.Value); ok {
+		addr, _ = v.Load().(S)
+	}
+	return
+}
@@ -0,0 +1,62 @@
+package reverse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBudget is returned by a Budget whose backend did not answer in time.
+var ErrBudget = errors.New("response time budget exceeded")
+
+// Budget is a RoundTripper cancelling requests whose response headers have
+// not arrived from the backend within Timeout. Once they have, reading the
+// body is not limited.
+type Budget struct {
+	http.RoundTripper
+	Timeout time.Duration
+}
+
+func (b *Budget) RoundTrip(req *http.Request) (res *http.Response, err E) {
+	rt := b.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	var exceeded atomic.Bool
+	timer := time.AfterFunc(b.Timeout, func() {
+		exceeded.Store(true)
+		cancel()
+	})
+	res, err = rt.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() && exceeded.Load() {
+		if res != nil {
+			res.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("%w after %v: %w", ErrBudget, b.Timeout, err)
+	}
+	if err != nil {
+		cancel()
+		return
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return
+}
+
+// cancelBody releases the context of a request when its response body is
+// closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() (err E) {
+	err = b.ReadCloser.Close()
+	b.cancel()
+	return
+}
@@ -0,0 +1,114 @@
+package reverse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdLog "log"
+	"net"
+	"net/http"
+	"syscall"
+
+	"lerproxy.mleku.dev/routelog"
+	"lerproxy.mleku.dev/slowbody"
+)
+
+// Classify determines why a request to a backend failed and the status code
+// to answer the client with: 504 when the backend timed out or exceeded the
+// response time budget of its host, 408 when the client sent the request body
+// too slowly and 502 when it could not be reached or
+// broke the connection.
+func Classify(err E) (reason S, status int) {
+	var op *net.OpError
+	dial := errors.As(err, &op) && op.Op == "dial"
+	var ne net.Error
+	var se *StatusError
+	switch {
+	case errors.As(err, &se):
+		return fmt.Sprintf("status %d", se.Code), se.Code
+	case errors.Is(err, ErrBudget):
+		return "budget exceeded", http.StatusGatewayTimeout
+	case errors.Is(err, slowbody.ErrTooSlow):
+		return "client too slow", http.StatusRequestTimeout
+	case errors.Is(err, context.Canceled):
+		// the client went away, so nobody sees the status.
+		return "client canceled", http.StatusBadGateway
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &ne) && ne.Timeout():
+		if dial {
+			return "dial timeout", http.StatusGatewayTimeout
+		}
+		return "read timeout", http.StatusGatewayTimeout
+	case errors.Is(err, syscall.ENOENT):
+		// a unix socket backend that is not running or was never started.
+		return "socket not found", http.StatusBadGateway
+	case errors.Is(err, syscall.ECONNREFUSED):
+		// for a unix socket, it exists but nothing listens on it anymore.
+		return "connection refused", http.StatusBadGateway
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection reset", http.StatusBadGateway
+	case dial:
+		return "dial failed", http.StatusBadGateway
+	}
+	return "backend error", http.StatusBadGateway
+}
+
+// ErrorHandler returns a ReverseProxy.ErrorHandler that logs the classified
+// reason for a failed request to host's backend to l and answers with the
+// matching status.
+func ErrorHandler(host S, l *stdLog.Logger) func(http.ResponseWriter,
+	*http.Request, error) {
+
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		reason, status := Classify(err)
+		logError(l, host, r, reason, status, err)
+		w.WriteHeader(status)
+	}
+}
+
+// StaleHandler returns a ReverseProxy.ErrorHandler like ErrorHandler, that
+// answers GET and HEAD requests from stale, such as a file server with a
+// snapshot of the site, rather than with the status alone.
+func StaleHandler(host S, l *stdLog.Logger, stale http.Handler) func(
+	http.ResponseWriter, *http.Request, error) {
+
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		reason, status := Classify(err)
+		logError(l, host, r, reason, status, err)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(status)
+			return
+		}
+		routelog.Add(r.Context(), "serving the stale snapshot")
+		// the snapshot should not replace the site in caches.
+		w.Header().Set("Cache-Control", "no-store")
+		stale.ServeHTTP(w, r)
+	}
+}
+
+func logError(l *stdLog.Logger, host S, r *http.Request, reason S,
+	status int, err E) {
+
+	l.Printf("backend error host=%s method=%s path=%q reason=%q "+
+		"status=%d err=%q", host, r.Method, r.URL.Path, reason, status,
+		err.Error())
+}
+
+// StatusError is a server error response of a backend, turned into an error
+// so that it is handled like a failure to reach it.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() S {
+	return fmt.Sprintf("backend answered with status %d", e.Code)
+}
+
+// FailServerErrors is a ReverseProxy.ModifyResponse that fails responses
+// with a 5xx status with a StatusError.
+func FailServerErrors(res *http.Response) (err E) {
+	if res.StatusCode >= 500 {
+		err = &StatusError{Code: res.StatusCode}
+	}
+	return
+}
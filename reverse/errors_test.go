@@ -0,0 +1,40 @@
+package reverse
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"lerproxy.mleku.dev/slowbody"
+)
+
+func TestClassifyUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	_, err := net.Dial("unix", path)
+	if reason, status := Classify(err); reason != "socket not found" ||
+		status != http.StatusBadGateway {
+		t.Errorf("missing socket: %q %d (%v)", reason, status, err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file stays behind, as when the backend process dies.
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	_, err = net.Dial("unix", path)
+	if reason, status := Classify(err); reason != "connection refused" ||
+		status != http.StatusBadGateway {
+		t.Errorf("stale socket: %q %d (%v)", reason, status, err)
+	}
+}
+
+func TestClassifySlowBody(t *testing.T) {
+	err := fmt.Errorf("reading body: %w", slowbody.ErrTooSlow)
+	if reason, status := Classify(err); reason != "client too slow" ||
+		status != http.StatusRequestTimeout {
+		t.Errorf("slow body: %q %d", reason, status)
+	}
+}
@@ -0,0 +1,113 @@
+package reverse
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Trusted is the set of networks of proxies in front of lerproxy, whose
+// X-Forwarded-For chains are passed on to backends.
+type Trusted []netip.Prefix
+
+// ParseTrusted reads addresses and CIDR networks, such as 10.0.0.0/8.
+func ParseTrusted(specs []S) (t Trusted, err E) {
+	for _, spec := range specs {
+		var p netip.Prefix
+		if strings.Contains(spec, "/") {
+			p, err = netip.ParsePrefix(spec)
+		} else {
+			var a netip.Addr
+			if a, err = netip.ParseAddr(spec); err == nil {
+				p = netip.PrefixFrom(a, a.BitLen())
+			}
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid trusted proxy `%s`: %w", spec, err)
+			return
+		}
+		t = append(t, p.Masked())
+	}
+	return
+}
+
+// Contains reports whether the host of remoteAddr, an ip:port, is trusted.
+func (t Trusted) Contains(remoteAddr S) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	a = a.Unmap()
+	for _, p := range t {
+		if p.Contains(a) {
+			return true
+		}
+	}
+	return false
+}
+
+// StripForwardedFor removes the X-Forwarded-For of a request to a backend
+// unless the client at remoteAddr is a trusted proxy, since anyone else can
+// put any address in it. The ReverseProxy then appends the client's address,
+// so backends get the chain of trusted proxies ending with the client.
+func (t Trusted) StripForwardedFor(h http.Header, remoteAddr S) {
+	if !t.Contains(remoteAddr) {
+		h.Del("X-Forwarded-For")
+	}
+}
+
+// ClientIPHeaders are the names of the headers a host's backends get the
+// client address in, by host, with "*" applying to hosts not listed.
+// Without names for a host, it is X-Forwarded-For.
+type ClientIPHeaders map[S][]S
+
+// Parse reads header names in the form "example.com:True-Client-IP".
+func (c ClientIPHeaders) Parse(specs []S) (err E) {
+	for _, spec := range specs {
+		host, name, _ := strings.Cut(spec, ":")
+		host = strings.ToLower(host)
+		name = strings.TrimSpace(name)
+		if host == "" || name == "" {
+			err = fmt.Errorf("invalid client IP header parameter format: "+
+				"`%s`", spec)
+			return
+		}
+		c[host] = append(c[host], http.CanonicalHeaderKey(name))
+	}
+	return
+}
+
+// Set puts the address of the client at remoteAddr in the headers named for
+// host other than X-Forwarded-For, replacing whatever the client sent in
+// them. If X-Forwarded-For is not one of them, it is left out of the request
+// to the backend.
+func (c ClientIPHeaders) Set(host S, h http.Header, remoteAddr S) {
+	names, ok := c[host]
+	if !ok {
+		if names, ok = c["*"]; !ok {
+			return
+		}
+	}
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	xff := false
+	for _, name := range names {
+		if name == "X-Forwarded-For" {
+			xff = true
+			continue
+		}
+		h.Set(name, ip)
+	}
+	if !xff {
+		// a nil value keeps the ReverseProxy from adding it.
+		h["X-Forwarded-For"] = nil
+	}
+}
@@ -0,0 +1,59 @@
+package reverse
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Gunzip decompresses a gzip encoded response from the backend when the
+// client did not advertise gzip support in its Accept-Encoding.
+func Gunzip(res *http.Response) (err E) {
+	if !strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") ||
+		res.Request == nil || AcceptsGzip(res.Request.Header) {
+		return
+	}
+	var zr *gzip.Reader
+	if zr, err = gzip.NewReader(res.Body); chk.E(err) {
+		return
+	}
+	res.Body = &gunzipBody{Reader: zr, body: res.Body}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return
+}
+
+// AcceptsGzip reports whether the Accept-Encoding in h allows gzip.
+func AcceptsGzip(h http.Header) bool {
+	for _, v := range h.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+			name = strings.TrimSpace(name)
+			if !strings.EqualFold(name, "gzip") && name != "*" {
+				continue
+			}
+			// gzip;q=0 explicitly refuses it.
+			for _, p := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if q, err := strconv.ParseFloat(v, 64); k == "q" &&
+					err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// gunzipBody reads the decompressed body, closing the original one.
+type gunzipBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *gunzipBody) Close() (err E) { return b.body.Close() }
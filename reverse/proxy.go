@@ -4,12 +4,14 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 
 	"lerproxy.mleku.dev/util"
 )
 
 // NewSingleHostReverseProxy is a copy of httputil.NewSingleHostReverseProxy
-// with addition of "X-Forwarded-Proto" header.
+// with addition of "X-Forwarded-Proto" header. The ReverseProxy appends the
+// client address to X-Forwarded-For after the Director.
 func NewSingleHostReverseProxy(target *url.URL) (rp *httputil.ReverseProxy) {
 	targetQuery := target.RawQuery
 	director := func(req *http.Request) {
@@ -30,3 +32,42 @@ func NewSingleHostReverseProxy(target *url.URL) (rp *httputil.ReverseProxy) {
 	rp = &httputil.ReverseProxy{Director: director}
 	return
 }
+
+// RewriteLocation rewrites the Location header of a redirect from target so
+// that it points at the public host the client requested over https, rather
+// than the backend's internal address.
+//
+// Absolute locations are rewritten when their host is the backend's host or
+// the public host. Relative locations are kept, except that target's path
+// prefix is removed, since the proxy adds it to incoming requests.
+func RewriteLocation(res *http.Response, target *url.URL) {
+	loc := res.Header.Get("Location")
+	if loc == "" || res.Request == nil {
+		return
+	}
+	var err E
+	var u *url.URL
+	if u, err = url.Parse(loc); err != nil {
+		log.D.F("not rewriting invalid location %q: %v", loc, err)
+		return
+	}
+	public := res.Request.Host
+	if u.IsAbs() {
+		if !strings.EqualFold(u.Host, target.Host) &&
+			!strings.EqualFold(u.Host, public) {
+			// a redirect to some other site.
+			return
+		}
+		u.Scheme, u.Host = "https", public
+	} else if u.Host != "" {
+		// a scheme relative location such as //host/path.
+		return
+	}
+	if prefix := strings.TrimSuffix(target.Path, "/"); prefix != "" &&
+		(u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/")) {
+		u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, prefix),
+			"/")
+		u.RawPath = ""
+	}
+	res.Header.Set("Location", u.String())
+}
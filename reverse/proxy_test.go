@@ -0,0 +1,60 @@
+package reverse
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRewriteLocation(t *testing.T) {
+	for _, tc := range []struct {
+		name, target, loc, want S
+	}{
+		{"internal absolute", "http://127.0.0.1:8080",
+			"http://127.0.0.1:8080/login?next=%2F",
+			"https://example.com/login?next=%2F"},
+		{"public over http", "http://127.0.0.1:8080",
+			"http://example.com/a", "https://example.com/a"},
+		{"host case", "http://app.internal:8080",
+			"http://APP.internal:8080/a", "https://example.com/a"},
+		{"other site", "http://127.0.0.1:8080",
+			"https://accounts.example.org/auth", "https://accounts.example.org/auth"},
+		{"relative", "http://127.0.0.1:8080", "/b/c", "/b/c"},
+		{"relative to the page", "http://127.0.0.1:8080", "c?x=1", "c?x=1"},
+		{"scheme relative", "http://127.0.0.1:8080",
+			"//cdn.example.org/x", "//cdn.example.org/x"},
+		{"absolute under target path", "http://127.0.0.1:8080/app/",
+			"http://127.0.0.1:8080/app/login", "https://example.com/login"},
+		{"relative under target path", "http://127.0.0.1:8080/app",
+			"/app/login", "/login"},
+		{"target path itself", "http://127.0.0.1:8080/app", "/app", "/"},
+		{"outside target path", "http://127.0.0.1:8080/app",
+			"/application", "/application"},
+		{"invalid", "http://127.0.0.1:8080", "http://[::1", "http://[::1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			target, err := url.Parse(tc.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := &http.Response{
+				StatusCode: http.StatusFound,
+				Header:     http.Header{"Location": {tc.loc}},
+				Request:    &http.Request{Host: "example.com"},
+			}
+			RewriteLocation(res, target)
+			if got := res.Header.Get("Location"); got != tc.want {
+				t.Errorf("Location = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRewriteLocationNoHeader(t *testing.T) {
+	res := &http.Response{Header: http.Header{},
+		Request: &http.Request{Host: "example.com"}}
+	RewriteLocation(res, &url.URL{Scheme: "http", Host: "127.0.0.1:8080"})
+	if _, ok := res.Header["Location"]; ok {
+		t.Error("Location added")
+	}
+}
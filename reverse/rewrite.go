@@ -0,0 +1,141 @@
+package reverse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// BodyRewriters maps hostnames to the rewriter of their response bodies.
+type BodyRewriters map[S]*BodyRewriter
+
+// Parse reads replacements in the form "example.com:old new", such as
+// "example.com:http://internal:8080 https://example.com", adding them to the
+// rewriter of the host with the given content types and size limit.
+func (r BodyRewriters) Parse(specs, types []S, maxSize int64) (err E) {
+	pairs := make(map[S][]S)
+	for _, spec := range specs {
+		host, rest, _ := strings.Cut(spec, ":")
+		host = strings.ToLower(host)
+		old, nu, ok := strings.Cut(rest, " ")
+		if host == "" || old == "" || !ok {
+			err = fmt.Errorf("invalid body rewrite parameter format: `%s`",
+				spec)
+			return
+		}
+		pairs[host] = append(pairs[host], old, strings.TrimSpace(nu))
+	}
+	var trimmed []S
+	for _, t := range types {
+		if t = strings.TrimSpace(t); t != "" {
+			trimmed = append(trimmed, t)
+		}
+	}
+	if types = trimmed; len(types) == 0 {
+		types = []S{"text/html"}
+	}
+	for host, p := range pairs {
+		r[host] = &BodyRewriter{
+			Replacer: strings.NewReplacer(p...),
+			Types:    types,
+			MaxSize:  maxSize,
+		}
+	}
+	return
+}
+
+// BodyRewriter replaces strings in the bodies of responses from a backend,
+// such as the internal URLs of an application that cannot be configured with
+// its public one. The whole body is held in memory, so only those with one
+// of Types and no larger than MaxSize are rewritten.
+type BodyRewriter struct {
+	Replacer *strings.Replacer
+	// Types are the media types of the responses that are rewritten.
+	Types []S
+	// MaxSize is the largest body rewritten, unlimited if zero.
+	MaxSize int64
+}
+
+// ModifyResponse rewrites the body of res if it is plain or gzip encoded and
+// has one of the Types, setting its Content-Length to the new size.
+func (br *BodyRewriter) ModifyResponse(res *http.Response) (err E) {
+	if res.Body == nil || res.Body == http.NoBody || !br.matches(res.Header) {
+		return
+	}
+	enc := strings.ToLower(res.Header.Get("Content-Encoding"))
+	if enc != "" && enc != "identity" && enc != "gzip" {
+		return
+	}
+	limit := br.MaxSize
+	if limit <= 0 {
+		limit = 1<<63 - 2
+	}
+	var raw B
+	if raw, err = io.ReadAll(io.LimitReader(res.Body, limit+1)); chk.E(err) {
+		return
+	}
+	if int64(len(raw)) > limit {
+		log.D.F("not rewriting body larger than %d bytes", limit)
+		res.Body = &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(raw), res.Body),
+			body:   res.Body,
+		}
+		return
+	}
+	res.Body.Close()
+	body := raw
+	if enc == "gzip" {
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(raw)); chk.E(err) {
+			return
+		}
+		if body, err = io.ReadAll(zr); chk.E(err) {
+			return
+		}
+	}
+	body = B(br.Replacer.Replace(S(body)))
+	if enc == "gzip" {
+		var zb bytes.Buffer
+		zw := gzip.NewWriter(&zb)
+		if _, err = zw.Write(body); chk.E(err) {
+			return
+		}
+		if err = zw.Close(); chk.E(err) {
+			return
+		}
+		body = zb.Bytes()
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.TransferEncoding = nil
+	// the validators of the backend's body do not match the new one.
+	res.Header.Del("ETag")
+	return
+}
+
+func (br *BodyRewriter) matches(h http.Header) bool {
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, t := range br.Types {
+		if strings.EqualFold(mt, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// readCloser reads from Reader, closing the original body.
+type readCloser struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+func (b *readCloser) Close() (err E) { return b.body.Close() }
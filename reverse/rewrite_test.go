@@ -0,0 +1,203 @@
+package reverse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+const (
+	internal = "http://internal:8080"
+	public   = "https://example.com"
+)
+
+func rewriter(t *testing.T, maxSize int64, types ...S) *BodyRewriter {
+	t.Helper()
+	r := make(BodyRewriters)
+	if err := r.Parse([]S{"Example.com:" + internal + " " + public}, types,
+		maxSize); err != nil {
+		t.Fatal(err)
+	}
+	return r["example.com"]
+}
+
+func gzipped(t *testing.T, s S) B {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(B(s))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func response(ct, enc S, body B, chunked bool) (res *http.Response) {
+	res = &http.Response{
+		StatusCode:    200,
+		Header:        http.Header{"Content-Type": {ct}, "Etag": {`"v1"`}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+	}
+	if enc != "" {
+		res.Header.Set("Content-Encoding", enc)
+	}
+	if chunked {
+		res.ContentLength = -1
+		res.TransferEncoding = []S{"chunked"}
+	} else {
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return
+}
+
+func TestBodyRewrite(t *testing.T) {
+	page := `<a href="` + internal + `/a">a</a><img src="` + internal + `/b.png">`
+	want := `<a href="` + public + `/a">a</a><img src="` + public + `/b.png">`
+	for _, tc := range []struct {
+		name    string
+		enc     S
+		chunked bool
+	}{
+		{"plain", "", false},
+		{"plain chunked", "", true},
+		{"gzip", "gzip", false},
+		{"gzip chunked", "gzip", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			body := B(page)
+			if tc.enc == "gzip" {
+				body = gzipped(t, page)
+			}
+			res := response("text/html; charset=utf-8", tc.enc, body,
+				tc.chunked)
+			if err := rewriter(t, 0).ModifyResponse(res); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(res.Body)
+			if res.ContentLength != int64(len(got)) ||
+				res.Header.Get("Content-Length") != strconv.Itoa(len(got)) {
+				t.Errorf("Content-Length %d and %q for %d bytes",
+					res.ContentLength, res.Header.Get("Content-Length"),
+					len(got))
+			}
+			if res.TransferEncoding != nil {
+				t.Errorf("Transfer-Encoding %q kept", res.TransferEncoding)
+			}
+			if tc.enc == "gzip" {
+				zr, err := gzip.NewReader(bytes.NewReader(got))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ = io.ReadAll(zr)
+			}
+			if S(got) != want {
+				t.Errorf("body = %q, want %q", got, want)
+			}
+			if res.Header.Get("ETag") != "" {
+				t.Error("ETag of the backend's body kept")
+			}
+		})
+	}
+}
+
+func TestBodyRewriteSkipped(t *testing.T) {
+	page := "see " + internal + "/x"
+	for _, tc := range []struct {
+		name, ct, enc S
+		maxSize       int64
+	}{
+		{"other type", "application/json", "", 0},
+		{"invalid type", "text/html; ===", "", 0},
+		{"other encoding", "text/html", "br", 0},
+		{"too large", "text/html", "", int64(len(page) - 1)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := response(tc.ct, tc.enc, B(page), false)
+			if err := rewriter(t, tc.maxSize).ModifyResponse(
+				res); err != nil {
+				t.Fatal(err)
+			}
+			got, _ := io.ReadAll(res.Body)
+			if S(got) != page {
+				t.Errorf("body = %q, want it unchanged", got)
+			}
+			if res.Header.Get("ETag") == "" {
+				t.Error("ETag removed from an unchanged body")
+			}
+		})
+	}
+}
+
+func TestBodyRewriteTypes(t *testing.T) {
+	br := rewriter(t, 0, " text/html", "application/javascript ")
+	res := response("application/javascript", "", B(internal), false)
+	if err := br.ModifyResponse(res); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(res.Body); S(got) != public {
+		t.Errorf("body = %q, want %q", got, public)
+	}
+}
+
+func TestBodyRewritersParse(t *testing.T) {
+	for _, spec := range []S{"example.com", "example.com:old",
+		":old new", "example.com: new"} {
+		if err := make(BodyRewriters).Parse([]S{spec}, nil,
+			0); err == nil {
+			t.Errorf("%q accepted", spec)
+		}
+	}
+}
+
+// TestBodyRewriteProxied rewrites the gzip encoded body that a backend
+// streams in chunks, through a reverse proxy.
+func TestBodyRewriteProxied(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Encoding", "gzip")
+			zw := gzip.NewWriter(w)
+			for i := 0; i < 3; i++ {
+				io.WriteString(zw, "<p>"+internal+"/"+strconv.Itoa(i)+"</p>")
+				zw.Flush()
+				w.(http.Flusher).Flush()
+			}
+			zw.Close()
+		}))
+	defer backend.Close()
+	u, _ := url.Parse(backend.URL)
+	rp := httputil.NewSingleHostReverseProxy(u)
+	rp.ModifyResponse = rewriter(t, 0).ModifyResponse
+	front := httptest.NewServer(rp)
+	defer front.Close()
+	req, _ := http.NewRequest("GET", front.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.ContentLength <= 0 {
+		t.Errorf("Content-Length %d, want the rewritten size",
+			res.ContentLength)
+	}
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(zr)
+	var want S
+	for i := 0; i < 3; i++ {
+		want += "<p>" + public + "/" + strconv.Itoa(i) + "</p>"
+	}
+	if S(got) != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,97 @@
+package reverse
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// StatusRule replaces the status From of the backend responses to the
+// requests whose path, as sent to the backend, matches Pattern with To,
+// keeping their headers and body.
+type StatusRule struct {
+	// Pattern is matched with path.Match against the request path, or if it
+	// ends with a slash, matches the paths under it. All paths match if it
+	// is empty.
+	Pattern  S
+	From, To int
+}
+
+// Match reports whether the rule applies to the request path p.
+func (r StatusRule) Match(p S) bool {
+	if r.Pattern == "" {
+		return true
+	}
+	if strings.HasSuffix(r.Pattern, "/") {
+		return strings.HasPrefix(p, r.Pattern)
+	}
+	ok, _ := path.Match(r.Pattern, p)
+	return ok
+}
+
+// StatusRemaps maps hostnames to their rules, in the order they were given.
+type StatusRemaps map[S][]StatusRule
+
+// Parse reads rules in the form "example.com:500=503", or with a path
+// pattern, "example.com:/api/:500=503".
+func (s StatusRemaps) Parse(specs []S) (err E) {
+	for _, spec := range specs {
+		host, rest, _ := strings.Cut(spec, ":")
+		host = strings.ToLower(host)
+		var r StatusRule
+		codes := rest
+		if i := strings.LastIndexByte(rest, ':'); i >= 0 {
+			r.Pattern, codes = rest[:i], rest[i+1:]
+		}
+		from, to, ok := strings.Cut(codes, "=")
+		if r.From, err = status(from); err == nil {
+			r.To, err = status(to)
+		}
+		if host == "" || !ok || err != nil ||
+			(r.Pattern == "" && strings.Contains(rest, ":")) {
+
+			err = fmt.Errorf("invalid status remap parameter format: `%s`",
+				spec)
+			return
+		}
+		if _, err = path.Match(r.Pattern, ""); err != nil {
+			err = fmt.Errorf("invalid status remap pattern `%s`: %w",
+				r.Pattern, err)
+			return
+		}
+		s[host] = append(s[host], r)
+	}
+	return
+}
+
+// status reads a final response status code.
+func status(s S) (code int, err E) {
+	if code, err = strconv.Atoi(strings.TrimSpace(s)); err != nil {
+		return
+	}
+	if code < 200 || code > 599 {
+		err = fmt.Errorf("status %d out of range", code)
+	}
+	return
+}
+
+// RemapStatus is a ReverseProxy.ModifyResponse applying the first of rules
+// matching the status and request path of res.
+func RemapStatus(rules []StatusRule) func(res *http.Response) error {
+	return func(res *http.Response) error {
+		p := "/"
+		if res.Request != nil {
+			p = res.Request.URL.Path
+		}
+		for _, r := range rules {
+			if r.From == res.StatusCode && r.Match(p) {
+				res.StatusCode = r.To
+				res.Status = fmt.Sprintf("%d %s", r.To, http.StatusText(r.To))
+				break
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,68 @@
+package reverse
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestStatusRemapsParse(t *testing.T) {
+	s := make(StatusRemaps)
+	if err := s.Parse([]S{"Example.com:500=503",
+		"example.com:/api/:502=504", "example.com:/*.json:404=410"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []StatusRule{{"", 500, 503}, {"/api/", 502, 504},
+		{"/*.json", 404, 410}}
+	if got := s["example.com"]; len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	} else {
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("rule %d = %v, want %v", i, got[i], want[i])
+			}
+		}
+	}
+	for _, spec := range []S{
+		"example.com",
+		"example.com:500",
+		":500=503",
+		"example.com:500=",
+		"example.com:five=503",
+		"example.com:100=200",
+		"example.com:500=600",
+		"example.com::500=503",
+		"example.com:/[:500=503",
+	} {
+		if err := make(StatusRemaps).Parse([]S{spec}); err == nil {
+			t.Errorf("Parse(%q) accepted", spec)
+		}
+	}
+}
+
+func TestRemapStatus(t *testing.T) {
+	remap := RemapStatus([]StatusRule{{"/api/", 500, 503}, {"", 500, 502},
+		{"/old", 404, 410}})
+	for _, tc := range []struct {
+		path         S
+		status, want int
+	}{
+		{"/api/users", 500, 503},
+		{"/", 500, 502},
+		{"/old", 404, 410},
+		{"/new", 404, 404},
+		{"/api/users", 200, 200},
+		{"/api/users", 502, 502},
+	} {
+		res := &http.Response{StatusCode: tc.status,
+			Status:  http.StatusText(tc.status),
+			Request: &http.Request{URL: &url.URL{Path: tc.path}}}
+		if err := remap(res); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tc.want {
+			t.Errorf("%s %d: got %d, want %d", tc.path, tc.status,
+				res.StatusCode, tc.want)
+		}
+	}
+}
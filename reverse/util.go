@@ -2,9 +2,9 @@ package reverse
 
 import (
 	"bytes"
-	"os"
 
 	"ec.mleku.dev/v2/lol"
+	"lerproxy.mleku.dev/logging"
 )
 
 type (
@@ -14,6 +14,7 @@ type (
 )
 
 var (
-	log, chk, errorf = lol.New(os.Stderr)
-	equals           = bytes.Equal
+	log, _, errorf = lol.New(logging.Writer)
+	_, chk, _      = lol.New(logging.Checks)
+	equals         = bytes.Equal
 )
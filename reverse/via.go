@@ -0,0 +1,28 @@
+package reverse
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// viaName is how lerproxy names itself in Via headers, with its version when
+// the binary was built from a tagged module.
+var viaName = func() S {
+	if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" &&
+		bi.Main.Version != "(devel)" {
+
+		return "lerproxy (lerproxy.mleku.dev/" + bi.Main.Version + ")"
+	}
+	return "lerproxy"
+}()
+
+// AddVia appends lerproxy to the Via chain of h, as having received the
+// message with the given HTTP version.
+func AddVia(h http.Header, major, minor int) {
+	proto := fmt.Sprint(major)
+	if major < 2 {
+		proto = fmt.Sprintf("%d.%d", major, minor)
+	}
+	h.Add("Via", proto+" "+viaName)
+}
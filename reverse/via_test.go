@@ -0,0 +1,24 @@
+package reverse
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAddVia(t *testing.T) {
+	h := http.Header{"Via": {"1.1 cdn"}}
+	AddVia(h, 1, 1)
+	AddVia(h, 2, 0)
+	AddVia(h, 1, 0)
+	want := []S{"1.1 cdn", "1.1 " + viaName, "2 " + viaName,
+		"1.0 " + viaName}
+	got := h.Values("Via")
+	if len(got) != len(want) {
+		t.Fatalf("Via %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Via[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package routelog records the routing decisions made for a request, from
+// the host and route it matched to the backend chosen and the address
+// dialed, and logs them as one line at trace level once it is answered.
+package routelog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+)
+
+// record is the decisions made for a request so far. Those made by the
+// transport may come from another goroutine.
+type record struct {
+	mx    sync.Mutex
+	steps []S
+}
+
+type recordKey struct{}
+
+// Handler records the routing decisions made for the requests it passes to
+// Handler and logs them at trace level.
+type Handler struct {
+	http.Handler
+}
+
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec := &record{}
+	h.Handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(),
+		recordKey{}, rec)))
+	log.T.C(func() S {
+		rec.mx.Lock()
+		defer rec.mx.Unlock()
+		return fmt.Sprintf("routed %s %s%s from %s: %s", r.Method, r.Host,
+			r.URL.Path, r.RemoteAddr, strings.Join(rec.steps, "; "))
+	})
+}
+
+// Add records a decision for the request of ctx, if it goes through a
+// Handler. It costs nothing otherwise.
+func Add(ctx context.Context, format S, args ...any) {
+	rec, ok := ctx.Value(recordKey{}).(*record)
+	if !ok {
+		return
+	}
+	step := fmt.Sprintf(format, args...)
+	rec.mx.Lock()
+	rec.steps = append(rec.steps, step)
+	rec.mx.Unlock()
+}
+
+// Transport records the address each request is sent to the backend over,
+// and whether the connection was new.
+type Transport struct {
+	http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (res *http.Response,
+	err E) {
+
+	rt := t.RoundTripper
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	ctx := req.Context()
+	if _, ok := ctx.Value(recordKey{}).(*record); !ok {
+		return rt.RoundTrip(req)
+	}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			how := "dialed"
+			if info.Reused {
+				how = "reused connection to"
+			}
+			Add(ctx, "%s %s", how, info.Conn.RemoteAddr())
+		},
+	})
+	if res, err = rt.RoundTrip(req.WithContext(ctx)); err != nil {
+		Add(ctx, "backend failed: %v", err)
+	}
+	return
+}
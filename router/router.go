@@ -0,0 +1,177 @@
+// Package router dispatches requests to a handler per host with a single map
+// lookup, which stays fast with thousands of hosts where a ServeMux would
+// match patterns.
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"lerproxy.mleku.dev/routelog"
+)
+
+// Router routes requests by their Host, ignoring case. It must not be
+// modified once it is serving; build a new one instead.
+//
+// A host of the form *.example.com matches any name ending in .example.com
+// that has no handler of its own. Where several wildcards match, the one
+// with the longest suffix wins.
+type Router struct {
+	hosts     map[S]http.Handler
+	wildcards []wildcard
+	// NotFound answers requests for hosts without a handler.
+	NotFound http.Handler
+}
+
+type wildcard struct {
+	// suffix with its leading dot.
+	suffix  S
+	handler http.Handler
+}
+
+// New creates an empty Router answering unknown hosts with notFound.
+func New(notFound http.Handler) *Router {
+	return &Router{hosts: make(map[S]http.Handler), NotFound: notFound}
+}
+
+// Handle routes requests for host, or for the names matching it if it is a
+// wildcard, to h.
+func (rt *Router) Handle(host S, h http.Handler) {
+	host = strings.ToLower(host)
+	suffix, ok := strings.CutPrefix(host, "*")
+	if !ok {
+		rt.hosts[host] = h
+		return
+	}
+	rt.wildcards = append(rt.wildcards, wildcard{suffix, h})
+	sort.SliceStable(rt.wildcards, func(i, j int) bool {
+		return len(rt.wildcards[i].suffix) > len(rt.wildcards[j].suffix)
+	})
+}
+
+// Len is the number of hosts and wildcards routed.
+func (rt *Router) Len() int { return len(rt.hosts) + len(rt.wildcards) }
+
+// Handler returns the handler for the request's host, or nil.
+func (rt *Router) Handler(r *http.Request) (h http.Handler) {
+	h, _ = rt.match(r)
+	return
+}
+
+// match returns the handler for the request's host and the host or wildcard
+// it was found under, or nil.
+func (rt *Router) match(r *http.Request) (h http.Handler, entry S) {
+	host := Host(r)
+	if hn, _, err := net.SplitHostPort(host); err == nil {
+		host = hn
+	}
+	host = strings.ToLower(host)
+	if h = rt.hosts[host]; h != nil {
+		return h, host
+	}
+	for _, w := range rt.wildcards {
+		if len(host) > len(w.suffix) && strings.HasSuffix(host, w.suffix) {
+			return w.handler, "*" + w.suffix
+		}
+	}
+	return
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, entry := rt.match(r)
+	if h == nil {
+		routelog.Add(r.Context(), "no host matched")
+		rt.NotFound.ServeHTTP(w, r)
+		return
+	}
+	routelog.Add(r.Context(), "host %s", entry)
+	// redirect paths with . or .. elements and repeated slashes to their
+	// clean form, as a ServeMux does.
+	_, verbatim := h.(Verbatim)
+	if !verbatim && r.Method != http.MethodConnect {
+		if p := cleanPath(r.URL.Path); p != r.URL.Path {
+			u := *r.URL
+			u.Path = p
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+	}
+	h.ServeHTTP(w, r)
+}
+
+// Host returns the host r is for. That is its Host, except for CONNECT
+// requests over TLS, whose Host is the target of the tunnel they ask for,
+// where it is the server name the client connected to.
+func Host(r *http.Request) S {
+	if r.Method != http.MethodConnect {
+		return r.Host
+	}
+	if r.TLS != nil && r.TLS.ServerName != "" {
+		return r.TLS.ServerName
+	}
+	// HTTP/2 CONNECT requests have no TLS state of their own.
+	if c, ok := r.Context().Value(connKey{}).(*tls.Conn); ok {
+		if sn := c.ConnectionState().ServerName; sn != "" {
+			return sn
+		}
+	}
+	return r.Host
+}
+
+type connKey struct{}
+
+// ConnContext is an http.Server ConnContext that keeps the connection of the
+// requests on it, so that Host finds the server name of HTTP/2 CONNECT
+// requests.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// Verbatim is a handler for a host whose requests are passed to it with
+// their path as it is, rather than redirected to its clean form.
+type Verbatim struct {
+	http.Handler
+}
+
+// EmptyHost handles requests without a Host header, which HTTP/1.0 clients
+// may leave out, and which would otherwise match no host: they are rejected
+// with 400 Bad Request if Reject is set, or else routed as requests for
+// Host, if it is set.
+type EmptyHost struct {
+	http.Handler
+	Host   S
+	Reject bool
+}
+
+func (e *EmptyHost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host == "" {
+		switch {
+		case e.Reject:
+			http.Error(w, "missing Host header", http.StatusBadRequest)
+			return
+		case e.Host != "":
+			r.Host = e.Host
+		}
+	}
+	e.Handler.ServeHTTP(w, r)
+}
+
+// cleanPath returns the canonical form of p, keeping a trailing slash.
+func cleanPath(p S) S {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if strings.HasSuffix(p, "/") && np != "/" {
+		np += "/"
+	}
+	return np
+}
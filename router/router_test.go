@@ -0,0 +1,172 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// benchHosts is the number of hosts of the benchmarks, that of a large
+// mapping.
+const benchHosts = 5000
+
+var ok = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+func hostName(i int) S { return fmt.Sprintf("host%d.example.com", i) }
+
+func newRouter() (rt *Router) {
+	rt = New(http.NotFoundHandler())
+	for i := 0; i < benchHosts; i++ {
+		rt.Handle(hostName(i), ok)
+	}
+	return
+}
+
+func newServeMux() (mux *http.ServeMux) {
+	mux = http.NewServeMux()
+	for i := 0; i < benchHosts; i++ {
+		mux.Handle(hostName(i)+"/", ok)
+	}
+	return
+}
+
+func BenchmarkBuildRouter(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		newRouter()
+	}
+}
+
+// BenchmarkBuildServeMux is how routes were built before the Router.
+func BenchmarkBuildServeMux(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		newServeMux()
+	}
+}
+
+func benchmarkRoute(b *testing.B, h http.Handler) {
+	reqs := make([]*http.Request, 64)
+	for i := range reqs {
+		reqs[i] = httptest.NewRequest("GET",
+			"https://"+hostName(i*benchHosts/len(reqs))+"/a/b", nil)
+	}
+	w := httptest.NewRecorder()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(w, reqs[i%len(reqs)])
+	}
+}
+
+func BenchmarkRouteRouter(b *testing.B) { benchmarkRoute(b, newRouter()) }
+
+// BenchmarkRouteServeMux is how requests were routed before the Router.
+func BenchmarkRouteServeMux(b *testing.B) { benchmarkRoute(b, newServeMux()) }
+
+func TestVerbatim(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	})
+	rt := New(http.NotFoundHandler())
+	rt.Handle("clean.test", echo)
+	rt.Handle("raw.test", Verbatim{Handler: echo})
+	for _, tc := range []struct {
+		url      S
+		status   int
+		location S
+		path     S
+	}{
+		{"https://clean.test/a/b", http.StatusOK, "", "/a/b"},
+		{"https://clean.test/a/../b", http.StatusMovedPermanently,
+			"https://clean.test/b", ""},
+		{"https://clean.test//a//b/", http.StatusMovedPermanently,
+			"https://clean.test/a/b/", ""},
+		{"https://raw.test/a/../b", http.StatusOK, "", "/a/../b"},
+		{"https://raw.test//a//b/", http.StatusOK, "", "//a//b/"},
+	} {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.url, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.url, w.Code, tc.status)
+		}
+		if loc := w.Header().Get("Location"); loc != tc.location {
+			t.Errorf("%s: Location %q, want %q", tc.url, loc, tc.location)
+		}
+		if tc.path != "" && w.Body.String() != tc.path {
+			t.Errorf("%s: handler saw %q, want %q", tc.url, w.Body.String(),
+				tc.path)
+		}
+	}
+}
+
+func TestEmptyHost(t *testing.T) {
+	rt := New(http.NotFoundHandler())
+	rt.Handle("default.test", ok)
+	rt.Handle("other.test", ok)
+	for _, tc := range []struct {
+		name   S
+		e      EmptyHost
+		host   S
+		status int
+	}{
+		{"unset", EmptyHost{}, "", http.StatusNotFound},
+		{"default", EmptyHost{Host: "default.test"}, "", http.StatusOK},
+		{"reject", EmptyHost{Reject: true}, "", http.StatusBadRequest},
+		{"reject before default", EmptyHost{Host: "default.test",
+			Reject: true}, "", http.StatusBadRequest},
+		{"host given", EmptyHost{Host: "default.test", Reject: true},
+			"other.test", http.StatusOK},
+	} {
+		tc.e.Handler = rt
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+		w := httptest.NewRecorder()
+		tc.e.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, w.Code, tc.status)
+		}
+	}
+}
+
+// named answers with its name in the X-Route header.
+type named S
+
+func (n named) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Route", S(n))
+}
+
+func TestWildcardPrecedence(t *testing.T) {
+	rt := New(named("not found"))
+	// the shorter wildcard first, so that the order given does not decide.
+	rt.Handle("*.example.com", named("*.example.com"))
+	rt.Handle("*.A.example.com", named("*.a.example.com"))
+	rt.Handle("www.example.com", named("www.example.com"))
+	rt.Handle("x.a.example.com", named("x.a.example.com"))
+	for _, tc := range []struct {
+		host, want S
+	}{
+		{"www.example.com", "www.example.com"},
+		{"api.example.com", "*.example.com"},
+		{"deep.api.example.com", "*.example.com"},
+		{"x.a.example.com", "x.a.example.com"},
+		{"y.a.example.com", "*.a.example.com"},
+		{"a.example.com", "*.example.com"},
+		{"example.com", "not found"},
+		{"notexample.com", "not found"},
+		{".example.com", "not found"},
+		{"WWW.Example.COM", "www.example.com"},
+		{"www.example.com:8443", "www.example.com"},
+		{"Y.A.Example.com:443", "*.a.example.com"},
+		{"EXAMPLE.com:443", "not found"},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Host = tc.host
+		rt.ServeHTTP(w, r)
+		if got := w.Header().Get("X-Route"); got != tc.want {
+			t.Errorf("%s: routed to %s, want %s", tc.host, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Package secret resolves the values of options holding secrets, which can
+// be given as @/path/to/file to read them from a file rather than exposing
+// them in the process list.
+package secret
+
+import (
+	"os"
+	"strings"
+)
+
+// Resolve returns v, or the content of the file it names if it is of the
+// form @/path/to/file, without surrounding whitespace.
+func Resolve(v S) (s S, err E) {
+	path, ok := strings.CutPrefix(v, "@")
+	if !ok {
+		return v, nil
+	}
+	CheckPermissions(path)
+	var b B
+	if b, err = os.ReadFile(path); chk.E(err) {
+		return
+	}
+	if s = strings.TrimSpace(S(b)); s == "" {
+		err = log.E.Err("secret file %s is empty", path)
+	}
+	return
+}
+
+// CheckPermissions warns if the file at path can be read by users other
+// than its owner and group.
+func CheckPermissions(path S) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if fi.Mode().Perm()&0004 != 0 {
+		log.W.F("secret file %s is readable by all users (mode %v), it "+
+			"should be 0600 or 0640", path, fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// drainSignals toggle drain mode, which has no signal on windows, only the
+// admin server's /drain.
+var drainSignals []os.Signal
@@ -0,0 +1,101 @@
+// Package slowbody aborts requests whose body arrives slower than a minimum
+// rate, since a client trickling it a byte at a time holds on to a
+// connection and a request to a backend for as long as it likes, which the
+// server's ReadHeaderTimeout does not cover.
+package slowbody
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ErrTooSlow is the error of reading a request body that does not arrive at
+// the minimum rate.
+var ErrTooSlow = errors.New("request body arrived too slowly")
+
+// DefaultWindow is the Window of a Handler that has none.
+const DefaultWindow = 10 * time.Second
+
+// Handler requires the bodies of requests to arrive at MinRate bytes per
+// second, averaged over each Window, failing their reads with ErrTooSlow
+// otherwise. The connection of such a request is not reused. Only the time
+// spent waiting for the client in a read counts, not the time between reads,
+// such as while the backend is slow to take the body.
+type Handler struct {
+	http.Handler
+	MinRate int64
+	Window  time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.MinRate > 0 && r.Body != nil && r.Body != http.NoBody {
+		window := h.Window
+		if window <= 0 {
+			window = DefaultWindow
+		}
+		need := int64(float64(h.MinRate) * window.Seconds())
+		if need < 1 {
+			need = 1
+		}
+		r.Body = &body{
+			ReadCloser: r.Body,
+			rc:         http.NewResponseController(w),
+			window:     window,
+			need:       need,
+			r:          r,
+		}
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+// body counts the bytes read in the current window and the time spent
+// waiting for them. Each read has the read deadline of the connection at the
+// end of what is left of the window, so that a client sending nothing at all
+// does not block it for longer, and cleared after it, so that the connection
+// does not time out while the body is not being read.
+type body struct {
+	io.ReadCloser
+	rc     *http.ResponseController
+	window time.Duration
+	need   int64
+	got    int64
+	spent  time.Duration
+	err    E
+	r      *http.Request
+}
+
+func (b *body) Read(p []byte) (n int, err E) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	start := time.Now()
+	b.deadline(start.Add(b.window - b.spent))
+	n, err = b.ReadCloser.Read(p)
+	b.deadline(time.Time{})
+	b.got += int64(n)
+	b.spent += time.Since(start)
+	switch {
+	case b.got >= b.need:
+		b.got, b.spent = 0, 0
+	case b.spent >= b.window || errors.Is(err, os.ErrDeadlineExceeded):
+		log.D.F("aborting request for %s%s from %s, %d bytes of the body "+
+			"in %v", b.r.Host, b.r.URL.Path, b.r.RemoteAddr, b.got, b.window)
+		b.err = ErrTooSlow
+		// the server does not wait for the rest of the body either before
+		// answering.
+		b.deadline(time.Now())
+		return n, b.err
+	}
+	return
+}
+
+func (b *body) deadline(t time.Time) {
+	if err := b.rc.SetReadDeadline(t); err != nil &&
+		!errors.Is(err, http.ErrNotSupported) {
+
+		log.D.Ln("setting read deadline:", err)
+	}
+}
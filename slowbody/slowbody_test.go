@@ -0,0 +1,136 @@
+package slowbody
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// server answers 408 when the request body came too slowly, and the length
+// of the body otherwise.
+func server(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(&Handler{
+		MinRate: 100,
+		Window:  200 * time.Millisecond,
+		Handler: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				if errors.Is(err, ErrTooSlow) {
+					w.WriteHeader(http.StatusRequestTimeout)
+					return
+				}
+				fmt.Fprint(w, len(b))
+			}),
+	})
+	t.Cleanup(s.Close)
+	return s
+}
+
+// post sends a body of size bytes, chunk bytes at a time with pause in
+// between, and returns the status of the response.
+func post(t *testing.T, s *httptest.Server, size, chunk int,
+	pause time.Duration) int {
+
+	t.Helper()
+	c, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n",
+		size)
+	go func() {
+		for sent := 0; sent < size && chunk > 0; sent += chunk {
+			if _, err := io.WriteString(c,
+				strings.Repeat("x", min(chunk, size-sent))); err != nil {
+				return
+			}
+			time.Sleep(pause)
+		}
+	}()
+	res, err := http.ReadResponse(bufio.NewReader(c), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestHandler(t *testing.T) {
+	s := server(t)
+	for _, tc := range []struct {
+		name        S
+		size, chunk int
+		pause       time.Duration
+		status      int
+	}{
+		{"fast", 1000, 1000, 0, http.StatusOK},
+		{"steady", 100, 10, 20 * time.Millisecond, http.StatusOK},
+		{"trickle", 100, 1, 50 * time.Millisecond,
+			http.StatusRequestTimeout},
+		{"silent", 100, 0, 0, http.StatusRequestTimeout},
+	} {
+		start := time.Now()
+		if status := post(t, s, tc.size, tc.chunk,
+			tc.pause); status != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("%s: took %v", tc.name, d)
+		}
+	}
+}
+
+func TestHandlerNoBody(t *testing.T) {
+	s := server(t)
+	res, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status %d", res.StatusCode)
+	}
+}
+
+// TestSlowConsumer checks that a body sent at once is not taken for a slow
+// one when the handler is slow to read it, as when the backend is slow to
+// accept it.
+func TestSlowConsumer(t *testing.T) {
+	s := httptest.NewServer(&Handler{
+		MinRate: 100,
+		Window:  100 * time.Millisecond,
+		Handler: http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				var n int
+				// less than the 10 bytes needed in each window.
+				buf := make([]byte, 5)
+				for {
+					m, err := r.Body.Read(buf)
+					n += m
+					if errors.Is(err, ErrTooSlow) {
+						w.WriteHeader(http.StatusRequestTimeout)
+						return
+					}
+					if err != nil {
+						break
+					}
+					// more than a window passes between reads.
+					time.Sleep(150 * time.Millisecond)
+				}
+				fmt.Fprint(w, n)
+			}),
+	})
+	defer s.Close()
+	if status := post(t, s, 20, 20, 0); status != http.StatusOK {
+		t.Errorf("status %d, want %d", status, http.StatusOK)
+	}
+}
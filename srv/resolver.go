@@ -0,0 +1,139 @@
+// Package srv picks backend addresses from DNS SRV records, for backends
+// registered with service discovery such as Consul or Kubernetes headless
+// services.
+package srv
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lerproxy.mleku.dev/routelog"
+)
+
+// Resolver looks up the SRV records of Name, caching them for TTL. If a
+// lookup fails, the last records found keep being used.
+type Resolver struct {
+	// Name is the full SRV name, such as _http._tcp.myservice.consul.
+	Name S
+	// TTL is how long records are used before they are looked up again.
+	TTL time.Duration
+	// Strategy chooses among the targets with the lowest priority value.
+	Strategy Strategy
+
+	mx      sync.Mutex
+	records []*net.SRV
+	expires time.Time
+	next    int
+	latency map[S]time.Duration
+	// resolving is set while the records are looked up.
+	resolving bool
+}
+
+// New creates a Resolver for name.
+func New(name S, ttl time.Duration, strategy Strategy) *Resolver {
+	return &Resolver{Name: name, TTL: ttl, Strategy: strategy}
+}
+
+// Pick returns the host:port of a target, chosen by the Strategy among those
+// with the lowest priority value.
+func (r *Resolver) Pick(ctx context.Context) (addr S, err E) {
+	var records []*net.SRV
+	if records, err = r.lookup(ctx); err != nil {
+		return
+	}
+	var t *net.SRV
+	switch group := lowest(records); r.Strategy {
+	case RoundRobin:
+		t = r.pickRoundRobin(group)
+	case Latency:
+		t = r.pickLatency(group)
+	default:
+		t = pick(group)
+	}
+	addr = address(t)
+	routelog.Add(ctx, "%s picked %s of %s", r.Strategy, addr, r.Name)
+	return
+}
+
+// address is the host:port of the target of a record.
+func address(t *net.SRV) S {
+	return net.JoinHostPort(strings.TrimSuffix(t.Target, "."),
+		strconv.Itoa(int(t.Port)))
+}
+
+// lowest returns the records with the lowest priority value.
+func lowest(records []*net.SRV) (group []*net.SRV) {
+	best := records[0].Priority
+	for _, r := range records {
+		best = min(best, r.Priority)
+	}
+	for _, r := range records {
+		if r.Priority == best {
+			group = append(group, r)
+		}
+	}
+	return
+}
+
+// lookup returns the cached records, or looks them up if they expired. The
+// lock is not held during the query, so that picks do not wait on a slow
+// resolver, and while one query is running the others use the expired
+// records.
+func (r *Resolver) lookup(ctx context.Context) (records []*net.SRV, err E) {
+	r.mx.Lock()
+	if r.records != nil && (time.Now().Before(r.expires) || r.resolving) {
+		records = r.records
+		r.mx.Unlock()
+		return
+	}
+	r.resolving = true
+	r.mx.Unlock()
+	// the records are already sorted by priority and randomized by weight.
+	_, records, err = net.DefaultResolver.LookupSRV(ctx, "", "", r.Name)
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.resolving = false
+	if err != nil || len(records) == 0 {
+
+		if err == nil {
+			err = fmt.Errorf("no SRV records for %s", r.Name)
+		}
+		if r.records == nil {
+			log.E.F("resolving %s: %v", r.Name, err)
+			return
+		}
+		log.W.F("resolving %s: %v, using previous records", r.Name, err)
+		// retry after another TTL rather than on every request.
+		r.expires = time.Now().Add(r.TTL)
+		return r.records, nil
+	}
+	log.D.F("resolved %s to %d targets", r.Name, len(records))
+	r.records, r.expires = records, time.Now().Add(r.TTL)
+	return
+}
+
+// pick selects a target of a priority group as described in RFC 2782: each
+// is chosen with probability proportional to its weight, and records with
+// weight 0 only rarely unless all are 0.
+func pick(group []*net.SRV) (t *net.SRV) {
+	var total int
+	for _, r := range group {
+		total += int(r.Weight)
+	}
+	if total == 0 {
+		return group[rand.IntN(len(group))]
+	}
+	n := rand.IntN(total)
+	for _, r := range group {
+		if n -= int(r.Weight); n < 0 {
+			return r
+		}
+	}
+	return group[len(group)-1]
+}
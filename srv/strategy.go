@@ -0,0 +1,109 @@
+package srv
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"time"
+)
+
+// Strategy is how a Resolver chooses among the targets with the lowest
+// priority value.
+type Strategy int
+
+const (
+	// Weighted picks targets in proportion to their SRV weight.
+	Weighted Strategy = iota
+	// RoundRobin picks each target in turn, ignoring weights.
+	RoundRobin
+	// Latency picks targets in inverse proportion to their recent average
+	// response time, so faster targets get more of the requests while slower
+	// ones still get enough to notice when they recover. Like the others, it
+	// picks when a connection is dialed, so the requests sent on a kept alive
+	// connection all go to the same target.
+	Latency
+)
+
+var strategies = map[S]Strategy{
+	"weighted":    Weighted,
+	"round-robin": RoundRobin,
+	"latency":     Latency,
+}
+
+// ParseStrategy returns the Strategy named s.
+func ParseStrategy(s S) (st Strategy, err E) {
+	var ok bool
+	if st, ok = strategies[s]; !ok {
+		err = fmt.Errorf("unknown load balancing strategy %q, want weighted, "+
+			"round-robin or latency", s)
+	}
+	return
+}
+
+func (st Strategy) String() S {
+	for name, v := range strategies {
+		if v == st {
+			return name
+		}
+	}
+	return fmt.Sprintf("Strategy(%d)", int(st))
+}
+
+// smoothing is the weight of a new sample in the moving average of a
+// target's latency.
+const smoothing = 0.2
+
+// failedLatency is recorded for a target that could not be dialed or failed
+// to answer, so that it gets measured and is then rarely picked until it
+// answers again.
+const failedLatency = 10 * time.Second
+
+// Observe records that a request to the target at addr took d to be
+// answered, for the Latency strategy.
+func (r *Resolver) Observe(addr S, d time.Duration) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.latency == nil {
+		r.latency = make(map[S]time.Duration)
+	}
+	avg, ok := r.latency[addr]
+	if !ok {
+		r.latency[addr] = d
+		return
+	}
+	r.latency[addr] = avg + time.Duration(smoothing*float64(d-avg))
+}
+
+// pickRoundRobin returns the targets of group in turn.
+func (r *Resolver) pickRoundRobin(group []*net.SRV) (t *net.SRV) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	t = group[r.next%len(group)]
+	r.next++
+	return
+}
+
+// pickLatency chooses a target with probability inversely proportional to
+// its average latency. Targets that have not been measured yet are tried
+// first.
+func (r *Resolver) pickLatency(group []*net.SRV) (t *net.SRV) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	speeds := make([]float64, len(group))
+	var total float64
+	for i, g := range group {
+		avg, ok := r.latency[address(g)]
+		if !ok {
+			return g
+		}
+		speeds[i] = 1 / max(avg.Seconds(), 1e-6)
+		total += speeds[i]
+	}
+	n := rand.Float64() * total
+	for i, g := range group {
+		if n -= speeds[i]; n < 0 {
+			return g
+		}
+	}
+	return group[len(group)-1]
+}
@@ -0,0 +1,211 @@
+package srv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resolved returns a Resolver of strategy whose records are the addresses
+// of servers, without looking them up.
+func resolved(t *testing.T, strategy Strategy,
+	servers ...*httptest.Server) (r *Resolver) {
+
+	r = New("_http._tcp.test", time.Hour, strategy)
+	for _, s := range servers {
+		host, port, err := net.SplitHostPort(s.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, _ := strconv.Atoi(port)
+		r.records = append(r.records, &net.SRV{Target: host + ".",
+			Port: uint16(p), Weight: 1})
+	}
+	r.expires = time.Now().Add(time.Hour)
+	return
+}
+
+func TestObserve(t *testing.T) {
+	r := New("", 0, Latency)
+	r.Observe("a", 100*time.Millisecond)
+	if d := r.latency["a"]; d != 100*time.Millisecond {
+		t.Errorf("first sample = %v, want 100ms", d)
+	}
+	r.Observe("a", 200*time.Millisecond)
+	if d := r.latency["a"]; d != 120*time.Millisecond {
+		t.Errorf("average = %v, want 120ms", d)
+	}
+}
+
+func TestPickLatencyUnmeasured(t *testing.T) {
+	r := New("", 0, Latency)
+	group := []*net.SRV{{Target: "a.", Port: 1}, {Target: "b.", Port: 1}}
+	r.Observe("a:1", time.Millisecond)
+	for i := 0; i < 10; i++ {
+		if got := r.pickLatency(group); got != group[1] {
+			t.Fatalf("picked %s before the unmeasured b", got.Target)
+		}
+	}
+}
+
+// TestLatencyPrefersFast sends requests through a Transport to a slow and a
+// fast backend, which should get most of them.
+func TestLatencyPrefersFast(t *testing.T) {
+	var slowHits, fastHits int
+	slow := httptest.NewServer(http.HandlerFunc(
+		func(http.ResponseWriter, *http.Request) {
+			slowHits++
+			time.Sleep(20 * time.Millisecond)
+		}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(
+		func(http.ResponseWriter, *http.Request) { fastHits++ }))
+	defer fast.Close()
+	r := resolved(t, Latency, slow, fast)
+	c := &http.Client{Transport: &Transport{
+		RoundTripper: &http.Transport{
+			DialContext: func(ctx context.Context, n, _ S) (net.Conn, error) {
+				return r.Dial(ctx, n, time.Second)
+			},
+			// a new connection each time, so that each request picks.
+			DisableKeepAlives: true,
+		},
+		Resolver: r,
+	}}
+	const requests = 200
+	for i := 0; i < requests; i++ {
+		res, err := c.Get("http://backend/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+	if slowHits == 0 || fastHits == 0 {
+		t.Fatalf("slow got %d and fast %d, both should be measured",
+			slowHits, fastHits)
+	}
+	if fastHits < requests*3/4 {
+		t.Errorf("fast got %d of %d requests, slow %d", fastHits, requests,
+			slowHits)
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	a := httptest.NewServer(http.NotFoundHandler())
+	defer a.Close()
+	b := httptest.NewServer(http.NotFoundHandler())
+	defer b.Close()
+	r := resolved(t, RoundRobin, a, b)
+	var picks []S
+	for i := 0; i < 4; i++ {
+		addr, err := r.Pick(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks = append(picks, addr)
+	}
+	if picks[0] == picks[1] || picks[0] != picks[2] || picks[1] != picks[3] {
+		t.Errorf("picks not in turn: %v", picks)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	for name, want := range strategies {
+		if st, err := ParseStrategy(name); err != nil || st != want ||
+			st.String() != name {
+			t.Errorf("ParseStrategy(%q) = %v, %v", name, st, err)
+		}
+	}
+	if _, err := ParseStrategy("fastest"); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+}
+
+// TestLatencyDeadTarget checks that a target refusing connections is
+// measured, rather than being picked first forever as unmeasured.
+func TestLatencyDeadTarget(t *testing.T) {
+	live := httptest.NewServer(http.NotFoundHandler())
+	defer live.Close()
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	r := resolved(t, Latency, dead, live)
+	c := &http.Client{Transport: &Transport{
+		RoundTripper: &http.Transport{
+			DialContext: func(ctx context.Context, n, _ S) (net.Conn, error) {
+				return r.Dial(ctx, n, time.Second)
+			},
+			DisableKeepAlives: true,
+		},
+		Resolver: r,
+	}}
+	const requests = 100
+	var failed int
+	for i := 0; i < requests; i++ {
+		res, err := c.Get("http://backend/")
+		if err != nil {
+			failed++
+			continue
+		}
+		res.Body.Close()
+	}
+	if d := r.latency[dead.Listener.Addr().String()]; d != failedLatency {
+		t.Errorf("dead target latency %v, want %v", d, failedLatency)
+	}
+	if failed > requests/10 {
+		t.Errorf("%d of %d requests went to the dead target", failed,
+			requests)
+	}
+}
+
+func TestLatencyFailedRequest(t *testing.T) {
+	var hangup atomic.Bool
+	backend := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if hangup.Load() {
+				panic(http.ErrAbortHandler)
+			}
+		}))
+	defer backend.Close()
+	r := resolved(t, Latency, backend)
+	c := &http.Client{Transport: &Transport{
+		RoundTripper: &http.Transport{
+			DialContext: func(ctx context.Context, n, _ S) (net.Conn, error) {
+				return r.Dial(ctx, n, time.Second)
+			},
+		},
+		Resolver: r,
+	}}
+	addr := backend.Listener.Addr().String()
+	res, err := c.Get("http://backend/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	before := r.latency[addr]
+	hangup.Store(true)
+	if _, err = c.Get("http://backend/"); err == nil {
+		t.Fatal("aborted response succeeded")
+	}
+	if d := r.latency[addr]; d < before+time.Second {
+		t.Errorf("latency %v after a failed request, was %v", d, before)
+	}
+}
+
+func TestLookupCached(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	defer s.Close()
+	r := resolved(t, Weighted, s)
+	// expired while another lookup is running: the records are used as
+	// they are rather than queried again.
+	r.expires = time.Now().Add(-time.Second)
+	r.resolving = true
+	if addr, err := r.Pick(context.Background()); err != nil ||
+		addr != s.Listener.Addr().String() {
+		t.Errorf("Pick = %q, %v", addr, err)
+	}
+}
@@ -0,0 +1,65 @@
+package srv
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// Conn is a connection to the target at Addr.
+type Conn struct {
+	net.Conn
+	Addr S
+}
+
+// Dial picks a target and connects to it.
+func (r *Resolver) Dial(ctx context.Context, network S,
+	timeout time.Duration) (conn net.Conn, err E) {
+
+	var addr S
+	if addr, err = r.Pick(ctx); err != nil {
+		return
+	}
+	d := net.Dialer{Timeout: timeout}
+	if conn, err = d.DialContext(ctx, network, addr); err != nil {
+		if r.Strategy == Latency && ctx.Err() == nil {
+			r.Observe(addr, failedLatency)
+		}
+		return
+	}
+	return &Conn{Conn: conn, Addr: addr}, nil
+}
+
+// Transport measures how long each request takes to be answered by the
+// target its connection was dialed to with Resolver.Dial, and records it with
+// Resolver.Observe. A request that fails is recorded as taking
+// failedLatency.
+type Transport struct {
+	http.RoundTripper
+	Resolver *Resolver
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (res *http.Response, err E) {
+	var addr S
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if c, ok := info.Conn.(*Conn); ok {
+				addr = c.Addr
+			}
+		},
+	}
+	start := time.Now()
+	res, err = t.RoundTripper.RoundTrip(req.WithContext(
+		httptrace.WithClientTrace(req.Context(), trace)))
+	switch {
+	case addr == "":
+	case err == nil:
+		t.Resolver.Observe(addr, time.Since(start))
+	case req.Context().Err() == nil:
+		// not the client leaving, which says nothing about the target.
+		t.Resolver.Observe(addr, failedLatency)
+	}
+	return
+}
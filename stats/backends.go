@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// backend identifies a backend of a host. Only the backends in the mapping
+// are counted, not the addresses they resolve to, so that the number of
+// them stays bounded.
+type backend struct {
+	host, target S
+}
+
+type backendCounts struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+	// latency is the sum of the times until the response headers arrived.
+	latency atomic.Int64
+}
+
+// Backend wraps rt, the transport of the backend target of host, counting
+// the requests sent with it, those that failed or were answered with a
+// server error, and the time until their response headers arrived.
+func (s *Stats) Backend(host, target S,
+	rt http.RoundTripper) http.RoundTripper {
+
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	key := backend{host, target}
+	s.mx.Lock()
+	if s.backends == nil {
+		s.backends = make(map[backend]*backendCounts)
+	}
+	// a reload builds the transports again, keeping the counts.
+	c, ok := s.backends[key]
+	if !ok {
+		c = &backendCounts{}
+		s.backends[key] = c
+	}
+	s.mx.Unlock()
+	return &backendTransport{rt, c}
+}
+
+type backendTransport struct {
+	http.RoundTripper
+	counts *backendCounts
+}
+
+func (t *backendTransport) RoundTrip(req *http.Request) (res *http.Response,
+	err E) {
+
+	start := time.Now()
+	res, err = t.RoundTripper.RoundTrip(req)
+	t.counts.requests.Add(1)
+	t.counts.latency.Add(int64(time.Since(start)))
+	if err != nil || res.StatusCode >= 500 {
+		t.counts.errors.Add(1)
+	}
+	return
+}
+
+// writeBackends writes the counts of each backend as lines labeled with
+// its host and target. s.mx must be held.
+func (s *Stats) writeBackends(w http.ResponseWriter) {
+	keys := make([]backend, 0, len(s.backends))
+	for k := range s.backends {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].target < keys[j].target
+	})
+	for _, k := range keys {
+		c := s.backends[k]
+		labels := fmt.Sprintf("{host=%q,backend=%q}", k.host, k.target)
+		fmt.Fprintf(w, "backend_requests_total%s=%d\n", labels,
+			c.requests.Load())
+		fmt.Fprintf(w, "backend_errors_total%s=%d\n", labels,
+			c.errors.Load())
+		fmt.Fprintf(w, "backend_latency_seconds_sum%s=%.3f\n", labels,
+			time.Duration(c.latency.Load()).Seconds())
+	}
+}
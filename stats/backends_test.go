@@ -0,0 +1,54 @@
+package stats
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// status is a RoundTripper answering with a status, or failing if it is 0.
+type status int
+
+func (s status) RoundTrip(req *http.Request) (*http.Response, error) {
+	if s == 0 {
+		return nil, errors.New("connection refused")
+	}
+	return &http.Response{StatusCode: int(s), Body: http.NoBody}, nil
+}
+
+func TestBackend(t *testing.T) {
+	s := &Stats{}
+	send := func(rt http.RoundTripper, n int) {
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest(http.MethodGet, "http://backend/", nil)
+			if res, err := rt.RoundTrip(req); err == nil {
+				res.Body.Close()
+			}
+		}
+	}
+	send(s.Backend("a.test", "http://a:8080", status(200)), 3)
+	send(s.Backend("a.test", "http://a:8080", status(502)), 1)
+	send(s.Backend("b.test", "/run/b.sock", status(0)), 2)
+	// a reload builds the transport again, adding to the same counts.
+	send(s.Backend("b.test", "/run/b.sock", status(200)), 1)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	out := w.Body.String()
+	for _, want := range []S{
+		`backend_requests_total{host="a.test",backend="http://a:8080"}=4`,
+		`backend_errors_total{host="a.test",backend="http://a:8080"}=1`,
+		`backend_requests_total{host="b.test",backend="/run/b.sock"}=3`,
+		`backend_errors_total{host="b.test",backend="/run/b.sock"}=2`,
+		`backend_latency_seconds_sum{host="a.test",backend="http://a:8080"}=`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("stats do not contain %s:\n%s", want, out)
+		}
+	}
+	if a, b := strings.Index(out, `host="a.test"`),
+		strings.Index(out, `host="b.test"`); a < 0 || b < a {
+		t.Errorf("backends not sorted by host:\n%s", out)
+	}
+}
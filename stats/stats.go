@@ -0,0 +1,175 @@
+// Package stats counts requests, responses and connections and reports them
+// along with certificate expiry as plain text key=value lines, without
+// needing a metrics library.
+package stats
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the counters. The zero value is ready to use.
+type Stats struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+	active   atomic.Int64
+	mx       sync.Mutex
+	status   map[int]int64
+	expiry   map[S]time.Time
+	backends map[backend]*backendCounts
+}
+
+// Handler counts the requests served by h and the status of their responses.
+func (s *Stats) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		rw := &Writer{ResponseWriter: w}
+		h.ServeHTTP(rw, r)
+		s.count(rw.Status())
+	})
+}
+
+func (s *Stats) count(status int) {
+	if status >= 500 {
+		s.errors.Add(1)
+	}
+	s.mx.Lock()
+	if s.status == nil {
+		s.status = make(map[int]int64)
+	}
+	s.status[status]++
+	s.mx.Unlock()
+}
+
+// Totals returns the number of requests and of those answered with a server
+// error since startup, and the number of open connections.
+func (s *Stats) Totals() (requests, errors, active int64) {
+	return s.requests.Load(), s.errors.Load(), s.active.Load()
+}
+
+// ConnState tracks the number of open connections, for use as
+// http.Server.ConnState.
+func (s *Stats) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		s.active.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		s.active.Add(-1)
+	}
+}
+
+// GetCertificate wraps a tls.Config.GetCertificate function, recording the
+// expiry of the certificates it returns under their name. It is not kept by
+// the server name the client sent, which with wildcard certificates or
+// routes would let clients add entries without limit.
+func (s *Stats) GetCertificate(get func(*tls.ClientHelloInfo) (
+	*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate,
+	error) {
+
+	return func(hello *tls.ClientHelloInfo) (cert *tls.Certificate, err E) {
+		if cert, err = get(hello); err != nil || cert == nil {
+			return
+		}
+		leaf := cert.Leaf
+		if leaf == nil && len(cert.Certificate) > 0 {
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				// the handshake still goes ahead with the certificate.
+				err = nil
+				return
+			}
+		}
+		if name := certName(leaf); name != "" {
+			s.mx.Lock()
+			if s.expiry == nil {
+				s.expiry = make(map[S]time.Time)
+			}
+			s.expiry[name] = leaf.NotAfter
+			s.mx.Unlock()
+		}
+		return
+	}
+}
+
+// certName is the name the expiry of leaf is reported under, its first DNS
+// name, or else its common name, so that a renewed certificate replaces the
+// one before it.
+func certName(leaf *x509.Certificate) S {
+	switch {
+	case leaf == nil:
+		return ""
+	case len(leaf.DNSNames) > 0:
+		return leaf.DNSNames[0]
+	}
+	return leaf.Subject.CommonName
+}
+
+// ServeHTTP writes the current values as key=value lines.
+func (s *Stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "requests_total=%d\n", s.requests.Load())
+	fmt.Fprintf(w, "connections_active=%d\n", s.active.Load())
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	codes := make([]int, 0, len(s.status))
+	for code := range s.status {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "responses_status_%d=%d\n", code, s.status[code])
+	}
+	hosts := make([]S, 0, len(s.expiry))
+	for host := range s.expiry {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	now := time.Now()
+	for _, host := range hosts {
+		fmt.Fprintf(w, "cert_expiry_days.%s=%d\n", host,
+			int(s.expiry[host].Sub(now).Hours()/24))
+	}
+	s.writeBackends(w)
+}
+
+// Writer records the status code written through it.
+type Writer struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *Writer) WriteHeader(code int) {
+	// informational responses are followed by the real one.
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *Writer) Write(b B) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Status is the status code of the response, 200 if none was written.
+func (w *Writer) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so that
+// flushing and hijacking keep working.
+func (w *Writer) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// Flush implements http.Flusher for code that asserts it directly.
+func (w *Writer) Flush() { _ = http.NewResponseController(w.ResponseWriter).Flush() }
@@ -0,0 +1,73 @@
+package stats
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cert returns a certificate for names expiring after days.
+func cert(t *testing.T, days int, names ...S) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an hour more, so that the whole days left are days.
+	expiry := time.Now().Add(time.Duration(days)*24*time.Hour + time.Hour)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     expiry,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey,
+		key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestCertExpiry(t *testing.T) {
+	wildcard := cert(t, 30, "*.example.com", "example.com")
+	other := cert(t, 60, "other.test")
+	var s Stats
+	get := s.GetCertificate(func(hello *tls.ClientHelloInfo) (
+		*tls.Certificate, error) {
+
+		if hello.ServerName == "other.test" {
+			return other, nil
+		}
+		return wildcard, nil
+	})
+	// any number of names a client makes up under the wildcard.
+	for _, name := range []S{"a.example.com", "b.example.com", "example.com",
+		"x1.example.com", "other.test", ""} {
+		if _, err := get(&tls.ClientHelloInfo{ServerName: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	var lines []S
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "cert_expiry_days.") {
+			lines = append(lines, line)
+		}
+	}
+	want := []S{"cert_expiry_days.*.example.com=30",
+		"cert_expiry_days.other.test=60"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
@@ -0,0 +1,33 @@
+// Package swap provides an http.Handler whose underlying handler can be
+// replaced while the server is running.
+package swap
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Handler forwards requests to the most recently stored handler. Requests
+// already in flight keep using the handler they started with.
+type Handler struct {
+	h atomic.Pointer[http.Handler]
+}
+
+// New creates a Handler initially serving h.
+func New(h http.Handler) (s *Handler) {
+	s = &Handler{}
+	s.Store(h)
+	return
+}
+
+// Store replaces the handler used for subsequent requests.
+func (s *Handler) Store(h http.Handler) { s.h.Store(&h) }
+
+func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := s.h.Load()
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	(*h).ServeHTTP(w, r)
+}
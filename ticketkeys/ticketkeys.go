@@ -0,0 +1,105 @@
+// Package ticketkeys rotates the keys TLS session tickets are encrypted
+// with, so that a key that leaks only exposes the sessions of a limited
+// time, and so that instances behind a load balancer can share keys.
+package ticketkeys
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"lerproxy.mleku.dev/secret"
+)
+
+// Rotator replaces the session ticket keys of Config every Interval. The
+// newest key encrypts new tickets, and the previous one is kept so tickets
+// issued before a rotation can still resume sessions.
+type Rotator struct {
+	Config   *tls.Config
+	Interval time.Duration
+	// File, if set, is read for the keys instead of generating them, so
+	// that several instances can share keys rotated by an external process.
+	// It holds one hex encoded 32 byte key per line, newest first.
+	File S
+
+	keys [][32]byte
+}
+
+// Run rotates the keys every Interval until ctx is done. Rotate sets the
+// first ones.
+func (r *Rotator) Run(ctx context.Context) (err E) {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			// a failed rotation keeps the previous keys in use.
+			chk.E(r.Rotate())
+		}
+	}
+}
+
+// Rotate replaces the keys once.
+func (r *Rotator) Rotate() (err E) {
+	var keys [][32]byte
+	if r.File != "" {
+		if keys, err = Read(r.File); err != nil {
+			return
+		}
+	} else {
+		var key [32]byte
+		if _, err = rand.Read(key[:]); err != nil {
+			return
+		}
+		keys = [][32]byte{key}
+		if len(r.keys) > 0 {
+			keys = append(keys, r.keys[0])
+		}
+	}
+	r.Config.SetSessionTicketKeys(keys)
+	r.keys = keys
+	log.D.F("rotated TLS session ticket keys, %d in use", len(keys))
+	return
+}
+
+// Read reads hex encoded 32 byte keys from the lines of file, ignoring empty
+// lines and those starting with #.
+func Read(file S) (keys [][32]byte, err E) {
+	secret.CheckPermissions(file)
+	var f *os.File
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var b B
+		if b, err = hex.DecodeString(line); err != nil || len(b) != 32 {
+			err = fmt.Errorf("%s:%d: session ticket key is not 32 hex "+
+				"encoded bytes", file, n)
+			return
+		}
+		var key [32]byte
+		copy(key[:], b)
+		keys = append(keys, key)
+	}
+	if err = sc.Err(); err != nil {
+		return
+	}
+	if len(keys) == 0 {
+		err = fmt.Errorf("no session ticket keys in %s", file)
+	}
+	return
+}
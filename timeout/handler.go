@@ -0,0 +1,34 @@
+package timeout
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Handler bounds the time reading each request and writing its response may
+// take, like the ReadTimeout and WriteTimeout of http.Server but counted from
+// when Handler is called, replacing those of the server for the requests it
+// handles. A zero duration leaves the server's in effect.
+type Handler struct {
+	http.Handler
+	Read, Write time.Duration
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	now := time.Now()
+	if h.Read > 0 {
+		deadline(rc.SetReadDeadline(now.Add(h.Read)))
+	}
+	if h.Write > 0 {
+		deadline(rc.SetWriteDeadline(now.Add(h.Write)))
+	}
+	h.Handler.ServeHTTP(w, r)
+}
+
+func deadline(err E) {
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.D.Ln("setting deadline:", err)
+	}
+}
@@ -0,0 +1,63 @@
+// Package tracing creates an OpenTelemetry span for each request and for
+// each request to a backend, propagating the W3C traceparent and tracestate
+// headers, and exports them to a collector over OTLP/HTTP.
+//
+// Nothing is wrapped or exported unless Setup is called, so tracing costs
+// nothing when it is not configured.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Setup exports spans to the collector at endpoint, a URL such as
+// http://localhost:4318 or a host:port that is reached over https. The
+// returned shutdown flushes the spans not yet exported.
+func Setup(ctx context.Context, endpoint S) (
+	shutdown func(context.Context) error, err E) {
+
+	opt := otlptracehttp.WithEndpoint(endpoint)
+	if strings.Contains(endpoint, "://") {
+		opt = otlptracehttp.WithEndpointURL(endpoint)
+	}
+	var exporter *otlptrace.Exporter
+	if exporter, err = otlptracehttp.New(ctx, opt); err != nil {
+		return
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "lerproxy"))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	log.I.Ln("exporting traces to", endpoint)
+	return tp.Shutdown, nil
+}
+
+// Handler creates a span for each request served by h, continuing the trace
+// of an incoming traceparent header.
+func Handler(h http.Handler) http.Handler {
+	return otelhttp.NewHandler(h, "lerproxy",
+		otelhttp.WithSpanNameFormatter(func(_ S, r *http.Request) S {
+			return r.Method + " " + r.Host
+		}))
+}
+
+// Transport creates a span for each request made with rt, a backend
+// transport, and adds the traceparent header for the backend to continue
+// the trace.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(rt)
+}
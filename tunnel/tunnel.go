@@ -0,0 +1,144 @@
+// Package tunnel answers HTTP CONNECT requests by relaying the bytes of the
+// connection to a target from a fixed list, making a host a forward proxy for
+// internal tunneling rather than a reverse proxy.
+package tunnel
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Handler tunnels CONNECT requests to the targets in Allow. Requests with
+// other methods are answered with 405, and those for other targets with 403.
+type Handler struct {
+	// Allow are the host:port targets that may be connected to, compared
+	// ignoring case.
+	Allow []S
+	// DialTimeout bounds connecting to the target. Zero leaves it to the
+	// system.
+	DialTimeout time.Duration
+}
+
+// Allowed reports whether target is in the Allow list.
+func (h *Handler) Allowed(target S) bool {
+	for _, a := range h.Allow {
+		if strings.EqualFold(a, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.Header().Set("Allow", http.MethodConnect)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed),
+			http.StatusMethodNotAllowed)
+		return
+	}
+	// the request target of CONNECT is the authority alone, which is the
+	// Host.
+	target := r.Host
+	if !h.Allowed(target) {
+		log.W.F("refusing tunnel to %s from %s", target, r.RemoteAddr)
+		http.Error(w, "tunnel target not allowed", http.StatusForbidden)
+		return
+	}
+	upstream, err := net.DialTimeout("tcp", target, h.DialTimeout)
+	if err != nil {
+		log.W.F("tunnel to %s from %s failed: %v", target, r.RemoteAddr, err)
+		http.Error(w, "cannot reach tunnel target", http.StatusBadGateway)
+		return
+	}
+	log.D.F("tunnel to %s from %s opened", target, r.RemoteAddr)
+	rc := http.NewResponseController(w)
+	if r.ProtoMajor == 1 {
+		h.hijacked(w, rc, upstream)
+	} else {
+		h.stream(w, r, rc, upstream)
+	}
+	log.D.F("tunnel to %s from %s closed", target, r.RemoteAddr)
+}
+
+// hijacked relays an HTTP/1 tunnel, which takes over the connection.
+func (h *Handler) hijacked(w http.ResponseWriter, rc *http.ResponseController,
+	upstream net.Conn) {
+
+	conn, brw, err := rc.Hijack()
+	if err != nil {
+		upstream.Close()
+		log.E.Ln("cannot take over connection for tunnel:", err)
+		http.Error(w, "tunnel not supported", http.StatusInternalServerError)
+		return
+	}
+	// the timeouts of the server are for requests, not tunnels.
+	chk.E(conn.SetDeadline(time.Time{}))
+	if _, err = io.WriteString(conn,
+		"HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	// bytes the client sent along with the request are already buffered.
+	relay(upstream, io.MultiReader(brw.Reader, conn), conn, conn)
+}
+
+// stream relays an HTTP/2 tunnel, which is the body of the request and of the
+// response of a stream.
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request,
+	rc *http.ResponseController, upstream net.Conn) {
+
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		upstream.Close()
+		return
+	}
+	relay(upstream, r.Body, &flusher{w, rc}, r.Body)
+}
+
+// relay copies from the client to upstream and back until either side is
+// done, then closes both.
+func relay(upstream net.Conn, in io.Reader, out io.Writer, client io.Closer) {
+	var once sync.Once
+	done := func() {
+		once.Do(func() {
+			upstream.Close()
+			client.Close()
+		})
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		defer done()
+		_, _ = io.Copy(upstream, in)
+	}()
+	go func() {
+		defer wg.Done()
+		defer done()
+		_, _ = io.Copy(out, upstream)
+	}()
+	wg.Wait()
+}
+
+// flusher flushes each write to an HTTP/2 stream, so that the bytes are sent
+// as they arrive.
+type flusher struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f *flusher) Write(p B) (n int, err E) {
+	if n, err = f.w.Write(p); err != nil {
+		return
+	}
+	err = f.rc.Flush()
+	return
+}
@@ -0,0 +1,143 @@
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echo starts a target that greets each connection, then echoes what it
+// reads, and returns its address.
+func echo(t *testing.T) S {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.WriteString(c, "hello\n")
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// connect sends a CONNECT request for target to the server at addr and
+// returns the connection and its response.
+func connect(t *testing.T, addr, target S) (c net.Conn, br *bufio.Reader,
+	res *http.Response) {
+
+	t.Helper()
+	var err error
+	if c, err = net.Dial("tcp", addr); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+	// bytes sent right after the request go through the tunnel too.
+	if _, err = io.WriteString(c, "CONNECT "+target+" HTTP/1.1\r\n"+
+		"Host: "+target+"\r\n\r\nearly\n"); err != nil {
+		t.Fatal(err)
+	}
+	br = bufio.NewReader(c)
+	if res, err = http.ReadResponse(br, nil); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func readLine(t *testing.T, br *bufio.Reader) S {
+	t.Helper()
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return line
+}
+
+func TestTunnel(t *testing.T) {
+	target := echo(t)
+	srv := httptest.NewServer(&Handler{Allow: []S{strings.ToUpper(target)},
+		DialTimeout: time.Second})
+	defer srv.Close()
+	c, br, res := connect(t, srv.Listener.Addr().String(), target)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", res.StatusCode)
+	}
+	for _, want := range []S{"hello\n", "early\n"} {
+		if got := readLine(t, br); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+	if _, err := io.WriteString(c, "ping\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLine(t, br); got != "ping\n" {
+		t.Errorf("got %q, want ping", got)
+	}
+}
+
+func TestTunnelHTTP2(t *testing.T) {
+	target := echo(t)
+	srv := httptest.NewUnstartedServer(&Handler{Allow: []S{target}})
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	u, _ := url.Parse(srv.URL)
+	res, err := srv.Client().Do(&http.Request{Method: http.MethodConnect,
+		URL: u, Host: target, Header: http.Header{}, Body: pr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.ProtoMajor != 2 {
+		t.Fatalf("got %s over HTTP/%d", res.Status, res.ProtoMajor)
+	}
+	br := bufio.NewReader(res.Body)
+	if got := readLine(t, br); got != "hello\n" {
+		t.Errorf("got %q, want hello", got)
+	}
+	if _, err = io.WriteString(pw, "ping\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readLine(t, br); got != "ping\n" {
+		t.Errorf("got %q, want ping", got)
+	}
+}
+
+func TestTunnelRejected(t *testing.T) {
+	target := echo(t)
+	srv := httptest.NewServer(&Handler{Allow: []S{target}})
+	defer srv.Close()
+	_, _, res := connect(t, srv.Listener.Addr().String(), "127.0.0.1:1")
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("disallowed target: status %d, want 403", res.StatusCode)
+	}
+	for _, method := range []S{http.MethodGet, http.MethodPost} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "http://"+target+"/", nil)
+		(&Handler{Allow: []S{target}}).ServeHTTP(w, r)
+		if w.Code != http.StatusMethodNotAllowed ||
+			w.Header().Get("Allow") != http.MethodConnect {
+			t.Errorf("%s: status %d, Allow %q", method, w.Code,
+				w.Header().Get("Allow"))
+		}
+	}
+}
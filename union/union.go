@@ -0,0 +1,32 @@
+// Package union merges several directories into one file system, such as
+// the assets shared by sites with the files of one of them.
+package union
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+)
+
+// FileSystem opens a name from the first of its file systems that has it.
+// A directory is listed from the first that has it too, without the entries
+// of the others.
+type FileSystem []http.FileSystem
+
+// Dirs returns the FileSystem of dirs, searched in order.
+func Dirs(dirs ...S) (f FileSystem) {
+	for _, d := range dirs {
+		f = append(f, http.Dir(d))
+	}
+	return
+}
+
+func (f FileSystem) Open(name S) (file http.File, err E) {
+	err = fs.ErrNotExist
+	for _, sub := range f {
+		if file, err = sub.Open(name); !errors.Is(err, fs.ErrNotExist) {
+			return
+		}
+	}
+	return
+}
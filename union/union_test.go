@@ -0,0 +1,49 @@
+package union
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func write(t *testing.T, dir, name, content S) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirs(t *testing.T) {
+	site, shared := t.TempDir(), t.TempDir()
+	write(t, site, "index.html", "site index")
+	write(t, site, "style.css", "site style")
+	write(t, shared, "style.css", "shared style")
+	write(t, shared, "assets/logo.svg", "shared logo")
+	h := http.FileServer(Dirs(site, shared))
+	for _, tc := range []struct {
+		path   S
+		status int
+		body   S
+	}{
+		{"/", http.StatusOK, "site index"},
+		{"/style.css", http.StatusOK, "site style"},
+		{"/assets/logo.svg", http.StatusOK, "shared logo"},
+		{"/missing.txt", http.StatusNotFound, ""},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("%s: status %d, want %d", tc.path, w.Code, tc.status)
+		}
+		if tc.body != "" && w.Body.String() != tc.body {
+			t.Errorf("%s: body %q, want %q", tc.path, w.Body.String(),
+				tc.body)
+		}
+	}
+}
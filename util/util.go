@@ -1,15 +1,24 @@
 package util
 
-import "strings"
+import (
+	"sort"
+	"strings"
+)
 
+// GetKeys returns the keys of m in sorted order, so that anything derived
+// from them, such as the host whitelist and log output, is deterministic.
 func GetKeys(m map[string]string) []string {
 	out := make([]string, 0, len(m))
 	for k := range m {
 		out = append(out, k)
 	}
+	sort.Strings(out)
 	return out
 }
 
+// SingleJoiningSlash joins a and b with exactly one slash between them. Only
+// the slashes at the join are merged, other repeated slashes, such as those
+// of a client's path "//foo", are kept as they may matter to the backend.
 func SingleJoiningSlash(a, b string) string {
 	suffixSlash := strings.HasSuffix(a, "/")
 	prefixSlash := strings.HasPrefix(b, "/")
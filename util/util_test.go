@@ -0,0 +1,52 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSingleJoiningSlash(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, want string
+	}{
+		{"", "", "/"},
+		{"", "/", "/"},
+		{"/", "", "/"},
+		{"/", "/", "/"},
+		{"", "b", "/b"},
+		{"a", "b", "a/b"},
+		{"a/", "b", "a/b"},
+		{"a", "/b", "a/b"},
+		{"a/", "/b", "a/b"},
+		{"a", "//b", "a//b"},
+		{"a/", "//b", "a//b"},
+		{"/", "//b", "//b"},
+		{"/base/", "/b?x=/y", "/base/b?x=/y"},
+		{"/bäse", "ü/", "/bäse/ü/"},
+	} {
+		if got := SingleJoiningSlash(tc.a, tc.b); got != tc.want {
+			t.Errorf("SingleJoiningSlash(%q, %q) = %q, want %q", tc.a,
+				tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestGetKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		m    map[string]string
+		want []string
+	}{
+		{"nil", nil, []string{}},
+		{"empty", map[string]string{}, []string{}},
+		{"sorted", map[string]string{"b.com": "1", "a.com": "2",
+			"c.com": "3", "bücher.de": "4"},
+			[]string{"a.com", "b.com", "bücher.de", "c.com"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := GetKeys(tc.m); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("GetKeys = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
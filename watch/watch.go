@@ -0,0 +1,57 @@
+// Package watch calls a function when a file changes, such as to reload the
+// mapping when a configuration management tool writes it.
+package watch
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// File calls changed each time file has been written, created, renamed or
+// removed, once no further change has come for delay, so that a burst of
+// writes is seen as one. It returns when ctx is done.
+//
+// The directory of file is watched rather than file itself, so that the
+// file being replaced by renaming another over it, as editors and
+// configuration management tools do to write it atomically, is seen too.
+func File(ctx context.Context, file S, delay time.Duration,
+	changed func()) (err E) {
+
+	var w *fsnotify.Watcher
+	if w, err = fsnotify.NewWatcher(); chk.E(err) {
+		return
+	}
+	defer w.Close()
+	file = filepath.Clean(file)
+	if err = w.Add(filepath.Dir(file)); chk.E(err) {
+		return
+	}
+	timer := time.NewTimer(delay)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != file || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			log.D.Ln("watched file changed:", ev)
+			timer.Reset(delay)
+		case werr, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.E.F("watching %s: %v", file, werr)
+		case <-timer.C:
+			changed()
+		}
+	}
+}